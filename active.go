@@ -0,0 +1,121 @@
+package conversion
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Reduces an array to the elements that reference rows name as in use, e.g. the detector of
+// the acquisition among all installed ones an EPU session lists. With Options.Inventory the
+// complete array is kept under instrument.inventory, named like the array. Provenance
+// records follow their elements.
+//
+// Parameters:
+//   - result: The converted document
+//   - arrayPath: "." separated path of the array
+//   - active: The positions, or keys of a keyed array, of the elements to keep
+//
+// Returns:
+//   - error: If the inventory collides with the output of a row
+func (c *converter) keepActiveElements(result map[string]interface{}, arrayPath string, active map[string]bool) error {
+	segments := strings.Split(arrayPath, ".")
+	parent := result
+	if len(segments) > 1 {
+		var ok bool
+		if parent, ok = lookupPath(result, segments[:len(segments)-1]).(map[string]interface{}); !ok {
+			return nil
+		}
+	}
+	name := segments[len(segments)-1]
+	inventoryPath := []string{"instrument", "inventory", name}
+	if c.inventory {
+		if err := insertNested(result, inventoryPath, copyTree(parent[name])); err != nil {
+			return fmt.Errorf("cannot keep the inventory of %s: %w", arrayPath, err)
+		}
+	}
+
+	// the new position of each kept element, and the identifiers of the dropped ones
+	positions := make(map[string]string)
+	dropped := make(map[string]bool)
+	switch elements := parent[name].(type) {
+	case []interface{}:
+		var kept []interface{}
+		for i, element := range elements {
+			if active[strconv.Itoa(i)] {
+				positions[strconv.Itoa(i)] = strconv.Itoa(len(kept))
+				kept = append(kept, element)
+			} else if id := c.elementIDs[arrayPath][i]; id != "" {
+				dropped[id] = true
+			}
+		}
+		parent[name] = kept
+	case map[string]interface{}:
+		for id := range elements {
+			if !active[id] {
+				delete(elements, id)
+				dropped[id] = true
+			}
+		}
+	}
+	c.moveElementProvenance(arrayPath, strings.Join(inventoryPath, "."), positions, dropped)
+	return nil
+}
+
+// Updates the provenance records of a reduced array: records of kept elements move to
+// their new position, those of dropped elements are removed, and with an inventory all of
+// them are copied to it unchanged.
+func (c *converter) moveElementProvenance(arrayPath string, inventoryPath string, positions map[string]string, dropped map[string]bool) {
+	if c.provenance == nil {
+		return
+	}
+	prefix := arrayPath + "["
+	moved := make(map[string]provenanceRecord)
+	for path, record := range c.provenance {
+		if !strings.HasPrefix(path, prefix) {
+			continue
+		}
+		end := strings.Index(path[len(prefix):], "]")
+		if end < 0 {
+			continue
+		}
+		index, rest := path[len(prefix):len(prefix)+end], path[len(prefix)+end:]
+		if c.inventory {
+			moved[inventoryPath+"["+index+rest] = record
+		}
+		if _, err := strconv.Atoi(index); err != nil {
+			// recorded under the identifier of an element of a dynamic array
+			if dropped[index] {
+				delete(c.provenance, path)
+			}
+			continue
+		}
+		delete(c.provenance, path)
+		if position, kept := positions[index]; kept {
+			moved[prefix+position+rest] = record
+		}
+	}
+	for path, record := range moved {
+		c.provenance[path] = record
+	}
+}
+
+// Returns a copy of a document subtree whose objects and arrays are not shared with it, so
+// later processing of one does not change the other.
+func copyTree(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(v))
+		for key, element := range v {
+			copied[key] = copyTree(element)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(v))
+		for i, element := range v {
+			copied[i] = copyTree(element)
+		}
+		return copied
+	}
+	return value
+}
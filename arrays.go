@@ -1,6 +1,7 @@
 package conversion
 
 import (
+	"fmt"
 	"regexp"
 	"sort"
 	"strings"
@@ -14,9 +15,12 @@ import (
 //   - result: The target map where processed arrays will be added
 //   - dynamicFieldPatterns: CSV mapping rows containing [N] notation patterns
 //   - input: The input data map with field names as keys and values as strings
-func processDynamicArrayFields(result map[string]interface{}, dynamicFieldPatterns []csvextract, input map[string]string) {
+//
+// Returns:
+//   - error: If two patterns collide within an array element
+func (c *converter) processDynamicArrayFields(result map[string]interface{}, dynamicFieldPatterns []csvextract, input map[string]string) error {
 	if len(dynamicFieldPatterns) == 0 {
-		return
+		return nil
 	}
 	// Group patterns by their common prefixes (everything before [N])
 	prefixGroups := make(map[string][]csvextract)
@@ -27,16 +31,55 @@ func processDynamicArrayFields(result map[string]interface{}, dynamicFieldPatter
 			prefixGroups[prefix] = append(prefixGroups[prefix], pattern)
 		}
 	}
-	inputs := groupArrayInputs(input, prefixGroups)
+	inputs := c.groupArrayInputs(input, prefixGroups)
 	if len(inputs) == 0 {
-		return
+		return nil
+	}
+	prefixes := make([]string, 0, len(inputs))
+	for prefix := range inputs {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	for _, prefix := range prefixes {
+		if err := c.limits.checkArray(prefix+"[N]", len(inputs[prefix])); err != nil {
+			return err
+		}
+	}
+	processedArrays, identifiers, err := c.processEachArrayType(inputs, dynamicFieldPatterns)
+	if err != nil {
+		return err
 	}
-	processedArrays := processEachArrayType(inputs, dynamicFieldPatterns)
 
-	// Add arrays to result
-	for arrayPath, arrayData := range processedArrays {
-		addItemsToArrayPath(result, arrayData, arrayPath)
+	// Add arrays to result, in a fixed order so a collision is reported the same way on every run
+	arrayPaths := make([]string, 0, len(processedArrays))
+	for arrayPath := range processedArrays {
+		arrayPaths = append(arrayPaths, arrayPath)
 	}
+	sort.Strings(arrayPaths)
+	for _, arrayPath := range arrayPaths {
+		// the elements are appended to those of regular rows
+		existing, _ := lookupPath(result, strings.Split(arrayPath, ".")).([]interface{})
+		if collision := addItemsToArrayPath(result, processedArrays[arrayPath], arrayPath); collision != nil {
+			return c.collisionError("", collision, patternForArray(dynamicFieldPatterns, arrayPath))
+		}
+		for i, id := range identifiers[arrayPath] {
+			if c.elementIDs[arrayPath] == nil {
+				c.elementIDs[arrayPath] = make(map[int]string)
+			}
+			c.elementIDs[arrayPath][len(existing)+i] = id
+		}
+	}
+	return nil
+}
+
+// Returns the first pattern writing to the given array, to name it in error messages.
+func patternForArray(dynamicFieldPatterns []csvextract, arrayPath string) csvextract {
+	for _, row := range dynamicFieldPatterns {
+		if strings.HasPrefix(row.OSCEM, arrayPath+"[N]") {
+			return row
+		}
+	}
+	return csvextract{OSCEM: arrayPath + "[N]"}
 }
 
 // Extracts the appropriate field pattern from a CSV mapping row, based on priority.
@@ -62,7 +105,7 @@ func getFieldPattern(row csvextract) string {
 //
 // Returns:
 //   - Nested structure organized by array path and index
-func groupArrayInputs(input map[string]string, prefixGroups map[string][]csvextract) map[string]map[string]map[string]string {
+func (c *converter) groupArrayInputs(input map[string]string, prefixGroups map[string][]csvextract) map[string]map[string]map[string]string {
 	inputs := make(map[string]map[string]map[string]string)
 
 	for _, patterns := range prefixGroups {
@@ -82,11 +125,10 @@ func groupArrayInputs(input map[string]string, prefixGroups map[string][]csvextr
 			if fieldPattern == "" {
 				continue
 			}
-			regexPattern := convertPatternToRegex(fieldPattern)
-			if regexPattern == "" {
+			if !strings.Contains(fieldPattern, "[N]") {
 				continue
 			}
-			regex := regexp.MustCompile(regexPattern)
+			regex := c.patternRegexp(fieldPattern)
 			for inputKey, inputValue := range input {
 				if matches := regex.FindStringSubmatch(inputKey); len(matches) >= 2 {
 					arrayIndex := matches[1]
@@ -111,18 +153,24 @@ func groupArrayInputs(input map[string]string, prefixGroups map[string][]csvextr
 // It takes the nested input structure organized by array path and index, processes
 // each array element individually, and returns a map of complete arrays ready to
 // be added to the result. Array indices are sorted to ensure consistent ordering.
+// Arrays that a pattern marks as keyed are returned as objects keyed by index instead.
 //
 // Parameters:
 //   - inputs: Nested map structure: arrayPath -> arrayIndex -> inputData
 //   - dynamicFieldPatterns: CSV mapping patterns for processing individual elements
 //
 // Returns:
-//   - map[string][]interface{}: Map of array paths to their processed array data
-func processEachArrayType(inputs map[string]map[string]map[string]string, dynamicFieldPatterns []csvextract) map[string][]interface{} {
-	arrayResults := make(map[string][]interface{})
+//   - map[string]interface{}: Map of array paths to their processed array data, a []interface{} or a keyed map[string]interface{}
+//   - map[string][]string: The identifiers captured for [N] of the elements of each positional array, in order
+//   - error: If two patterns collide within an array element
+func (c *converter) processEachArrayType(inputs map[string]map[string]map[string]string, dynamicFieldPatterns []csvextract) (map[string]interface{}, map[string][]string, error) {
+	arrayResults := make(map[string]interface{})
+	identifiers := make(map[string][]string)
 
 	for arrayPath, arrayIndices := range inputs {
 		var arrayData []interface{}
+		keyed := isKeyedArray(dynamicFieldPatterns, arrayPath)
+		keyedData := make(map[string]interface{})
 
 		// Sort indices for consistent order
 		sortedIndices := make([]string, 0, len(arrayIndices))
@@ -134,18 +182,38 @@ func processEachArrayType(inputs map[string]map[string]map[string]string, dynami
 		// Process each array index
 		for _, index := range sortedIndices {
 			inputData := arrayIndices[index]
-			processedElement := processSingleInput(inputData, dynamicFieldPatterns)
+			processedElement, err := c.processSingleInput(inputData, dynamicFieldPatterns, arrayPath+"["+index+"]")
+			if err != nil {
+				return nil, nil, err
+			}
 			if len(processedElement) > 0 {
-				arrayData = append(arrayData, processedElement)
+				if keyed {
+					keyedData[index] = processedElement
+				} else {
+					arrayData = append(arrayData, processedElement)
+					identifiers[arrayPath] = append(identifiers[arrayPath], index)
+				}
 			}
 		}
 
-		if len(arrayData) > 0 {
+		if keyed && len(keyedData) > 0 {
+			arrayResults[arrayPath] = keyedData
+		} else if len(arrayData) > 0 {
 			arrayResults[arrayPath] = arrayData
 		}
 	}
 
-	return arrayResults
+	return arrayResults, identifiers, nil
+}
+
+// Reports whether any pattern writing to the given array asks for it to be keyed by index.
+func isKeyedArray(dynamicFieldPatterns []csvextract, arrayPath string) bool {
+	for _, row := range dynamicFieldPatterns {
+		if row.ArrayKeyed && strings.HasPrefix(row.OSCEM, arrayPath+"[N]") {
+			return true
+		}
+	}
+	return false
 }
 
 // Processes a single array element from input data.
@@ -156,10 +224,12 @@ func processEachArrayType(inputs map[string]map[string]map[string]string, dynami
 // Parameters:
 //   - input: Input data for a single array element (one index)
 //   - dynamicFieldPatterns: CSV mapping patterns containing [N] notation
+//   - base: Output path of the element, used in messages
 //
 // Returns:
 //   - map[string]interface{}: Processed object representing one array element
-func processSingleInput(input map[string]string, dynamicFieldPatterns []csvextract) map[string]interface{} {
+//   - error: If two patterns collide within the element
+func (c *converter) processSingleInput(input map[string]string, dynamicFieldPatterns []csvextract, base string) (map[string]interface{}, error) {
 	singleInput := make(map[string]interface{})
 
 	for _, row := range dynamicFieldPatterns {
@@ -170,11 +240,10 @@ func processSingleInput(input map[string]string, dynamicFieldPatterns []csvextra
 		if fieldPattern == "" {
 			continue
 		}
-		regexPattern := convertPatternToRegex(fieldPattern)
-		if regexPattern == "" {
+		if !strings.Contains(fieldPattern, "[N]") {
 			continue
 		}
-		regex := regexp.MustCompile(regexPattern)
+		regex := c.patternRegexp(fieldPattern)
 
 		for inputKey, inputValue := range input {
 			if matches := regex.FindStringSubmatch(inputKey); len(matches) >= 2 {
@@ -182,20 +251,50 @@ func processSingleInput(input map[string]string, dynamicFieldPatterns []csvextra
 				if propertyName == "" {
 					continue
 				}
-				// Apply unit conversion using priority-based crunch factor
-				crunchFactor := getCrunchFactor(row)
-				value := processValue(inputValue, crunchFactor, row)
+				// Apply unit conversion using priority-based crunch factor, which may be read from the element
+				crunchFactor := c.elementCrunchFactor(getCrunchFactor(row), matches[1], row)
+				value := c.processValue(inputValue, crunchFactor, row)
+				if value == nil {
+					break // dropped by the extract pattern
+				}
 				// Insert the value into the result structure
-				if strings.Contains(propertyName, ".") {
-					insertNested(singleInput, strings.Split(propertyName, "."), value)
-				} else {
-					singleInput[propertyName] = value
+				if err := c.insertValue(singleInput, base, strings.Split(propertyName, "."), value, row); err != nil {
+					return nil, err
 				}
+				c.recordProvenance(joinOutputPath(base, propertyName), row, inputKey, crunchFactor)
 				break
 			}
 		}
 	}
-	return singleInput
+	return singleInput, nil
+}
+
+// Resolves the crunch factor of an array element. A crunch cell holding [N] names the input
+// key of the factor instead, e.g. "Detectors.Detector-[N].PixelScale", and [N] is replaced by
+// the identifier of the element, so each element is converted by its own number or unit.
+//
+// Parameters:
+//   - crunchFactor: The crunch cell of the row
+//   - index: The identifier captured for [N] in the element's input keys
+//   - row: CSV mapping rule, used in messages
+//
+// Returns:
+//   - string: The factor or unit to convert by, empty if the referenced key holds no value
+func (c *converter) elementCrunchFactor(crunchFactor string, index string, row csvextract) string {
+	if !strings.Contains(crunchFactor, "[N]") {
+		return crunchFactor
+	}
+	key := strings.ReplaceAll(crunchFactor, "[N]", index)
+	factor := strings.TrimSpace(c.input[key])
+	if factor == "" {
+		c.warn(Warning{
+			Code:    WarnUnitConversion,
+			Path:    row.OSCEM,
+			Row:     row.Line,
+			Message: fmt.Sprintf("the crunch key %s holds no value, keeping the value unconverted", key),
+		})
+	}
+	return factor
 }
 
 // Converts a field pattern with [N] notation to a regex pattern.
@@ -217,6 +316,16 @@ func convertPatternToRegex(fieldPattern string) string {
 	return "^" + regexPattern + "$"
 }
 
+// Returns the compiled regular expression of an [N] source pattern, see
+// convertPatternToRegex. The patterns of the rows are compiled once by the plan of the
+// mapping, and live as long as it; others are compiled for the call.
+func (c *converter) patternRegexp(fieldPattern string) *regexp.Regexp {
+	if regex, ok := c.regexps[fieldPattern]; ok {
+		return regex
+	}
+	return regexp.MustCompile(convertPatternToRegex(fieldPattern))
+}
+
 // Extracts the appropriate unit conversion factor from a CSV mapping row, following the same priority.
 func getCrunchFactor(row csvextract) string {
 	if row.FromMDOC != "" {
@@ -281,43 +390,40 @@ func extractPropertyName(oscem string) string {
 // Adds processed array items to their target location in the result.
 // It navigates through the nested result structure using the array path,
 // and appends the new items to any existing array at the target location.
+// Keyed items are merged into the object at the target location instead.
 // This handles the final placement of processed arrays.
 //
 // Parameters:
 //   - result: The target result map
-//   - items: Array of processed items to add
+//   - items: Processed items to add, a []interface{} or a keyed map[string]interface{}
 //   - arrayPath: Dot-separated path indicating where to place the array (e.g., "acquisition.detectors")
-func addItemsToArrayPath(result map[string]interface{}, items []interface{}, arrayPath string) {
-	if len(items) == 0 {
-		return
-	}
+//
+// Returns:
+//   - *pathCollisionError: If the path holds something other than objects leading to an array
+func addItemsToArrayPath(result map[string]interface{}, items interface{}, arrayPath string) *pathCollisionError {
 	parts := strings.Split(arrayPath, ".")
-	if len(parts) == 0 {
-		return
-	}
-
-	// Navigate to the parent container
-	parent := result
-	for _, segment := range parts[:len(parts)-1] {
-		if _, exists := parent[segment]; !exists {
-			parent[segment] = make(map[string]interface{})
+	arrayName := parts[len(parts)-1]
+	switch items := items.(type) {
+	case []interface{}:
+		if len(items) == 0 {
+			return nil
 		}
-		if nextParent, ok := parent[segment].(map[string]interface{}); ok {
-			parent = nextParent
-		} else {
-			return
+		// Navigate to the parent container, creating the array if needed
+		parent, collision := arrayParent(result, parts[:len(parts)-1], arrayName, false)
+		if collision != nil {
+			return collision
 		}
-	}
-
-	// Get the array name (last part of the path)
-	arrayName := parts[len(parts)-1]
-	// Get existing array or create new one
-	var existingArray []interface{}
-	if existing, exists := parent[arrayName]; exists {
-		if arr, ok := existing.([]interface{}); ok {
-			existingArray = arr
+		// Append the new items to the existing array
+		parent[arrayName] = append(parent[arrayName].([]interface{}), items...)
+	case map[string]interface{}:
+		parent, collision := arrayParent(result, parts[:len(parts)-1], arrayName, true)
+		if collision != nil {
+			return collision
+		}
+		keyed := parent[arrayName].(map[string]interface{})
+		for key, item := range items {
+			keyed[key] = item
 		}
 	}
-	// Append the new items
-	parent[arrayName] = append(existingArray, items...)
+	return nil
 }
@@ -0,0 +1,82 @@
+package conversion
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/osc-em/oscem-converter-extracted/basetypes"
+)
+
+// Computes the size and SHA-256 checksum of the data files referenced by the input, such as
+// movies and gain references, so archives can verify them without re-reading the data.
+//
+// Parameters:
+//   - ctx: Context checked between files
+//   - input: Source data as key-value pairs
+//   - keys: Input keys whose values are paths of data files
+//   - root: Directory relative paths are resolved against, the working directory when empty
+//   - strict: Whether a missing or unreadable file fails the conversion instead of being skipped with a warning
+//   - warn: Receives the warnings for skipped files
+//
+// Returns:
+//   - []interface{}: One data_files element per referenced file, with path, size and sha256
+//   - error: If the context is cancelled, or a file cannot be read in strict mode
+func checksumDataFiles(ctx context.Context, input map[string]string, keys []string, root string, strict bool, warn func(Warning)) ([]interface{}, error) {
+	var files []interface{}
+	seen := make(map[string]bool)
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		ref := strings.TrimSpace(input[key])
+		if ref == "" || seen[ref] {
+			continue
+		}
+		seen[ref] = true
+		// instrument software on Windows writes backslash separated paths
+		path := filepath.FromSlash(strings.ReplaceAll(ref, "\\", "/"))
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(root, path)
+		}
+		size, sum, err := fileChecksum(path)
+		if err != nil {
+			if strict {
+				return nil, fmt.Errorf("cannot checksum %s from %s: %w", ref, key, err)
+			}
+			warn(Warning{Code: WarnChecksum, Path: "data_files", Message: fmt.Sprintf("skipped checksum of %s from %s: %v", ref, key, err)})
+			continue
+		}
+		var refOut, sumOut basetypes.String
+		refOut.Set(ref)
+		sumOut.Set(sum)
+		var sizeOut basetypes.Int
+		sizeOut.Set(size, "")
+		files = append(files, map[string]interface{}{
+			"path":   refOut,
+			"size":   sizeOut,
+			"sha256": sumOut,
+		})
+	}
+	return files, nil
+}
+
+// Returns the size in bytes and the hex encoded SHA-256 checksum of a file.
+func fileChecksum(path string) (int64, string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return 0, "", err
+	}
+	return size, hex.EncodeToString(hash.Sum(nil)), nil
+}
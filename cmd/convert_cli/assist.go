@@ -0,0 +1,436 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	conversion "github.com/osc-em/oscem-converter-extracted"
+)
+
+// Links input keys of a sample file to OSCEM paths interactively and writes the result back
+// into a mapping file:
+//
+//	convert_cli assist -i sample.json -map draft.csv [-o mapping.csv] [-column fromformat]
+//
+// The screen lists the input keys no mapping row reads on the left and the OSCEM paths of
+// the embedded mapping that no row fills from the sample on the right; "link k3 p7" makes
+// the row of path p7 read key k3. See assistHelp for the other commands.
+func runAssist(args []string) {
+	fs := flag.NewFlagSet("assist", flag.ExitOnError)
+	inputFile := fs.String("i", "", "Sample input file of the instrument to map (required)")
+	mappingFile := fs.String("map", "", "Draft mapping CSV to extend, created if missing (required)")
+	outputFile := fs.String("o", "", "File to write the updated mapping to (optional, defaults to -map)")
+	column := fs.String("column", "", "Source column new links are written to (optional, defaults to fromformat, or fromxml for full layout mappings)")
+	extractorName := fs.String("extractor", "", "Registered extractor reading the input (optional, defaults to flat JSON)")
+	fs.Parse(args)
+
+	if *inputFile == "" || *mappingFile == "" {
+		log.Fatal("assist requires -i and -map.")
+	}
+	if *outputFile == "" {
+		*outputFile = *mappingFile
+	}
+	raw, err := os.ReadFile(*inputFile)
+	if err != nil {
+		log.Fatalf("Failed to read input: %v", err)
+	}
+	name := *extractorName
+	if name == "" {
+		name = conversion.DefaultExtractor
+	}
+	extractor, ok := conversion.LookupExtractor(name)
+	if !ok {
+		log.Fatalf("Unknown extractor %q, registered: %v", name, conversion.Extractors())
+	}
+	input, err := extractor.Extract(context.Background(), raw)
+	if err != nil {
+		log.Fatalf("Failed to read input: %v", err)
+	}
+	draft, err := loadDraftMapping(*mappingFile, *column)
+	if err != nil {
+		log.Fatalf("Failed to load mapping: %v", err)
+	}
+	fields, err := conversion.Fields()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	a := &assistant{input: input, draft: draft, out: os.Stdout}
+	for _, field := range fields {
+		path := strings.Join(field.Path, ".")
+		if path != "oscem_schema_version" {
+			a.fields = append(a.fields, field)
+		}
+	}
+	if err := a.run(os.Stdin, *outputFile); err != nil {
+		log.Fatal(err)
+	}
+}
+
+const assistHelp = `Commands:
+  link <k#> <p#>   let the row of path p# read input key k#, adding the row if needed
+  keys [text]      only list input keys containing text, no text lists all of them
+  paths [text]     only list OSCEM paths containing text, no text lists all of them
+  list             list the unmapped keys and unfilled paths again
+  write            write the mapping
+  quit             leave, quit! leaves without writing pending changes
+`
+
+// The state of an assist session.
+type assistant struct {
+	input  map[string]string
+	draft  *draftMapping
+	fields []conversion.FieldSpec
+	out    io.Writer
+	dirty  bool
+
+	// substrings the listed keys and paths must contain
+	keyFilter  string
+	pathFilter string
+
+	// what the numbers of the last listing refer to
+	keys  []string
+	paths []conversion.FieldSpec
+}
+
+// Reads commands from in until quit or the end of the input.
+func (a *assistant) run(in io.Reader, output string) error {
+	a.list()
+	fmt.Fprint(a.out, assistHelp)
+	scanner := bufio.NewScanner(in)
+	for {
+		fmt.Fprint(a.out, "> ")
+		if !scanner.Scan() {
+			break
+		}
+		words := strings.Fields(scanner.Text())
+		if len(words) == 0 {
+			continue
+		}
+		switch words[0] {
+		case "link", "l":
+			if len(words) != 3 {
+				fmt.Fprintln(a.out, "usage: link <k#> <p#>")
+				continue
+			}
+			if err := a.link(words[1], words[2]); err != nil {
+				fmt.Fprintln(a.out, err)
+				continue
+			}
+			a.list()
+		case "keys", "k":
+			a.keyFilter = strings.ToLower(strings.Join(words[1:], " "))
+			a.list()
+		case "paths", "p":
+			a.pathFilter = strings.ToLower(strings.Join(words[1:], " "))
+			a.list()
+		case "list", "ls":
+			a.list()
+		case "write", "w":
+			if err := a.draft.write(output); err != nil {
+				fmt.Fprintln(a.out, "write failed:", err)
+				continue
+			}
+			a.dirty = false
+			fmt.Fprintln(a.out, "Mapping written to", output)
+		case "quit", "q":
+			if a.dirty {
+				fmt.Fprintln(a.out, "There are unwritten links; write them first, or use quit! to discard them.")
+				continue
+			}
+			return nil
+		case "quit!", "q!":
+			return nil
+		case "help", "h", "?":
+			fmt.Fprint(a.out, assistHelp)
+		default:
+			fmt.Fprintf(a.out, "unknown command %q\n%s", words[0], assistHelp)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if a.dirty {
+		fmt.Fprintln(a.out, "\nInput ended with unwritten links, they were discarded.")
+	}
+	return nil
+}
+
+// Width of the input key column of the listing.
+const assistColumnWidth = 56
+
+// Prints the unmapped input keys next to the unfilled OSCEM paths, numbering both.
+func (a *assistant) list() {
+	read := a.draft.readKeys(a.input)
+	a.keys = a.keys[:0]
+	for key := range a.input {
+		if !read[key] && strings.Contains(strings.ToLower(key), a.keyFilter) {
+			a.keys = append(a.keys, key)
+		}
+	}
+	sort.Strings(a.keys)
+	filled := a.draft.filledPaths(a.input)
+	a.paths = a.paths[:0]
+	for _, field := range a.fields {
+		path := strings.Join(field.Path, ".")
+		if !filled[path] && strings.Contains(strings.ToLower(path), a.pathFilter) {
+			a.paths = append(a.paths, field)
+		}
+	}
+
+	left := []string{fmt.Sprintf("unmapped input keys (%d)", len(a.keys))}
+	for i, key := range a.keys {
+		left = append(left, fmt.Sprintf("k%-3d %s = %s", i+1, key, a.input[key]))
+	}
+	right := []string{fmt.Sprintf("unfilled OSCEM paths (%d)", len(a.paths))}
+	for i, field := range a.paths {
+		entry := fmt.Sprintf("p%-3d %s", i+1, strings.Join(field.Path, "."))
+		if field.Type != "" || field.Units != "" {
+			entry += " [" + strings.Trim(field.Type+", "+field.Units, ", ") + "]"
+		}
+		right = append(right, entry)
+	}
+	for i := 0; i < len(left) || i < len(right); i++ {
+		var l, r string
+		if i < len(left) {
+			l = truncate(left[i], assistColumnWidth)
+		}
+		if i < len(right) {
+			r = right[i]
+		}
+		fmt.Fprintf(a.out, "%-*s | %s\n", assistColumnWidth, l, r)
+	}
+}
+
+// Shortens text to at most width runes, marking the cut with "…".
+func truncate(text string, width int) string {
+	runes := []rune(text)
+	if len(runes) <= width {
+		return text
+	}
+	return string(runes[:width-1]) + "…"
+}
+
+// Links a listed input key to a listed OSCEM path.
+func (a *assistant) link(keyRef, pathRef string) error {
+	keyIdx, err := listIndex(keyRef, "k", len(a.keys))
+	if err != nil {
+		return err
+	}
+	pathIdx, err := listIndex(pathRef, "p", len(a.paths))
+	if err != nil {
+		return err
+	}
+	key, field := a.keys[keyIdx], a.paths[pathIdx]
+	path := strings.Join(field.Path, ".")
+	if previous := a.draft.link(path, key, field); previous != "" {
+		fmt.Fprintf(a.out, "%s read %q before, now reads %q\n", path, previous, key)
+	} else {
+		fmt.Fprintf(a.out, "%s now reads %q\n", path, key)
+	}
+	a.dirty = true
+	return nil
+}
+
+// Parses a listing reference such as k3 into a zero-based index.
+func listIndex(ref string, prefix string, n int) (int, error) {
+	if n == 0 {
+		return 0, fmt.Errorf("no %s entries are listed", prefix)
+	}
+	var i int
+	if _, err := fmt.Sscanf(strings.TrimPrefix(ref, prefix), "%d", &i); err != nil || i < 1 || i > n {
+		return 0, fmt.Errorf("%q is not one of %s1 to %s%d", ref, prefix, prefix, n)
+	}
+	return i - 1, nil
+}
+
+// A mapping CSV being edited, kept as its raw cells so unrelated rows, columns and the
+// file's line endings and byte order mark are written back unchanged.
+type draftMapping struct {
+	header []string
+	rows   [][]string
+	column map[string]int
+	source int // column new links are written to
+	crlf   bool
+	bom    bool
+}
+
+// The columns holding source keys, in either layout.
+var draftSourceColumns = []string{"fromformat", "optionals", "fromxml", "frommdoc", "optionals_mdoc", "optionals_xml"}
+
+// Loads a draft mapping, or starts a reduced layout one if the file does not exist.
+func loadDraftMapping(path string, sourceColumn string) (*draftMapping, error) {
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		// CRLF like the tables shipped with the converter
+		raw, err = []byte("oscem,fromformat,optionals,units,crunch,type\r\n"), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseDraftMapping(raw, sourceColumn)
+}
+
+// Parses a draft mapping, picking the column new links are written to.
+func parseDraftMapping(raw []byte, sourceColumn string) (*draftMapping, error) {
+	d := &draftMapping{
+		bom:    bytes.HasPrefix(raw, []byte("\ufeff")),
+		crlf:   bytes.Contains(raw, []byte("\r\n")),
+		column: make(map[string]int),
+	}
+	reader := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(raw, []byte("\ufeff"))))
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("mapping has no header")
+	}
+	d.header, d.rows = records[0], records[1:]
+	for i, name := range d.header {
+		d.column[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := d.column["oscem"]; !ok {
+		return nil, fmt.Errorf("mapping has no oscem column")
+	}
+	if sourceColumn == "" {
+		sourceColumn = "fromformat"
+		if _, ok := d.column[sourceColumn]; !ok {
+			sourceColumn = "fromxml"
+		}
+	}
+	idx, ok := d.column[strings.ToLower(sourceColumn)]
+	if !ok {
+		return nil, fmt.Errorf("mapping has no %s column", sourceColumn)
+	}
+	d.source = idx
+	return d, nil
+}
+
+// Returns a cell of a row, "" if the row is too short.
+func (d *draftMapping) cell(row []string, name string) string {
+	idx, ok := d.column[name]
+	if !ok || idx >= len(row) {
+		return ""
+	}
+	return strings.TrimSpace(row[idx])
+}
+
+// Lists the source keys and [N] patterns a row reads, from all source and fallback columns.
+func (d *draftMapping) sources(row []string) []string {
+	var keys []string
+	for _, name := range draftSourceColumns {
+		for _, key := range strings.Split(d.cell(row, name), ";") {
+			if key = strings.TrimSpace(key); key != "" {
+				keys = append(keys, key)
+			}
+		}
+	}
+	for _, fallback := range strings.Split(d.cell(row, "fallbacks"), "|") {
+		key, _, _ := strings.Cut(fallback, "=")
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// Returns the input keys matching a source key or [N] pattern.
+func matchingKeys(source string, input map[string]string) []string {
+	if !strings.Contains(source, "[N]") {
+		if _, ok := input[source]; ok {
+			return []string{source}
+		}
+		return nil
+	}
+	pattern := regexp.MustCompile("^" + strings.ReplaceAll(regexp.QuoteMeta(source), `\[N\]`, `[^.]+`) + "$")
+	var matches []string
+	for key := range input {
+		if pattern.MatchString(key) {
+			matches = append(matches, key)
+		}
+	}
+	return matches
+}
+
+// Returns the input keys some row reads.
+func (d *draftMapping) readKeys(input map[string]string) map[string]bool {
+	read := make(map[string]bool)
+	for _, row := range d.rows {
+		for _, source := range d.sources(row) {
+			for _, key := range matchingKeys(source, input) {
+				read[key] = true
+			}
+		}
+	}
+	return read
+}
+
+// Returns the OSCEM paths of the rows that find one of their source keys in the input.
+func (d *draftMapping) filledPaths(input map[string]string) map[string]bool {
+	filled := make(map[string]bool)
+	for _, row := range d.rows {
+		for _, source := range d.sources(row) {
+			if len(matchingKeys(source, input)) > 0 {
+				filled[d.cell(row, "oscem")] = true
+				break
+			}
+		}
+	}
+	return filled
+}
+
+// Makes the row of path read key from the source column, appending a row with the type and
+// units of field if the mapping has none. Returns the key the row read before, if any.
+func (d *draftMapping) link(path string, key string, field conversion.FieldSpec) string {
+	for i, row := range d.rows {
+		if d.cell(row, "oscem") == path {
+			for len(row) <= d.source {
+				row = append(row, "")
+			}
+			previous := row[d.source]
+			row[d.source] = key
+			d.rows[i] = row
+			return previous
+		}
+	}
+	row := make([]string, len(d.header))
+	row[d.column["oscem"]] = path
+	row[d.source] = key
+	if idx, ok := d.column["type"]; ok {
+		row[idx] = field.Type
+	}
+	if idx, ok := d.column["units"]; ok {
+		row[idx] = field.Units
+	}
+	d.rows = append(d.rows, row)
+	return ""
+}
+
+// Writes the mapping in the line endings and with the byte order mark of the draft.
+func (d *draftMapping) write(path string) error {
+	var buf bytes.Buffer
+	if d.bom {
+		buf.WriteString("\ufeff")
+	}
+	w := csv.NewWriter(&buf)
+	w.UseCRLF = d.crlf
+	w.Write(d.header)
+	w.WriteAll(d.rows)
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return conversion.WriteOutput(path, buf.Bytes(), conversion.Options{})
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	conversion "github.com/osc-em/oscem-converter-extracted"
+)
+
+// Converts many inputs in one run, crawling directories for .json files:
+//
+//	convert_cli batch -o converted/ -coverage coverage.csv sessions/
+//
+// Each output is named after its input and written to the output directory,
+// next to the input when none is given. A state file records the checksums, status
+// and errors of the processed inputs, so a re-run skips inputs that are unchanged and
+// still have their document, and those that failed unless -retry-failed is given.
+// With -summary, the session-level values every facility report needs are derived from
+// the documents as well: acquisitions, first and last acquisition time and throughput.
+// With -report, every input is written as a JSON line with its outcome and document.
+// With -instrument-inventory, the detectors, apertures and accessories of each microscope
+// are collected into a static inventory document for asset registries.
+func runBatch(args []string) {
+	flags := flag.NewFlagSet("batch", flag.ExitOnError)
+	outDir := flags.String("o", "", "Directory to write converted documents to (optional, defaults to next to each input)")
+	mappingFile := flags.String("map", "", "Custom CSV mapping file path (optional)")
+	cs := flags.String("cs", "", "Provide CS (spherical aberration) value here (optional)")
+	gainFlipRotate := flags.String("gain_flip_rotate", "", "Provide whether and how to flip the gain ref here, if applicaple (optional)")
+	strict := flags.Bool("strict", false, "Fail on malformed mapping rows and on any conversion warning, such as a failed unit conversion, instead of warning (optional)")
+	extractorName := flags.String("extractor", "", "Registered extractor reading the inputs (optional, defaults to flat JSON)")
+	schemaVersion := flags.String("schema-version", "", "OSCEM schema version to target (optional, defaults to the newest)")
+	modality := flags.String("modality", "", "Acquisition modality (spa, tomo, screening, diffraction) activating the mapping rows of its profile (optional)")
+	noClobber := flags.Bool("no-clobber", false, "Count inputs whose document already exists as failed instead of replacing it (optional)")
+	syncOutput := flags.Bool("sync", false, "Flush every document to stable storage before recording it as converted (optional)")
+	minFields := flags.Int("min-fields", 0, "Count inputs whose document has fewer fields as failed instead of writing it, e.g. when the wrong mapping was chosen (optional)")
+	coverageFile := flags.String("coverage", "", "Write per-field fill rates per session and instrument to this .json or .csv file (optional)")
+	instrumentPath := flags.String("instrument-path", "instrument.microscope.model", "OSCEM path naming the instrument in coverage reports (optional)")
+	summaryFile := flags.String("summary", "", "Write acquisition counts, first and last acquisition times and images per hour per session to this JSON file (optional)")
+	inventoryFile := flags.String("instrument-inventory", "", "Write the detectors, apertures and accessories found per microscope to this JSON file (optional)")
+	timePath := flags.String("time-path", "acquisition.date_time", "OSCEM path holding the acquisition time in session summaries (optional)")
+	reportFile := flags.String("report", "", "Write one JSON line per input with its status, error, warnings and document to this file, for querying the batch with jq or DuckDB (optional)")
+	stateFile := flags.String("state", "", "State file recording converted inputs (optional, defaults to "+batchStateName+" in the output or working directory)")
+	rerun := flags.Bool("rerun", false, "Convert every input again, even if unchanged since the last run (optional)")
+	retryFailed := flags.Bool("retry-failed", false, "Convert unchanged inputs whose conversion failed in an earlier run again (optional)")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		log.Fatal("batch requires at least one input file or directory.")
+	}
+	if *outDir != "" {
+		// the documents and the default state file are written there
+		if err := os.MkdirAll(*outDir, 0755); err != nil {
+			log.Fatalf("Failed to create output directory: %v", err)
+		}
+	}
+	if *stateFile == "" {
+		*stateFile = filepath.Join(*outDir, batchStateName)
+	}
+	inputs, err := collectInputs(flags.Args(), *outDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	state, err := loadBatchState(*stateFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var runReport *batchReport
+	if *reportFile != "" {
+		if runReport, err = newBatchReport(*reportFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+	settings, err := settingsChecksum(*mappingFile, map[string]string{
+		"cs":               *cs,
+		"gain_flip_rotate": *gainFlipRotate,
+		"strict":           fmt.Sprint(*strict),
+		"extractor":        *extractorName,
+		"schema_version":   *schemaVersion,
+		"modality":         *modality,
+		"min_fields":       fmt.Sprint(*minFields),
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	// a shutdown signal lets the conversion in progress finish, a second one aborts it
+	drain, ctx, stop := shutdownContexts()
+	defer stop()
+
+	opts := conversion.Options{
+		MappingFile:    *mappingFile,
+		CS:             *cs,
+		GainFlipRotate: *gainFlipRotate,
+		Strict:         *strict,
+		Extractor:      *extractorName,
+		SchemaVersion:  *schemaVersion,
+		Modality:       *modality,
+		MinFields:      *minFields,
+		NoClobber:      *noClobber,
+		Sync:           *syncOutput,
+	}
+	if *mappingFile != "" {
+		// parse the mapping once instead of per input, as strict as the conversions
+		mapping, err := conversion.LoadMappingWithOptions(*mappingFile, opts)
+		if err != nil {
+			log.Fatalf("Failed to load mapping: %v", err)
+		}
+		opts.Mapping = mapping
+	}
+
+	report := conversion.NewCoverageReport()
+	summary := conversion.NewSessionSummary(*timePath)
+	inventory := conversion.NewInstrumentInventory()
+	converted, failed, warned, skipped, failedBefore := 0, 0, 0, 0, 0
+	processed := 0
+	for _, path := range inputs {
+		if drain.Err() != nil {
+			break
+		}
+		processed++
+		key := absPath(path)
+		entry := stateEntry{Settings: settings, Output: absPath(outputPath(path, *outDir))}
+		input, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			failed++
+			// recorded without a checksum, so the next run reads it again
+			entry.Status, entry.Error = statusFailed, err.Error()
+			if err := state.record(key, entry); err != nil {
+				log.Fatal(err)
+			}
+			if err := runReport.add(path, entry, false, nil, nil); err != nil {
+				log.Fatal(err)
+			}
+			continue
+		}
+		entry.Input = checksum(input)
+		var doc []byte
+		if previous, ok := state.previous(key, entry); ok && !*rerun {
+			switch {
+			case previous.Status == statusFailed && !*retryFailed:
+				fmt.Fprintf(os.Stderr, "%s: skipped, failed in an earlier run: %s\n", path, previous.Error)
+				failedBefore++
+				if err := runReport.add(path, previous, true, nil, nil); err != nil {
+					log.Fatal(err)
+				}
+				continue
+			case previous.Status == statusConverted:
+				// the earlier document still counts towards the coverage of the batch
+				if doc, err = os.ReadFile(entry.Output); err == nil {
+					converted++
+					skipped++
+					if err := runReport.add(path, previous, true, nil, doc); err != nil {
+						log.Fatal(err)
+					}
+				}
+			}
+		}
+		if doc == nil {
+			res, err := convertFile(ctx, path, input, *outDir, opts)
+			if err != nil {
+				if ctx.Err() != nil {
+					// aborted, so the next run converts it again
+					break
+				}
+				fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+				failed++
+				entry.Status, entry.Error = statusFailed, err.Error()
+				if err := state.record(key, entry); err != nil {
+					log.Fatal(err)
+				}
+				if err := runReport.add(path, entry, false, nil, nil); err != nil {
+					log.Fatal(err)
+				}
+				continue
+			}
+			for _, warning := range res.Warnings {
+				fmt.Fprintf(os.Stderr, "%s: warning: %s\n", path, warning)
+			}
+			warned += len(res.Warnings)
+			converted++
+			entry.Status, entry.Warnings = statusConverted, len(res.Warnings)
+			if err := state.record(key, entry); err != nil {
+				log.Fatal(err)
+			}
+			if err := runReport.add(path, entry, false, res.Warnings, res.Document); err != nil {
+				log.Fatal(err)
+			}
+			doc = res.Document
+		}
+		// the directory of an input is its session
+		if err := report.Add(doc, filepath.Dir(path), conversion.DocumentValue(doc, *instrumentPath)); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+		} else if err := summary.Add(doc, filepath.Dir(path)); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+		} else if err := inventory.Add(doc); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+		}
+	}
+	if err := state.close(); err != nil {
+		log.Fatal(err)
+	}
+	if err := runReport.close(); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Converted %d of %d inputs with %d warnings, %d unchanged since the last run\n", converted, len(inputs), warned, skipped)
+	if processed < len(inputs) || ctx.Err() != nil {
+		fmt.Printf("Stopped early, %d inputs are left for the next run\n", len(inputs)-converted-failed-failedBefore)
+	}
+	if failedBefore > 0 {
+		fmt.Printf("Skipped %d inputs that failed in an earlier run, -retry-failed converts them again\n", failedBefore)
+	}
+
+	if *coverageFile != "" {
+		if err := writeCoverage(*coverageFile, report); err != nil {
+			log.Fatalf("Failed to write coverage report: %v", err)
+		}
+	}
+	if *summaryFile != "" {
+		if err := writeJSONFile(*summaryFile, summary); err != nil {
+			log.Fatalf("Failed to write session summary: %v", err)
+		}
+	}
+	if *inventoryFile != "" {
+		if err := writeJSONFile(*inventoryFile, inventory); err != nil {
+			log.Fatalf("Failed to write instrument inventory: %v", err)
+		}
+	}
+	if failed > 0 || failedBefore > 0 {
+		os.Exit(1)
+	}
+}
+
+// Expands the arguments into input files, walking directories for .json files and
+// skipping the output directory so earlier results are not converted again.
+func collectInputs(args []string, outDir string) ([]string, error) {
+	var inputs []string
+	absOut, _ := filepath.Abs(outDir)
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			inputs = append(inputs, arg)
+			continue
+		}
+		err = filepath.WalkDir(arg, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				if abs, _ := filepath.Abs(path); outDir != "" && abs == absOut {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if strings.EqualFold(filepath.Ext(path), ".json") && !strings.HasSuffix(path, ".oscem.json") && d.Name() != batchStateName {
+				inputs = append(inputs, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return inputs, nil
+}
+
+// Converts the content of one input file and writes the document, see outputPath.
+func convertFile(ctx context.Context, path string, input []byte, outDir string, opts conversion.Options) (*conversion.Result, error) {
+	res, err := conversion.ConvertDocument(ctx, input, opts)
+	if err != nil {
+		return nil, err
+	}
+	res.OutputPath = outputPath(path, outDir)
+	if err := conversion.WriteOutput(res.OutputPath, res.Document, opts); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Returns where the document of an input is written: <name>.oscem.json in the output
+// directory, or next to the input when none is given.
+func outputPath(path string, outDir string) string {
+	dir := outDir
+	if dir == "" {
+		dir = filepath.Dir(path)
+	}
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)) + ".oscem.json"
+	return filepath.Join(dir, name)
+}
+
+// Writes the coverage report as CSV or, for any other extension, as JSON.
+func writeCoverage(path string, report *conversion.CoverageReport) error {
+	if !strings.EqualFold(filepath.Ext(path), ".csv") {
+		return writeJSONFile(path, report)
+	}
+	var buf bytes.Buffer
+	if err := report.WriteCSV(&buf); err != nil {
+		return err
+	}
+	return conversion.WriteOutput(path, buf.Bytes(), conversion.Options{})
+}
+
+// Writes a value to a file as indented JSON.
+func writeJSONFile(path string, value interface{}) error {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	return conversion.WriteOutput(path, append(data, '\n'), conversion.Options{})
+}
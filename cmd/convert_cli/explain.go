@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	conversion "github.com/osc-em/oscem-converter-extracted"
+)
+
+// Explains how the conversion of an input arrives at the value of one OSCEM path:
+//
+//	convert_cli explain -i input.json -path instrument.cs [-map mapping.csv] [-json]
+func runExplain(args []string) {
+	fs := flag.NewFlagSet("explain", flag.ExitOnError)
+	inputFile := fs.String("i", "", "Input JSON file (required)")
+	path := fs.String("path", "", "OSCEM path to explain, e.g. instrument.cs or acquisition.detectors[0].name (required)")
+	mappingFile := fs.String("map", "", "Custom CSV mapping file path (optional)")
+	extractorName := fs.String("extractor", "", "Registered extractor reading the input (optional, defaults to flat JSON)")
+	schemaVersion := fs.String("schema-version", "", "OSCEM schema version to target (optional, defaults to the newest)")
+	modality := fs.String("modality", "", "Acquisition modality whose profile rows are active (optional)")
+	cs := fs.String("cs", "", "CS (spherical aberration) value, as given to the conversion (optional)")
+	gainFlipRotate := fs.String("gain_flip_rotate", "", "Gain reference flip/rotate, as given to the conversion (optional)")
+	overlayFile := fs.String("overlay", "", "JSON overlay file, as given to the conversion (optional)")
+	registryFile := fs.String("registry", "", "CSV instrument registry, as given to the conversion (optional)")
+	valueStyle := fs.String("values", "", "Representation of typed values: bare or object (optional)")
+	derivePixelSize := fs.Bool("derive-pixel-size", false, "Derive a missing pixel size, as in the conversion (optional)")
+	asJSON := fs.Bool("json", false, "Print the explanation as JSON (optional)")
+	fs.Parse(args)
+
+	if *inputFile == "" || *path == "" {
+		log.Fatal("Both an input file (-i) and a path (-path) are required")
+	}
+	jsonin, err := os.ReadFile(*inputFile)
+	if err != nil {
+		log.Fatalf("Failed to read input: %v", err)
+	}
+	opts := conversion.Options{
+		MappingFile:     *mappingFile,
+		Extractor:       *extractorName,
+		SchemaVersion:   *schemaVersion,
+		Modality:        *modality,
+		CS:              *cs,
+		GainFlipRotate:  *gainFlipRotate,
+		ValueStyle:      *valueStyle,
+		DerivePixelSize: *derivePixelSize,
+	}
+	if *overlayFile != "" {
+		if opts.Overlay, err = conversion.LoadOverlay(*overlayFile); err != nil {
+			log.Fatalf("Failed to read overlay: %v", err)
+		}
+	}
+	if *registryFile != "" {
+		if opts.Registry, err = conversion.LoadRegistry(*registryFile); err != nil {
+			log.Fatalf("Failed to read registry: %v", err)
+		}
+	}
+	explanation, err := conversion.Explain(context.Background(), jsonin, *path, opts)
+	if err != nil {
+		log.Fatalf("Failed to explain %s: %v", *path, err)
+	}
+	if *asJSON {
+		out, _ := json.MarshalIndent(explanation, "", "  ")
+		os.Stdout.Write(append(out, '\n'))
+		return
+	}
+	printExplanation(explanation)
+}
+
+// Prints an explanation as an indented outline, one mapping row after the other.
+func printExplanation(e *conversion.FieldExplanation) {
+	fmt.Println(e.Path)
+	for _, row := range e.Rows {
+		state := ""
+		if !row.Active {
+			state = ", inactive for this modality"
+		}
+		fmt.Printf("  row %s (line %d, %s %s%s)\n", row.Target, row.Line, row.Type, row.Units, state)
+		for i, check := range row.Checks {
+			result := "missing"
+			if check.Found {
+				result = fmt.Sprintf("found %q", check.Value)
+			}
+			fmt.Printf("    %d. %-14s %s: %s\n", i+1, check.Column, check.Key, result)
+		}
+		if row.Source == "" {
+			fmt.Println("    -> no value")
+		} else {
+			fmt.Printf("    -> takes %s\n", row.Source)
+		}
+		for _, value := range row.Values {
+			if value.Input != "" {
+				fmt.Printf("       %s = %q\n", value.Input, value.Raw)
+			}
+			for _, step := range value.Steps {
+				fmt.Printf("         %s\n", step)
+			}
+		}
+		for _, w := range row.Warnings {
+			fmt.Printf("    warning: %s\n", w)
+		}
+	}
+	for _, note := range e.Notes {
+		fmt.Printf("  note: %s\n", note)
+	}
+	value, _ := json.Marshal(e.Value)
+	fmt.Printf("  value: %s\n", value)
+	for _, w := range e.Warnings {
+		fmt.Printf("  warning: %s\n", w)
+	}
+}
@@ -1,20 +1,96 @@
 package main
 
 import (
+	"context"
+	"crypto/ed25519"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"strings"
 
 	conversion "github.com/osc-em/oscem-converter-extracted"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "migrate":
+			runMigrate(os.Args[2:])
+			return
+		case "show-mapping":
+			runShowMapping(os.Args[2:])
+			return
+		case "rpc":
+			runRPC(os.Args[2:])
+			return
+		case "verify":
+			runVerify(os.Args[2:])
+			return
+		case "batch":
+			runBatch(os.Args[2:])
+			return
+		case "assist":
+			runAssist(os.Args[2:])
+			return
+		case "schema":
+			runSchema(os.Args[2:])
+			return
+		case "explain":
+			runExplain(os.Args[2:])
+			return
+		case "serve":
+			runServe(os.Args[2:])
+			return
+		}
+	}
+
 	inputFile := flag.String("i", "", "Input JSON file (required)")
 	outputFile := flag.String("o", "", "Output JSON file name (optional)")
 	mappingFile := flag.String("map", "", "Custom CSV mapping file path (optional)")
 	p1Flag := flag.String("cs", "", "Provide CS (spherical aberration) value here (optional)")
 	p2Flag := flag.String("gain_flip_rotate", "", "Provide whether and how to flip the gain ref here, if applicaple (optional)")
+	strict := flag.Bool("strict", false, "Fail on malformed mapping rows and on any conversion warning, such as a failed unit conversion, instead of warning (optional)")
+	only := flag.String("only", "", "Comma-separated OSCEM paths whose mapping rows are converted, e.g. sample.grid to re-extract a corrected section with -append (optional)")
+	include := flag.String("include", "", "Comma-separated OSCEM paths to keep in the output, e.g. acquisition,instrument (optional)")
+	exclude := flag.String("exclude", "", "Comma-separated OSCEM paths to drop from the output, e.g. sample.operator (optional)")
+	redact := flag.String("redact", "", "Comma-separated OSCEM paths with personal data to remove, \"personal\" for the built-in list (optional)")
+	pseudonymize := flag.Bool("pseudonymize", false, "Replace redacted values by HMAC pseudonyms keyed with OSCEM_PSEUDONYM_KEY instead of removing them (optional)")
+	hash := flag.String("hash", "", "Comma-separated OSCEM paths of identifiers to replace by their hash salted with OSCEM_HASH_SALT (optional)")
+	license := flag.String("license", "", "SPDX identifier of the data license, e.g. CC-BY-4.0 (optional)")
+	doi := flag.String("doi", "", "DOI of the dataset (optional)")
+	orcid := flag.String("orcid", "", "ORCID iD of the author (optional)")
+	funder := flag.String("funder", "", "Name of the funding organization (optional)")
+	enrichURL := flag.String("enrich-url", "", "REST endpoint to look up proposal or sample metadata, {id} is replaced by the identifier (optional)")
+	enrichID := flag.String("enrich-id-key", "", "Input key holding the identifier looked up at -enrich-url (optional)")
+	enrichFields := flag.String("enrich-fields", "", "CSV file selecting the response fields copied into the output (required with -enrich-url)")
+	checksum := flag.String("checksum", "", "Comma-separated input keys referencing data files to checksum into data_files (optional)")
+	dataRoot := flag.String("data-root", "", "Directory relative data file paths are resolved against (optional, defaults to the working directory)")
+	signKey := flag.String("sign-key", "", "PEM Ed25519 private key; a detached JWS of the output is written next to it as .jws (optional)")
+	provenance := flag.Bool("provenance", false, "Add a _provenance object naming the input key, mapping row and crunch factor behind each value (optional)")
+	canonical := flag.Bool("canonical", false, "Write RFC 8785 canonical JSON instead of indented JSON (optional)")
+	keepEmptySlots := flag.Bool("keep-empty-slots", false, "Write null for empty entries of semicolon-separated source lists to keep array positions (optional)")
+	listPolicy := flag.String("list-policy", "", "Resolution of semicolon-separated source lists mapped to a single field whose entries disagree: first, last, error or array (optional, defaults to first)")
+	force := flag.Bool("force", false, "Replace an existing output file without a warning (optional)")
+	noClobber := flag.Bool("no-clobber", false, "Fail instead of replacing an existing output file (optional)")
+	syncOutput := flag.Bool("sync", false, "Flush the output to stable storage before exiting, for archival pipelines (optional)")
+	minFields := flag.Int("min-fields", 0, "Fail instead of writing a document with fewer fields, e.g. when the wrong mapping was chosen (optional)")
+	activeOnly := flag.Bool("active-only", false, "Keep only the array elements the mapping's reference rows name as in use, e.g. the detector of the acquisition (optional)")
+	inventory := flag.Bool("inventory", false, "With -active-only, keep the complete arrays under instrument.inventory (optional)")
+	derivePixelSize := flag.Bool("derive-pixel-size", false, "Compute a missing pixel size from the physical detector pixel size, binning and magnification (optional)")
+	valueStyle := flag.String("values", "", "Representation of typed values: bare for plain primitives, object for {value, unit} numbers; numbers with a unit become objects when empty (optional)")
+	overlayFile := flag.String("overlay", "", "JSON file with metadata the instrument does not record, e.g. grid and plunge-freezing parameters (optional)")
+	fibLog := flag.String("fib-log", "", "CSV milling log of the cryo-FIB/SEM session that prepared the lamellae (optional)")
+	processing := flag.String("processing", "", "Warp settings XML or RELION default_pipeline.star describing the on-the-fly processing of the data (optional)")
+	screeningExport := flag.String("screening", "", "SmartScope or cryoSPARC Live JSON export with the screening decisions of the grid (optional)")
+	appendFile := flag.String("append", "", "Existing OSCEM JSON to merge the newly extracted fields into (optional, also the default output)")
+	extractorName := flag.String("extractor", "", "Registered extractor reading the input (optional, defaults to flat JSON)")
+	registryFile := flag.String("registry", "", "CSV registry selecting mapping and injected values by instrument identifier (optional)")
+	schemaVersion := flag.String("schema-version", "", "OSCEM schema version to target, e.g. 1.x (optional, defaults to the newest)")
+	modality := flag.String("modality", "", "Acquisition modality (spa, tomo, screening, diffraction) activating the mapping rows of its profile (optional)")
+	esURL := flag.String("es-url", "", "Elasticsearch/OpenSearch URL to push the converted document to (optional)")
+	esIndex := flag.String("es-index", "oscem", "Elasticsearch/OpenSearch index name (optional)")
 
 	flag.Parse()
 
@@ -22,12 +98,175 @@ func main() {
 		log.Fatal("Input file (-in) is required.")
 	}
 
-	jsonIn, err := os.ReadFile(*inputFile)
+	// the input is read as it is converted, so only check it can be opened before the options
+	_, err := os.Stat(*inputFile)
 	if err != nil {
 		log.Fatalf("Failed to read input file: %v", err)
 	}
-	_, err1 := conversion.Convert(jsonIn, *mappingFile, *p1Flag, *p2Flag, *outputFile)
+	// Ctrl-C cancels a running conversion or push instead of killing the process mid-write
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var pseudonymKey []byte
+	if *pseudonymize {
+		pseudonymKey = []byte(os.Getenv("OSCEM_PSEUDONYM_KEY"))
+		if len(pseudonymKey) == 0 {
+			log.Fatal("-pseudonymize requires the OSCEM_PSEUDONYM_KEY environment variable.")
+		}
+	}
+
+	var enrichers []conversion.Enricher
+	if *enrichURL != "" {
+		if *enrichID == "" || *enrichFields == "" {
+			log.Fatal("-enrich-url requires -enrich-id-key and -enrich-fields.")
+		}
+		fields, err := conversion.LoadEnrichmentFields(*enrichFields)
+		if err != nil {
+			log.Fatalf("Failed to load enrichment fields: %v", err)
+		}
+		enrichers = append(enrichers, &conversion.RESTEnricher{
+			URL:    *enrichURL,
+			IDKey:  *enrichID,
+			Fields: fields,
+			Token:  os.Getenv("OSCEM_ENRICH_TOKEN"),
+		})
+	}
+
+	var signingKey ed25519.PrivateKey
+	if *signKey != "" {
+		signingKey, err = conversion.LoadSigningKey(*signKey)
+		if err != nil {
+			log.Fatalf("Failed to load signing key: %v", err)
+		}
+	}
+
+	var overlay map[string]string
+	if *overlayFile != "" {
+		overlay, err = conversion.LoadOverlay(*overlayFile)
+		if err != nil {
+			log.Fatalf("Failed to load overlay: %v", err)
+		}
+	}
+
+	if *fibLog != "" {
+		// the preparation is attached to the record like any other overlay
+		if overlay, err = addExtracted(ctx, overlay, conversion.FIBLogExtractor, *fibLog); err != nil {
+			log.Fatalf("Failed to read milling log: %v", err)
+		}
+	}
+	if *screeningExport != "" {
+		if overlay, err = addExtracted(ctx, overlay, conversion.ScreeningExtractor, *screeningExport); err != nil {
+			log.Fatalf("Failed to read screening export: %v", err)
+		}
+	}
+	if *processing != "" {
+		name := conversion.RelionPipelineExtractor
+		if strings.HasSuffix(*processing, ".settings") || strings.HasSuffix(*processing, ".xml") {
+			name = conversion.WarpSettingsExtractor
+		}
+		if overlay, err = addExtracted(ctx, overlay, name, *processing); err != nil {
+			log.Fatalf("Failed to read processing description: %v", err)
+		}
+	}
+
+	var registry *conversion.Registry
+	if *registryFile != "" {
+		registry, err = conversion.LoadRegistry(*registryFile)
+		if err != nil {
+			log.Fatalf("Failed to load registry: %v", err)
+		}
+	}
+
+	res, err1 := conversion.ConvertFile(ctx, *inputFile, conversion.Options{
+		MappingFile:    *mappingFile,
+		CS:             *p1Flag,
+		GainFlipRotate: *p2Flag,
+		Output:         *outputFile,
+		SchemaVersion:  *schemaVersion,
+		Modality:       *modality,
+		Registry:       registry,
+		Extractor:      *extractorName,
+		Overlay:        overlay,
+		AppendTo:       *appendFile,
+		Strict:         *strict,
+		Only:           splitList(*only),
+		Include:        splitList(*include),
+		Exclude:        splitList(*exclude),
+		Redact:         splitList(*redact),
+		PseudonymKey:   pseudonymKey,
+		Hash:           splitList(*hash),
+		HashSalt:       []byte(os.Getenv("OSCEM_HASH_SALT")),
+		Rights: conversion.Rights{
+			License: *license,
+			DOI:     *doi,
+			ORCID:   *orcid,
+			Funder:  *funder,
+		},
+		Enrichers:       enrichers,
+		ChecksumKeys:    splitList(*checksum),
+		DataRoot:        *dataRoot,
+		SigningKey:      signingKey,
+		Provenance:      *provenance,
+		Canonical:       *canonical,
+		KeepEmptySlots:  *keepEmptySlots,
+		ListPolicy:      *listPolicy,
+		ValueStyle:      *valueStyle,
+		DerivePixelSize: *derivePixelSize,
+		ActiveOnly:      *activeOnly,
+		MinFields:       *minFields,
+		Force:           *force,
+		NoClobber:       *noClobber,
+		Sync:            *syncOutput,
+		Inventory:       *inventory,
+	})
 	if err1 != nil {
-		fmt.Fprintln(os.Stderr, "conversion failed because", err)
+		// write errors name the failing path, and scripts see the failure in the exit code
+		fmt.Fprintln(os.Stderr, "conversion failed because", err1)
+		stop()
+		os.Exit(1)
+	}
+	for _, warning := range res.Warnings {
+		fmt.Fprintln(os.Stderr, "Warning:", warning)
+	}
+	for _, conflict := range res.Conflicts {
+		fmt.Fprintln(os.Stderr, "overwrote", conflict)
+	}
+	if *esURL != "" {
+		sink := conversion.NewElasticsearchSinkFromEnv(*esURL, *esIndex)
+		if err := sink.Push(ctx, [][]byte{res.Document}); err != nil {
+			log.Fatalf("Failed to push document to Elasticsearch: %v", err)
+		}
+	}
+}
+
+// Adds what the named extractor reads from a side file, such as the lamellae of a cryo-FIB/SEM
+// milling log, to the overlay, creating it if needed.
+func addExtracted(ctx context.Context, overlay map[string]string, name string, path string) (map[string]string, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	extractor, _ := conversion.LookupExtractor(name)
+	values, err := extractor.Extract(ctx, raw)
+	if err != nil {
+		return nil, err
+	}
+	if overlay == nil {
+		overlay = make(map[string]string)
+	}
+	for key, value := range values {
+		overlay[key] = value
+	}
+	return overlay, nil
+}
+
+// Splits a comma-separated flag value, dropping blank entries.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
 	}
+	return items
 }
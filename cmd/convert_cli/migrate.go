@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	conversion "github.com/osc-em/oscem-converter-extracted"
+)
+
+// Rewrites existing OSCEM documents for another schema version:
+//
+//	convert_cli migrate -from 1.0 -to 2.0 -rules rules.csv doc1.json doc2.json
+func runMigrate(args []string) {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	from := fs.String("from", "", "Schema version the documents were produced for (optional, checked against oscem_schema_version)")
+	to := fs.String("to", "", "Schema version to migrate to (required)")
+	rulesFile := fs.String("rules", "", "CSV file with migration rules: action,from,to[,separator] (required)")
+	outDir := fs.String("o", "", "Directory to write migrated documents to (optional, rewrites in place if empty)")
+	fs.Parse(args)
+
+	if *to == "" || *rulesFile == "" {
+		log.Fatal("migrate requires -to and -rules.")
+	}
+	if fs.NArg() == 0 {
+		log.Fatal("migrate requires at least one OSCEM JSON file.")
+	}
+	rules, err := conversion.LoadMigrationRules(*rulesFile)
+	if err != nil {
+		log.Fatalf("Failed to load migration rules: %v", err)
+	}
+
+	failed := false
+	for _, path := range fs.Args() {
+		doc, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			failed = true
+			continue
+		}
+		migrated, err := conversion.Migrate(doc, rules, *from, *to)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			failed = true
+			continue
+		}
+		target := path
+		if *outDir != "" {
+			target = filepath.Join(*outDir, filepath.Base(path))
+		}
+		// documents are often migrated in place, which must not leave them truncated
+		if err := conversion.WriteOutput(target, migrated, conversion.Options{}); err != nil {
+			fmt.Fprintln(os.Stderr, err) // names the target
+			failed = true
+			continue
+		}
+		fmt.Printf("Migrated %s -> %s\n", path, target)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	conversion "github.com/osc-em/oscem-converter-extracted"
+)
+
+// The run report of a batch, one JSON line per processed input with its outcome and
+// document, so operators can query a batch with jq or DuckDB, e.g. for the acquisitions
+// of a week that lack a dose value, without a database driver.
+type batchReport struct {
+	file *os.File
+	enc  *json.Encoder
+}
+
+// A line of the run report.
+type reportRow struct {
+	Input     string          `json:"input"`
+	Session   string          `json:"session"`             // the directory of the input
+	Status    string          `json:"status"`              // one of statusConverted and statusFailed
+	Unchanged bool            `json:"unchanged,omitempty"` // skipped as the ledger records this outcome, see batchState
+	Error     string          `json:"error,omitempty"`
+	Warnings  []string        `json:"warnings,omitempty"`
+	Output    string          `json:"output,omitempty"`
+	Document  json.RawMessage `json:"document,omitempty"`
+}
+
+// Creates the run report at path, replacing the report of an earlier run.
+func newBatchReport(path string) (*batchReport, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create run report: %w", err)
+	}
+	return &batchReport{file: file, enc: json.NewEncoder(file)}, nil
+}
+
+// Appends the outcome of an input to the report; a nil report records nothing.
+//
+// Parameters:
+//   - path: The input as given or found by collectInputs
+//   - entry: Its outcome as recorded in the ledger
+//   - unchanged: Whether the outcome is that of an earlier run
+//   - warnings: The warnings of the conversion, if it ran
+//   - doc: The document, if the input was converted
+//
+// Returns:
+//   - error: If the report cannot be written
+func (r *batchReport) add(path string, entry stateEntry, unchanged bool, warnings []conversion.Warning, doc []byte) error {
+	if r == nil {
+		return nil
+	}
+	row := reportRow{
+		Input:     path,
+		Session:   filepath.Dir(path),
+		Status:    entry.Status,
+		Unchanged: unchanged,
+		Error:     entry.Error,
+	}
+	for _, warning := range warnings {
+		row.Warnings = append(row.Warnings, warning.String())
+	}
+	if doc != nil {
+		row.Output, row.Document = entry.Output, doc
+	}
+	if err := r.enc.Encode(row); err != nil {
+		return fmt.Errorf("failed to write run report: %w", err)
+	}
+	return nil
+}
+
+// Closes the report; a nil report has nothing to close.
+func (r *batchReport) close() error {
+	if r == nil {
+		return nil
+	}
+	if err := r.file.Close(); err != nil {
+		return fmt.Errorf("failed to write run report: %w", err)
+	}
+	return nil
+}
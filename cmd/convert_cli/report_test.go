@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The run report has a line per input with its document, marked unchanged on a re-run.
+func TestBatchReport(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	if err := os.Mkdir(in, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(in, "a.json"), []byte(`{"MicroscopeImage.microscopeData.instrument.InstrumentModel": "Krios"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	report := filepath.Join(dir, "report.jsonl")
+	for _, unchanged := range []bool{false, true} {
+		runBatch([]string{"-o", filepath.Join(dir, "out"), "-report", report, in})
+		data, err := os.ReadFile(report)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		if len(lines) != 1 {
+			t.Fatalf("got %d lines, want one per input:\n%s", len(lines), data)
+		}
+		var row reportRow
+		if err := json.Unmarshal([]byte(lines[0]), &row); err != nil {
+			t.Fatal(err)
+		}
+		if row.Status != statusConverted || row.Unchanged != unchanged || row.Session != in || !strings.Contains(string(row.Document), "Krios") {
+			t.Errorf("got %+v, want the converted document of session %s, unchanged %t", row, in, unchanged)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	conversion "github.com/osc-em/oscem-converter-extracted"
+)
+
+// JSON-RPC 2.0 error codes
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcConvertFailed  = -32000
+)
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type convertParams struct {
+	Input          json.RawMessage `json:"input"`      // flat input object
+	InputFile      string          `json:"input_file"` // alternatively, a file to read the input from
+	MappingFile    string          `json:"mapping_file"`
+	CS             string          `json:"cs"`
+	GainFlipRotate string          `json:"gain_flip_rotate"`
+	SchemaVersion  string          `json:"schema_version"`
+	Modality       string          `json:"modality"`
+}
+
+// Serves JSON-RPC 2.0 requests read from stdin, one response per line on stdout,
+// so long-running callers avoid a process start per conversion:
+//
+//	convert_cli rpc
+//	-> {"jsonrpc":"2.0","id":1,"method":"convert","params":{"input":{...},"mapping_file":"map.csv"}}
+//	<- {"jsonrpc":"2.0","id":1,"result":{"document":{...},"warnings":[]}}
+//
+// Methods: convert, fields, schema_version. The process exits when stdin is closed, or
+// after answering the request in progress on SIGTERM or Ctrl-C; a second signal aborts it.
+func runRPC(args []string) {
+	fs := flag.NewFlagSet("rpc", flag.ExitOnError)
+	fs.Parse(args)
+
+	drain, ctx, stop := shutdownContexts()
+	defer stop()
+	requests := make(chan rpcRequest)
+	go func() {
+		defer close(requests)
+		dec := json.NewDecoder(os.Stdin)
+		for {
+			var req rpcRequest
+			err := dec.Decode(&req)
+			if errors.Is(err, io.EOF) {
+				return
+			}
+			if err != nil {
+				// the stream can't be resynchronised after malformed JSON
+				json.NewEncoder(os.Stdout).Encode(rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &rpcError{rpcParseError, err.Error()}})
+				log.Fatalf("Invalid JSON-RPC input: %v", err)
+			}
+			requests <- req
+		}
+	}()
+
+	enc := json.NewEncoder(os.Stdout)
+	served := 0
+	for drain.Err() == nil {
+		var req rpcRequest
+		select {
+		case <-drain.Done():
+			continue
+		case next, ok := <-requests:
+			if !ok {
+				return
+			}
+			req = next
+		}
+		resp := handleRPC(ctx, req)
+		served++
+		if req.ID == nil {
+			continue // notification, no response expected
+		}
+		if err := enc.Encode(resp); err != nil {
+			log.Fatalf("Failed to write response: %v", err)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Served %d requests\n", served)
+}
+
+func handleRPC(ctx context.Context, req rpcRequest) rpcResponse {
+	resp := rpcResponse{JSONRPC: "2.0", ID: req.ID}
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		resp.Error = &rpcError{rpcInvalidRequest, "expected a JSON-RPC 2.0 request"}
+		return resp
+	}
+	switch req.Method {
+	case "convert":
+		var p convertParams
+		if err := json.Unmarshal(req.Params, &p); err != nil {
+			resp.Error = &rpcError{rpcInvalidParams, err.Error()}
+			return resp
+		}
+		input := []byte(p.Input)
+		if p.InputFile != "" {
+			var err error
+			input, err = os.ReadFile(p.InputFile)
+			if err != nil {
+				resp.Error = &rpcError{rpcInvalidParams, err.Error()}
+				return resp
+			}
+		}
+		res, err := conversion.ConvertDocument(ctx, input, conversion.Options{
+			MappingFile:    p.MappingFile,
+			CS:             p.CS,
+			GainFlipRotate: p.GainFlipRotate,
+			SchemaVersion:  p.SchemaVersion,
+			Modality:       p.Modality,
+			Limits:         conversion.DefaultLimits,
+		})
+		if err != nil {
+			resp.Error = &rpcError{rpcConvertFailed, err.Error()}
+			return resp
+		}
+		warnings := make([]string, 0, len(res.Warnings))
+		for _, warning := range res.Warnings {
+			warnings = append(warnings, warning.String())
+		}
+		resp.Result = map[string]interface{}{"document": json.RawMessage(res.Document), "warnings": warnings}
+	case "fields":
+		fields, err := conversion.Fields()
+		if err != nil {
+			resp.Error = &rpcError{rpcConvertFailed, err.Error()}
+			return resp
+		}
+		resp.Result = fields
+	case "schema_version":
+		resp.Result = conversion.SchemaVersion()
+	default:
+		resp.Error = &rpcError{rpcMethodNotFound, "unknown method " + req.Method}
+	}
+	return resp
+}
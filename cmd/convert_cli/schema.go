@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	conversion "github.com/osc-em/oscem-converter-extracted"
+)
+
+// Prints the JSON Schema of the documents a conversion with the given mapping and options produces:
+//
+//	convert_cli schema [-map mapping.csv] [-modality tomo] [-values bare|object] > oscem-output.schema.json
+func runSchema(args []string) {
+	fs := flag.NewFlagSet("schema", flag.ExitOnError)
+	mappingFile := fs.String("map", "", "Custom CSV mapping file path (optional, defaults to the embedded mapping)")
+	extractorName := fs.String("extractor", "", "Extractor whose embedded mapping to describe when no -map is given (optional)")
+	schemaVersion := fs.String("schema-version", "", "OSCEM schema version to describe (optional, defaults to the newest)")
+	modality := fs.String("modality", "", "Acquisition modality whose profile rows are active (optional)")
+	valueStyle := fs.String("values", "", "Representation of typed values: bare or object (optional)")
+	keepEmptySlots := fs.Bool("keep-empty-slots", false, "Allow null array elements, as written with -keep-empty-slots (optional)")
+	listPolicy := fs.String("list-policy", "", "Allow arrays for fields mapped from source lists, as written with -list-policy array (optional)")
+	provenance := fs.Bool("provenance", false, "Describe the _provenance object (optional)")
+	derivePixelSize := fs.Bool("derive-pixel-size", false, "Include the derived pixel size (optional)")
+	inventory := fs.Bool("inventory", false, "Describe instrument.inventory, as written with -active-only -inventory (optional)")
+	only := fs.String("only", "", "Comma-separated OSCEM paths whose mapping rows are converted (optional)")
+	include := fs.String("include", "", "Comma-separated OSCEM paths kept in the output (optional)")
+	exclude := fs.String("exclude", "", "Comma-separated OSCEM paths dropped from the output (optional)")
+	redact := fs.String("redact", "", "Comma-separated OSCEM paths removed from the output, \"personal\" for the built-in list (optional)")
+	pseudonymize := fs.Bool("pseudonymize", false, "Redacted strings are pseudonymized instead of removed (optional)")
+	hash := fs.String("hash", "", "Comma-separated OSCEM paths replaced by their hash (optional)")
+	checksum := fs.String("checksum", "", "Comma-separated input keys checksummed into data_files (optional)")
+	fs.Parse(args)
+
+	opts := conversion.Options{
+		MappingFile:     *mappingFile,
+		Extractor:       *extractorName,
+		SchemaVersion:   *schemaVersion,
+		Modality:        *modality,
+		ValueStyle:      *valueStyle,
+		KeepEmptySlots:  *keepEmptySlots,
+		ListPolicy:      *listPolicy,
+		Provenance:      *provenance,
+		DerivePixelSize: *derivePixelSize,
+		ActiveOnly:      *inventory,
+		Inventory:       *inventory,
+		Only:            splitList(*only),
+		Include:         splitList(*include),
+		Exclude:         splitList(*exclude),
+		Redact:          splitList(*redact),
+		Hash:            splitList(*hash),
+		ChecksumKeys:    splitList(*checksum),
+	}
+	if *pseudonymize {
+		// only whether strings are kept matters for their type
+		opts.PseudonymKey = []byte("schema")
+	}
+	schema, err := conversion.OutputSchema(opts)
+	if err != nil {
+		log.Fatalf("Failed to describe the output: %v", err)
+	}
+	os.Stdout.Write(append(schema, '\n'))
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	conversion "github.com/osc-em/oscem-converter-extracted"
+)
+
+// Serves conversions over HTTP, for ingestion pipelines that post metadata as it arrives:
+//
+//	convert_cli serve -addr :8080 -map mapping.csv -mappings krios1=krios1.csv,glacios=glacios.csv
+//	POST /convert?cs=2.7&modality=tomo    body: the input, answered with {"document": ..., "warnings": [...]}
+//	POST /reload?mapping=krios1           reloads a mapping file now
+//	GET  /fields, GET /schema_version, GET /metrics
+//
+// Requests pick one of the named mappings with the mapping query parameter or the
+// X-OSCEM-Mapping header, and use the -map or embedded mapping otherwise. Every mapping is
+// reloaded on its own when its file changes, so a broken edit only keeps that mapping at its
+// last good version, and /metrics reports conversions and reloads per mapping.
+//
+// Request bodies and the time to send them, the keys, array elements and key depth of inputs,
+// concurrent conversions and the request rate of every client are capped so a misbehaving
+// upstream cannot exhaust the node. On SIGTERM or Ctrl-C the server stops
+// accepting connections and exits once the requests in progress are answered.
+func runServe(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := fs.String("addr", ":8080", "Address to listen on (optional)")
+	mappingFile := fs.String("map", "", "Custom CSV mapping file path used when a request names no mapping, reloaded when it changes (optional)")
+	mappings := fs.String("mappings", "", "Comma-separated name=path mappings requests may select, e.g. per instrument or beamline (optional)")
+	extractorName := fs.String("extractor", "", "Registered extractor reading the inputs (optional, defaults to flat JSON)")
+	maxBody := fs.Int64("max-body", 16<<20, "Largest accepted request body in bytes (optional)")
+	maxConcurrent := fs.Int("max-concurrent", 8, "Conversions run at the same time; further requests are refused with 503 (optional)")
+	rate := fs.Float64("rate", 10, "Requests per second allowed per client address, 0 for no limit (optional)")
+	burst := fs.Int("burst", 20, "Requests a client may send at once before -rate applies (optional)")
+	maxInputKeys := fs.Int("max-input-keys", conversion.DefaultLimits.MaxInputKeys, "Largest number of keys of an input, rejected with 413 above, 0 for no limit (optional)")
+	maxArrayElements := fs.Int("max-array-elements", conversion.DefaultLimits.MaxArrayElements, "Largest number of elements of an array of an input, 0 for no limit (optional)")
+	maxDepth := fs.Int("max-depth", conversion.DefaultLimits.MaxDepth, "Largest number of \".\" separated segments of an input key, 0 for no limit (optional)")
+	readTimeout := fs.Duration("read-timeout", time.Minute, "Longest time a client may take to send a request, body included (optional)")
+	writeTimeout := fs.Duration("write-timeout", 2*time.Minute, "Longest time from the end of the request headers until the response is written (optional)")
+	fs.Parse(args)
+	if *maxConcurrent < 1 || *maxBody < 1 || *readTimeout <= 0 || *writeTimeout <= 0 {
+		log.Fatal("-max-concurrent, -max-body, -read-timeout and -write-timeout must be positive.")
+	}
+
+	drain, abort, stop := shutdownContexts()
+	defer stop()
+
+	s := &server{
+		tenants:   make(map[string]*tenant),
+		extractor: *extractorName,
+		maxBody:   *maxBody,
+		limits:    conversion.Limits{MaxInputKeys: *maxInputKeys, MaxArrayElements: *maxArrayElements, MaxDepth: *maxDepth},
+		slots:     make(chan struct{}, *maxConcurrent),
+		limiter:   newRateLimiter(*rate, *burst),
+	}
+	if *mappingFile != "" {
+		s.tenants[defaultTenant] = newTenant(drain, defaultTenant, *mappingFile)
+	}
+	for _, entry := range splitList(*mappings) {
+		name, path, ok := strings.Cut(entry, "=")
+		name, path = strings.TrimSpace(name), strings.TrimSpace(path)
+		if !ok || name == "" || path == "" {
+			log.Fatalf("Invalid -mappings entry %q, expected name=path", entry)
+		}
+		if _, exists := s.tenants[name]; exists {
+			if name == defaultTenant {
+				log.Fatalf("Mapping %q is given by both -map and -mappings, use one of them", name)
+			}
+			log.Fatalf("Mapping %q is given twice", name)
+		}
+		s.tenants[name] = newTenant(drain, name, path)
+	}
+	// -mappings default=... replaces the embedded mapping like -map
+	if s.tenants[defaultTenant] == nil {
+		s.tenants[defaultTenant] = &tenant{name: defaultTenant}
+	}
+	s.fallback = s.tenants[defaultTenant]
+	httpServer := &http.Server{
+		Addr:              *addr,
+		Handler:           s.routes(),
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       *readTimeout,
+		WriteTimeout:      *writeTimeout,
+		BaseContext:       func(net.Listener) context.Context { return abort },
+	}
+	go func() {
+		<-drain.Done()
+		httpServer.Shutdown(abort)
+	}()
+	log.Printf("Serving conversions on %s", *addr)
+	if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
+	}
+	fmt.Fprintf(os.Stderr, "Served %d conversions\n", s.served.Load())
+}
+
+// The name of the mapping used when a request names none.
+const defaultTenant = "default"
+
+// The state shared by the HTTP handlers.
+type server struct {
+	tenants   map[string]*tenant // the mappings requests may select, by name
+	fallback  *tenant            // the mapping of requests naming none
+	extractor string
+	maxBody   int64
+	limits    conversion.Limits // caps on the size of inputs
+	slots     chan struct{}     // one element per conversion in progress
+	limiter   *rateLimiter
+	served    atomic.Int64 // conversions answered
+}
+
+// A mapping hosted by the server, with its own reloads and metrics.
+type tenant struct {
+	name     string
+	reloader *conversion.MappingReloader // nil for the embedded mapping
+
+	conversions  atomic.Int64
+	failures     atomic.Int64
+	warnings     atomic.Int64
+	reloads      atomic.Int64
+	reloadErrors atomic.Int64
+}
+
+// Loads a mapping file and watches it for changes until ctx is cancelled.
+func newTenant(ctx context.Context, name string, path string) *tenant {
+	t := &tenant{name: name}
+	reloader, err := conversion.NewMappingReloader(path)
+	if err != nil {
+		log.Fatalf("Failed to load mapping %s: %v", name, err)
+	}
+	reloader.OnReload = func(*conversion.Mapping) {
+		t.reloads.Add(1)
+		log.Printf("Reloaded mapping %s from %s", name, path)
+	}
+	reloader.OnError = func(err error) {
+		t.reloadErrors.Add(1)
+		log.Printf("Kept the previous version of mapping %s: %v", name, err)
+	}
+	t.reloader = reloader
+	go reloader.Watch(ctx, 5*time.Second)
+	return t
+}
+
+// Returns the mapping a request selects, or nil if it names an unknown one.
+func (s *server) tenantOf(r *http.Request) *tenant {
+	name := r.URL.Query().Get("mapping")
+	if name == "" {
+		name = r.Header.Get("X-OSCEM-Mapping")
+	}
+	if name == "" {
+		return s.fallback
+	}
+	return s.tenants[name]
+}
+
+func (s *server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/convert", s.limit(s.handleConvert))
+	mux.HandleFunc("/fields", s.limit(func(w http.ResponseWriter, r *http.Request) {
+		fields, err := conversion.Fields()
+		if err != nil {
+			httpError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, fields)
+	}))
+	mux.HandleFunc("/schema_version", s.limit(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, conversion.SchemaVersion())
+	}))
+	mux.HandleFunc("/reload", s.limit(s.handleReload))
+	mux.HandleFunc("/metrics", s.handleMetrics)
+	return mux
+}
+
+// Reloads the selected mapping file now; the other mappings are left alone.
+func (s *server) handleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		httpError(w, http.StatusMethodNotAllowed, "POST to reload a mapping")
+		return
+	}
+	t := s.tenantOf(r)
+	switch {
+	case t == nil:
+		httpError(w, http.StatusNotFound, "unknown mapping")
+		return
+	case t.reloader == nil:
+		httpError(w, http.StatusBadRequest, "the embedded mapping cannot be reloaded")
+		return
+	}
+	// OnReload counts the successful reload, OnError is only called by Watch
+	if err := t.reloader.Reload(); err != nil {
+		t.reloadErrors.Add(1)
+		httpError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	writeJSON(w, map[string]string{"reloaded": t.name})
+}
+
+// Writes the counters of every mapping in the Prometheus text format.
+func (s *server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	names := make([]string, 0, len(s.tenants))
+	for name := range s.tenants {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	metrics := []struct {
+		name  string
+		help  string
+		value func(*tenant) int64
+	}{
+		{"oscem_conversions_total", "Conversions answered, by mapping.", func(t *tenant) int64 { return t.conversions.Load() }},
+		{"oscem_conversion_failures_total", "Conversions that failed, by mapping.", func(t *tenant) int64 { return t.failures.Load() }},
+		{"oscem_conversion_warnings_total", "Warnings of the conversions answered, by mapping.", func(t *tenant) int64 { return t.warnings.Load() }},
+		{"oscem_mapping_reloads_total", "Successful reloads of the mapping file.", func(t *tenant) int64 { return t.reloads.Load() }},
+		{"oscem_mapping_reload_errors_total", "Rejected reloads of the mapping file.", func(t *tenant) int64 { return t.reloadErrors.Load() }},
+	}
+	for _, metric := range metrics {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", metric.name, metric.help, metric.name)
+		for _, name := range names {
+			fmt.Fprintf(w, "%s{mapping=%q} %d\n", metric.name, name, metric.value(s.tenants[name]))
+		}
+	}
+}
+
+// Wraps a handler with the per-client rate limit.
+func (s *server) limit(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		client, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			client = r.RemoteAddr
+		}
+		if wait, ok := s.limiter.allow(client, time.Now()); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+			httpError(w, http.StatusTooManyRequests, "rate limit exceeded")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (s *server) handleConvert(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		httpError(w, http.StatusMethodNotAllowed, "POST the input to convert")
+		return
+	}
+	t := s.tenantOf(r)
+	if t == nil {
+		httpError(w, http.StatusNotFound, "unknown mapping")
+		return
+	}
+	// the body is read before taking a slot, so slow uploads cannot hold the slots
+	input, err := io.ReadAll(http.MaxBytesReader(w, r.Body, s.maxBody))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		httpError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body exceeds %d bytes", s.maxBody))
+		return
+	}
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	select {
+	case s.slots <- struct{}{}:
+		defer func() { <-s.slots }()
+	default:
+		httpError(w, http.StatusServiceUnavailable, "too many conversions in progress")
+		return
+	}
+	query := r.URL.Query()
+	opts := conversion.Options{
+		CS:             query.Get("cs"),
+		GainFlipRotate: query.Get("gain_flip_rotate"),
+		SchemaVersion:  query.Get("schema_version"),
+		Modality:       query.Get("modality"),
+		Extractor:      s.extractor,
+		Limits:         s.limits,
+	}
+	if t.reloader != nil {
+		opts.Mapping = t.reloader.Mapping()
+	}
+	res, err := conversion.ConvertDocument(r.Context(), input, opts)
+	var limitErr *conversion.LimitError
+	if errors.As(err, &limitErr) {
+		t.failures.Add(1)
+		httpError(w, http.StatusRequestEntityTooLarge, err.Error())
+		return
+	}
+	if err != nil {
+		t.failures.Add(1)
+		httpError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	s.served.Add(1)
+	t.conversions.Add(1)
+	t.warnings.Add(int64(len(res.Warnings)))
+	warnings := make([]string, 0, len(res.Warnings))
+	for _, warning := range res.Warnings {
+		warnings = append(warnings, warning.String())
+	}
+	writeJSON(w, map[string]interface{}{"document": json.RawMessage(res.Document), "warnings": warnings})
+}
+
+// Writes a JSON response.
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(value)
+}
+
+// Writes a JSON error response with the given status.
+func httpError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
+
+// Limits the request rate per client with a token bucket each: a client may send burst
+// requests at once, refilled at rate per second.
+type rateLimiter struct {
+	rate  float64
+	burst float64
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Returns a limiter allowing rate requests per second and client; a rate of 0 or less
+// allows any rate.
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{rate: rate, burst: float64(burst), buckets: make(map[string]*tokenBucket)}
+}
+
+// Takes a token from the client's bucket. If none is left, it returns how long until the
+// next one is available.
+func (l *rateLimiter) allow(client string, now time.Time) (time.Duration, bool) {
+	if l.rate <= 0 {
+		return 0, true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.buckets[client]
+	if !ok {
+		if len(l.buckets) > 10000 {
+			l.forgetIdle(now)
+		}
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[client] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * l.rate
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) / l.rate * float64(time.Second)), false
+	}
+	b.tokens--
+	return 0, true
+}
+
+// Drops the buckets of clients that have been quiet long enough to be full again, so the
+// limiter does not grow with every address it has seen.
+func (l *rateLimiter) forgetIdle(now time.Time) {
+	full := time.Duration(l.burst / l.rate * float64(time.Second))
+	for client, b := range l.buckets {
+		if now.Sub(b.last) > full {
+			delete(l.buckets, client)
+		}
+	}
+}
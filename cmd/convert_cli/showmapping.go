@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	conversion "github.com/osc-em/oscem-converter-extracted"
+)
+
+// Prints the embedded default mapping table:
+//
+//	convert_cli show-mapping [-format csv|yaml] [-schema-version 1.x]
+func runShowMapping(args []string) {
+	fs := flag.NewFlagSet("show-mapping", flag.ExitOnError)
+	format := fs.String("format", "csv", "Output format: csv or yaml")
+	schemaVersion := fs.String("schema-version", "", "OSCEM schema version whose mapping to show (optional, defaults to the newest)")
+	fs.Parse(args)
+
+	raw, err := conversion.DefaultMapping(*schemaVersion)
+	if err != nil {
+		log.Fatal(err)
+	}
+	switch strings.ToLower(*format) {
+	case "csv":
+		os.Stdout.Write(raw)
+	case "yaml", "yml":
+		out, err := mappingToYAML(raw)
+		if err != nil {
+			log.Fatalf("Failed to render mapping: %v", err)
+		}
+		os.Stdout.Write(out)
+	default:
+		log.Fatalf("Unknown format %q, use csv or yaml.", *format)
+	}
+}
+
+// Renders a mapping CSV as a YAML list with one entry per non-blank row.
+// Empty cells are omitted and values are written as double-quoted scalars.
+func mappingToYAML(raw []byte) ([]byte, error) {
+	records, err := csv.NewReader(bytes.NewReader(raw)).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("empty mapping")
+	}
+	header := records[0]
+	for i, h := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(strings.TrimLeft(h, "\ufeff")))
+	}
+
+	var buf bytes.Buffer
+	for _, row := range records[1:] {
+		first := true
+		for i, cell := range row {
+			if cell == "" || i >= len(header) {
+				continue
+			}
+			quoted, _ := json.Marshal(cell)
+			if first {
+				buf.WriteString("- ")
+				first = false
+			} else {
+				buf.WriteString("  ")
+			}
+			fmt.Fprintf(&buf, "%s: %s\n", header[i], quoted)
+		}
+	}
+	return buf.Bytes(), nil
+}
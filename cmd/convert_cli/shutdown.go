@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// The signals asking a long-running mode to stop: Ctrl-C, and SIGTERM as sent by systemd
+// and Kubernetes.
+var shutdownSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+
+// Returns two contexts for a graceful shutdown: drain is cancelled on the first shutdown
+// signal, telling the mode to take no new work, and abort on the second, to give up the
+// conversions still in progress. Call stop once the mode has finished.
+func shutdownContexts() (drain context.Context, abort context.Context, stop func()) {
+	drain, stopDrain := context.WithCancel(context.Background())
+	abort, stopAbort := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, shutdownSignals...)
+	go func() {
+		if _, ok := <-signals; !ok {
+			return
+		}
+		fmt.Fprintln(os.Stderr, "Shutting down once the work in progress is done, signal again to abort it")
+		stopDrain()
+		if _, ok := <-signals; ok {
+			stopAbort()
+		}
+	}()
+	stop = func() {
+		signal.Stop(signals)
+		close(signals)
+		stopDrain()
+		stopAbort()
+	}
+	return drain, abort, stop
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"time"
+)
+
+// Name of the ledger batch runs keep in their output directory, or in the working
+// directory when outputs are written next to their inputs.
+const batchStateName = ".oscem-batch-state.json"
+
+// The ledger of a batch: which inputs it processed with which settings and how that went,
+// so a re-run, e.g. after a restart, only converts inputs that are new or changed since.
+type batchState struct {
+	path  string
+	Files map[string]stateEntry `json:"files"` // by absolute input path
+
+	journal   *os.File // the outcomes recorded since the state file was last written, see record
+	journaled int      // the records in the journal
+}
+
+// An outcome appended to the journal of a state file.
+type journalRecord struct {
+	Key   string     `json:"key"`
+	Entry stateEntry `json:"entry"`
+}
+
+// Records the journal holds at least before the state file is rewritten with them; beyond
+// that it is rewritten once the journal holds as many records as the state has inputs, so
+// recording n inputs writes the state file O(log n) times rather than n times.
+const minJournaled = 64
+
+// The outcomes of a conversion recorded in the ledger.
+const (
+	statusConverted = "converted"
+	statusFailed    = "failed"
+)
+
+// The conversion of one input as recorded in the state file.
+type stateEntry struct {
+	Input    string `json:"input_sha256"`    // checksum of the input file, empty if it could not be read
+	Settings string `json:"settings_sha256"` // checksum of the mapping and options, see settingsChecksum
+	Output   string `json:"output"`          // the written document
+	Status   string `json:"status"`          // one of statusConverted and statusFailed
+	Error    string `json:"error,omitempty"` // why the conversion failed
+	Warnings int    `json:"warnings,omitempty"`
+	Time     string `json:"time"` // when the input was processed, in RFC 3339
+}
+
+// Loads the state file at path, or starts an empty state if it does not exist yet, with the
+// outcomes its journal recorded since it was last written, e.g. by a run that was killed.
+func loadBatchState(path string) (*batchState, error) {
+	state := &batchState{path: path, Files: make(map[string]stateEntry)}
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		// a first run killed before it wrote the state file only left its journal
+	case err != nil:
+		return nil, fmt.Errorf("failed to read batch state: %w", err)
+	default:
+		if err := json.Unmarshal(data, state); err != nil {
+			return nil, fmt.Errorf("failed to read batch state %s: %w", path, err)
+		}
+		if state.Files == nil {
+			state.Files = make(map[string]stateEntry)
+		}
+	}
+	if err := state.replayJournal(); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// Applies the records of the journal to the state. A record cut short by a crash ends it.
+func (s *batchState) replayJournal() error {
+	f, err := os.Open(s.journalPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read batch state journal: %w", err)
+	}
+	defer f.Close()
+	dec := json.NewDecoder(f)
+	for {
+		var record journalRecord
+		if err := dec.Decode(&record); err != nil {
+			return nil
+		}
+		s.Files[record.Key] = record.Entry
+		s.journaled++
+	}
+}
+
+// Returns the path of the journal of the state file.
+func (s *batchState) journalPath() string {
+	return s.path + ".journal"
+}
+
+// Returns the earlier outcome for an input processed from the same content and settings.
+// Conversions whose document no longer exists do not count.
+func (s *batchState) previous(key string, entry stateEntry) (stateEntry, bool) {
+	recorded, ok := s.Files[key]
+	if !ok || recorded.Input != entry.Input || recorded.Settings != entry.Settings || recorded.Output != entry.Output {
+		return stateEntry{}, false
+	}
+	if recorded.Status == statusConverted {
+		if _, err := os.Stat(recorded.Output); err != nil {
+			return stateEntry{}, false
+		}
+	}
+	return recorded, true
+}
+
+// Records the outcome of processing an input by appending it to the journal of the state
+// file, which is folded into the state file once it grew, see minJournaled, and by close.
+func (s *batchState) record(key string, entry stateEntry) error {
+	entry.Time = time.Now().UTC().Format(time.RFC3339)
+	s.Files[key] = entry
+	if s.journal == nil {
+		f, err := os.OpenFile(s.journalPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to write batch state: %w", err)
+		}
+		s.journal = f
+	}
+	data, err := json.Marshal(journalRecord{Key: key, Entry: entry})
+	if err != nil {
+		return err
+	}
+	if _, err := s.journal.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write batch state: %w", err)
+	}
+	s.journaled++
+	if s.journaled >= minJournaled && s.journaled >= len(s.Files) {
+		return s.compact()
+	}
+	return nil
+}
+
+// Writes the state file with everything recorded and removes the journal.
+func (s *batchState) close() error {
+	if s.journaled == 0 && s.journal == nil {
+		return nil
+	}
+	return s.compact()
+}
+
+// Writes the state file through a temporary file, so an interrupted run never leaves a
+// truncated ledger behind, and then removes the journal it now holds; a journal left behind
+// by a crash in between is applied again on load, which changes nothing.
+func (s *batchState) compact() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write batch state: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("failed to write batch state: %w", err)
+	}
+	if s.journal != nil {
+		s.journal.Close()
+		s.journal = nil
+	}
+	if err := os.Remove(s.journalPath()); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to write batch state: %w", err)
+	}
+	s.journaled = 0
+	return nil
+}
+
+// Returns the hex SHA-256 of data.
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Returns a checksum over everything besides the input that shapes a document: the mapping
+// file, the conversion flags and the build of the converter, whose embedded tables are the
+// mapping when no file is given.
+func settingsChecksum(mappingFile string, settings map[string]string) (string, error) {
+	if mappingFile != "" {
+		mapping, err := os.ReadFile(mappingFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read mapping: %w", err)
+		}
+		settings["mapping_sha256"] = checksum(mapping)
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		settings["build"] = info.Main.Version
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" || setting.Key == "vcs.modified" {
+				settings["build"] += " " + setting.Value
+			}
+		}
+	}
+	// maps are encoded with sorted keys
+	data, _ := json.Marshal(settings)
+	return checksum(data), nil
+}
+
+// Returns the absolute form of a path, to key the state independently of the working directory.
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
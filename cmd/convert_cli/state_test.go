@@ -0,0 +1,87 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Outcomes journaled by a run killed before it wrote the state file are loaded again, up to a
+// record cut short by the crash.
+func TestBatchStateReplaysTruncatedJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), batchStateName)
+	state, err := loadBatchState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a.json", "b.json"} {
+		if err := state.record(key, stateEntry{Input: "sum-" + key, Status: statusConverted}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// killed while appending the third record
+	if _, err := state.journal.WriteString(`{"key":"c.json","entry":{"input_sha`); err != nil {
+		t.Fatal(err)
+	}
+	state.journal.Close()
+
+	loaded, err := loadBatchState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Files) != 2 || loaded.Files["b.json"].Input != "sum-b.json" {
+		t.Fatalf("got %v, want the outcomes of a.json and b.json", loaded.Files)
+	}
+	if err := loaded.close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(loaded.journalPath()); !os.IsNotExist(err) {
+		t.Errorf("journal left behind after close: %v", err)
+	}
+	reloaded, err := loadBatchState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reloaded.Files) != 2 {
+		t.Errorf("got %v from the compacted state file, want the outcomes of a.json and b.json", reloaded.Files)
+	}
+}
+
+// A re-run leaves the documents of unchanged inputs alone and converts changed ones again.
+func TestBatchSkipsUnchangedInputs(t *testing.T) {
+	dir := t.TempDir()
+	in, out := filepath.Join(dir, "in"), filepath.Join(dir, "out")
+	if err := os.Mkdir(in, 0755); err != nil {
+		t.Fatal(err)
+	}
+	inputs := map[string]string{"a": `{"MicroscopeImage.microscopeData.instrument.InstrumentModel": "Krios"}`, "b": `{"MicroscopeImage.microscopeData.instrument.InstrumentModel": "Glacios"}`}
+	for name, input := range inputs {
+		if err := os.WriteFile(filepath.Join(in, name+".json"), []byte(input), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	runBatch([]string{"-o", out, in})
+
+	// mark the documents, so a conversion replacing them shows
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	for name := range inputs {
+		if err := os.Chtimes(filepath.Join(out, name+".oscem.json"), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(in, "b.json"), []byte(`{"MicroscopeImage.microscopeData.instrument.InstrumentModel": "Talos"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	runBatch([]string{"-o", out, in})
+
+	for name, converted := range map[string]bool{"a": false, "b": true} {
+		info, err := os.Stat(filepath.Join(out, name+".oscem.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.ModTime().Equal(old) == converted {
+			t.Errorf("%s.json: converted again is %t, want %t", name, !converted, converted)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	conversion "github.com/osc-em/oscem-converter-extracted"
+)
+
+// Checks documents against the detached signatures written by -sign-key:
+//
+//	convert_cli verify -pub facility.pub doc1.json doc2.json
+func runVerify(args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	pubFile := fs.String("pub", "", "PEM Ed25519 public key of the signing pipeline (required)")
+	fs.Parse(args)
+
+	if *pubFile == "" {
+		log.Fatal("verify requires -pub.")
+	}
+	if fs.NArg() == 0 {
+		log.Fatal("verify requires at least one OSCEM JSON file.")
+	}
+	key, err := conversion.LoadVerifyKey(*pubFile)
+	if err != nil {
+		log.Fatalf("Failed to load public key: %v", err)
+	}
+
+	failed := false
+	for _, path := range fs.Args() {
+		doc, err := os.ReadFile(path)
+		if err == nil {
+			var jws []byte
+			if jws, err = os.ReadFile(path + ".jws"); err == nil {
+				err = conversion.VerifyDocument(doc, string(jws), key)
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			failed = true
+			continue
+		}
+		fmt.Printf("%s: signature valid\n", path)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
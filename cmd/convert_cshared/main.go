@@ -0,0 +1,96 @@
+// Builds the converter as a shared library with a small, stable C ABI, so that
+// Python or LabVIEW based acquisition software can convert in-process:
+//
+//	go build -buildmode=c-shared -o liboscem.so ./cmd/convert_cshared
+//
+// This also writes liboscem.h declaring:
+//
+//	char* OscemConvertJSON(char* input, char* mapping, char* options, char** err);
+//	void  OscemFree(char* p);
+//	char* OscemSchemaVersion(void);
+//
+// input is the flat metadata JSON, mapping an optional custom mapping CSV (NULL or
+// empty for the embedded one) and options an optional JSON object with the keys
+// cs, gain_flip_rotate, schema_version and modality. On success the document is returned and
+// *err is set to NULL; on failure NULL is returned and *err holds the message.
+// Every non-NULL string returned by the library must be released with OscemFree.
+// These signatures are kept stable across releases.
+package main
+
+/*
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"unsafe"
+
+	conversion "github.com/osc-em/oscem-converter-extracted"
+)
+
+type cOptions struct {
+	CS             string `json:"cs"`
+	GainFlipRotate string `json:"gain_flip_rotate"`
+	SchemaVersion  string `json:"schema_version"`
+	Modality       string `json:"modality"`
+}
+
+//export OscemConvertJSON
+func OscemConvertJSON(input *C.char, mapping *C.char, options *C.char, errOut **C.char) *C.char {
+	doc, err := convertJSON(goString(input), goString(mapping), goString(options))
+	if err != nil {
+		if errOut != nil {
+			*errOut = C.CString(err.Error())
+		}
+		return nil
+	}
+	if errOut != nil {
+		*errOut = nil
+	}
+	return C.CString(string(doc))
+}
+
+//export OscemFree
+func OscemFree(p *C.char) {
+	C.free(unsafe.Pointer(p))
+}
+
+//export OscemSchemaVersion
+func OscemSchemaVersion() *C.char {
+	return C.CString(conversion.SchemaVersion())
+}
+
+func convertJSON(input, mapping, options string) ([]byte, error) {
+	var opts conversion.Options
+	if options != "" {
+		var parsed cOptions
+		if err := json.Unmarshal([]byte(options), &parsed); err != nil {
+			return nil, err
+		}
+		opts.CS = parsed.CS
+		opts.GainFlipRotate = parsed.GainFlipRotate
+		opts.SchemaVersion = parsed.SchemaVersion
+		opts.Modality = parsed.Modality
+	}
+	if mapping != "" {
+		m, err := conversion.ParseMapping(strings.NewReader(mapping))
+		if err != nil {
+			return nil, err
+		}
+		opts.Mapping = m
+	}
+	return conversion.ConvertBytes(context.Background(), []byte(input), opts)
+}
+
+func goString(s *C.char) string {
+	if s == nil {
+		return ""
+	}
+	return C.GoString(s)
+}
+
+// required by -buildmode=c-shared
+func main() {}
@@ -0,0 +1,71 @@
+//go:build js && wasm
+
+// Exposes the converter to JavaScript, e.g. for previewing conversions in a
+// browser-based metadata editor. Build with:
+//
+//	GOOS=js GOARCH=wasm go build -o oscem.wasm ./cmd/convert_wasm
+//
+// and load it with the wasm_exec.js shipped with Go. It registers a global
+// function
+//
+//	oscemConvert(input, mapping?, options?) -> {document} | {error}
+//
+// taking the flat input JSON as string, an optional custom mapping CSV as string
+// and an optional object with cs, gainFlipRotate, schemaVersion and modality. Everything
+// happens in memory, no files are read or written.
+package main
+
+import (
+	"context"
+	"strings"
+	"syscall/js"
+
+	conversion "github.com/osc-em/oscem-converter-extracted"
+)
+
+func main() {
+	js.Global().Set("oscemConvert", js.FuncOf(convert))
+	// keep the Go runtime alive so the function stays callable
+	select {}
+}
+
+func convert(this js.Value, args []js.Value) any {
+	if len(args) == 0 || args[0].Type() != js.TypeString {
+		return result("", "oscemConvert expects the input JSON as first argument")
+	}
+	var opts conversion.Options
+	if len(args) > 1 && args[1].Type() == js.TypeString && args[1].String() != "" {
+		mapping, err := conversion.ParseMapping(strings.NewReader(args[1].String()))
+		if err != nil {
+			return result("", err.Error())
+		}
+		opts.Mapping = mapping
+	}
+	if len(args) > 2 && args[2].Type() == js.TypeObject {
+		opts.CS = stringField(args[2], "cs")
+		opts.GainFlipRotate = stringField(args[2], "gainFlipRotate")
+		opts.SchemaVersion = stringField(args[2], "schemaVersion")
+		opts.Modality = stringField(args[2], "modality")
+	}
+
+	doc, err := conversion.ConvertBytes(context.Background(), []byte(args[0].String()), opts)
+	if err != nil {
+		return result("", err.Error())
+	}
+	return result(string(doc), "")
+}
+
+func stringField(obj js.Value, name string) string {
+	v := obj.Get(name)
+	if v.Type() == js.TypeUndefined || v.Type() == js.TypeNull {
+		return ""
+	}
+	return v.String()
+}
+
+func result(doc string, errMsg string) any {
+	if errMsg != "" {
+		return map[string]any{"error": errMsg}
+	}
+	return map[string]any{"document": doc}
+}
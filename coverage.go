@@ -0,0 +1,161 @@
+package conversion
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Coverage counts how often each OSCEM field is present across a set of converted documents.
+// Array elements are counted under their [N] path, once per document.
+type Coverage struct {
+	Documents int            `json:"documents"`
+	Present   map[string]int `json:"present"`
+}
+
+// Counts the fields of one converted document.
+func (c *Coverage) Add(doc []byte) error {
+	var data interface{}
+	if err := json.Unmarshal(doc, &data); err != nil {
+		return fmt.Errorf("document is not valid JSON: %w", err)
+	}
+	fields := make(map[string]bool)
+	collectLeafPaths(data, "", fields)
+	if c.Present == nil {
+		c.Present = make(map[string]int)
+	}
+	c.Documents++
+	for path := range fields {
+		c.Present[path]++
+	}
+	return nil
+}
+
+// Returns the share of documents holding the field, between 0 and 1.
+func (c *Coverage) Rate(path string) float64 {
+	if c.Documents == 0 {
+		return 0
+	}
+	return float64(c.Present[path]) / float64(c.Documents)
+}
+
+// Records the path of every value in a decoded document. Objects holding just a value
+// and a unit are values themselves.
+func collectLeafPaths(node interface{}, path string, fields map[string]bool) {
+	switch v := node.(type) {
+	case map[string]interface{}:
+		if _, hasValue := v["value"]; hasValue && len(v) <= 2 {
+			if _, hasUnit := v["unit"]; hasUnit || len(v) == 1 {
+				fields[path] = true
+				return
+			}
+		}
+		for key, child := range v {
+			collectLeafPaths(child, joinOutputPath(path, key), fields)
+		}
+	case []interface{}:
+		for _, elem := range v {
+			collectLeafPaths(elem, path+"[N]", fields)
+		}
+	case nil:
+	default:
+		fields[path] = true
+	}
+}
+
+// CoverageReport aggregates field coverage over a batch, overall and grouped
+// per session and per instrument, as a metadata quality overview.
+type CoverageReport struct {
+	Overall     Coverage             `json:"overall"`
+	Sessions    map[string]*Coverage `json:"sessions"`
+	Instruments map[string]*Coverage `json:"instruments"`
+}
+
+// Creates an empty report.
+func NewCoverageReport() *CoverageReport {
+	return &CoverageReport{
+		Sessions:    make(map[string]*Coverage),
+		Instruments: make(map[string]*Coverage),
+	}
+}
+
+// Counts a converted document for the batch, its session and its instrument.
+// Empty session or instrument names leave the document out of that grouping.
+func (r *CoverageReport) Add(doc []byte, session string, instrument string) error {
+	if err := r.Overall.Add(doc); err != nil {
+		return err
+	}
+	for _, group := range []struct {
+		groups map[string]*Coverage
+		name   string
+	}{{r.Sessions, session}, {r.Instruments, instrument}} {
+		if group.name == "" {
+			continue
+		}
+		if group.groups[group.name] == nil {
+			group.groups[group.name] = &Coverage{}
+		}
+		if err := group.groups[group.name].Add(doc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Writes the report as CSV with one row per group and field, listing every field found
+// anywhere in the batch so missing fields show up with a rate of 0.
+func (r *CoverageReport) WriteCSV(w io.Writer) error {
+	fields := make([]string, 0, len(r.Overall.Present))
+	for path := range r.Overall.Present {
+		fields = append(fields, path)
+	}
+	sort.Strings(fields)
+
+	out := csv.NewWriter(w)
+	out.Write([]string{"group", "name", "field", "present", "documents", "rate"})
+	write := func(group, name string, c *Coverage) {
+		for _, path := range fields {
+			out.Write([]string{group, name, path, strconv.Itoa(c.Present[path]), strconv.Itoa(c.Documents),
+				strconv.FormatFloat(c.Rate(path), 'f', 4, 64)})
+		}
+	}
+	write("overall", "", &r.Overall)
+	for _, grouping := range []struct {
+		group  string
+		groups map[string]*Coverage
+	}{{"session", r.Sessions}, {"instrument", r.Instruments}} {
+		names := make([]string, 0, len(grouping.groups))
+		for name := range grouping.groups {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			write(grouping.group, name, grouping.groups[name])
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// Returns the value at a "." separated path of a converted document as text, unwrapping
+// value and unit objects, or "" if it is missing.
+func DocumentValue(doc []byte, path string) string {
+	var data map[string]interface{}
+	if err := json.Unmarshal(doc, &data); err != nil {
+		return ""
+	}
+	value := lookupPath(data, strings.Split(path, "."))
+	if m, ok := value.(map[string]interface{}); ok {
+		value = m["value"]
+	}
+	switch v := value.(type) {
+	case nil, map[string]interface{}, []interface{}:
+		return ""
+	default:
+		return fmt.Sprint(v)
+	}
+}
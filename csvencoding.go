@@ -0,0 +1,131 @@
+package conversion
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Windows-1252 characters for the bytes 0x80-0x9F; everything else maps to the
+// Unicode code point of the same value, as in Latin-1.
+var cp1252 = [32]rune{
+	'€', 0x81, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', 0x8D, 'Ž', 0x8F,
+	0x90, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', 0x9D, 'ž', 'Ÿ',
+}
+
+// Decodes a mapping file or JSON input to UTF-8. Excel and other Windows tools save CSVs,
+// and some extraction tools their JSON, as UTF-16 (with BOM), UTF-8 with BOM or the legacy
+// Windows-1252 code page, so:
+//   - a UTF-16 (LE or BE) or UTF-8 byte order mark selects that encoding
+//   - text without BOM where every other byte is NUL is read as BOM-less UTF-16
+//   - valid UTF-8 is used as is
+//   - anything else is decoded as Windows-1252, a superset of Latin-1
+//
+// Parameters:
+//   - raw: File content as read from disk
+//
+// Returns:
+//   - string: The content as UTF-8 without BOM
+//   - error: If the content looks like UTF-16 but cannot be decoded as such
+func decodeText(raw []byte) (string, error) {
+	switch {
+	case bytes.HasPrefix(raw, []byte{0xEF, 0xBB, 0xBF}):
+		return string(raw[3:]), nil
+	case bytes.HasPrefix(raw, []byte{0xFF, 0xFE}):
+		return decodeUTF16(raw[2:], false)
+	case bytes.HasPrefix(raw, []byte{0xFE, 0xFF}):
+		return decodeUTF16(raw[2:], true)
+	}
+	if len(raw) >= 2 {
+		if oddNUL, evenNUL := nulRatio(raw); oddNUL > 0.4 && evenNUL < 0.05 {
+			return decodeUTF16(raw, false)
+		} else if evenNUL > 0.4 && oddNUL < 0.05 {
+			return decodeUTF16(raw, true)
+		}
+	}
+	if utf8.Valid(raw) {
+		return string(raw), nil
+	}
+	var sb strings.Builder
+	sb.Grow(len(raw))
+	for _, b := range raw {
+		if b >= 0x80 && b <= 0x9F {
+			sb.WriteRune(cp1252[b-0x80])
+		} else {
+			sb.WriteRune(rune(b))
+		}
+	}
+	return sb.String(), nil
+}
+
+// Returns the share of NUL bytes at odd and even offsets.
+func nulRatio(raw []byte) (float64, float64) {
+	var odd, even int
+	for i, b := range raw {
+		if b != 0 {
+			continue
+		}
+		if i%2 == 1 {
+			odd++
+		} else {
+			even++
+		}
+	}
+	half := float64(len(raw)) / 2
+	return float64(odd) / half, float64(even) / half
+}
+
+func decodeUTF16(raw []byte, bigEndian bool) (string, error) {
+	if len(raw)%2 != 0 {
+		return "", fmt.Errorf("text looks like UTF-16 but has an odd number of bytes; save it as UTF-8")
+	}
+	units := make([]uint16, len(raw)/2)
+	for i := range units {
+		if bigEndian {
+			units[i] = uint16(raw[2*i])<<8 | uint16(raw[2*i+1])
+		} else {
+			units[i] = uint16(raw[2*i+1])<<8 | uint16(raw[2*i])
+		}
+	}
+	return string(utf16.Decode(units)), nil
+}
+
+// Detects the delimiter of a mapping from its header line: whichever of comma,
+// semicolon and tab occurs most often outside quotes.
+func detectDelimiter(text string) (rune, error) {
+	header := text
+	for {
+		line, rest, found := strings.Cut(header, "\n")
+		if strings.TrimSpace(line) != "" && !strings.HasPrefix(line, "#") {
+			header = line
+			break
+		}
+		if !found {
+			return 0, fmt.Errorf("empty CSV file")
+		}
+		header = rest
+	}
+
+	counts := map[rune]int{}
+	inQuotes := false
+	for _, r := range header {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+		case !inQuotes && (r == ',' || r == ';' || r == '\t'):
+			counts[r]++
+		}
+	}
+	best, bestCount := rune(0), 0
+	for _, candidate := range []rune{',', ';', '\t'} {
+		if counts[candidate] > bestCount {
+			best, bestCount = candidate, counts[candidate]
+		}
+	}
+	if bestCount == 0 {
+		return 0, fmt.Errorf("could not detect the delimiter of the mapping header %q; use comma, semicolon or tab", header)
+	}
+	return best, nil
+}
@@ -0,0 +1,133 @@
+package conversion
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/osc-em/oscem-converter-extracted/basetypes"
+)
+
+// Loads a converted OSCEM document back into the map form the converter builds, with basetypes
+// values in place of JSON leaves, so documents can be edited and written again with
+// MarshalDocument instead of by raw JSON munging.
+//
+// Leaves are typed by the field list of the embedded mapping (see Fields), so an Int field stays
+// an Int even where it holds a whole number and {value, unit} objects become numbers with a unit.
+// A null leaf of a known field becomes an unset basetype, which MarshalDocument drops again.
+// Fields the mapping does not know are typed by their JSON value; _provenance is kept as decoded.
+//
+// Parameters:
+//   - doc: The OSCEM JSON document, e.g. as returned by Convert
+//
+// Returns:
+//   - map[string]interface{}: The document with basetypes leaves
+//   - error: If the document is not a JSON object
+func LoadDocument(doc []byte) (map[string]interface{}, error) {
+	data, err := decodeDocument(doc)
+	if err != nil {
+		return nil, err
+	}
+	fields, err := Fields()
+	if err != nil {
+		return nil, err
+	}
+	types := make(map[string]string, len(fields))
+	for _, field := range fields {
+		types[strings.Join(field.Path, ".")] = strings.ToLower(field.Type)
+	}
+	for key, value := range data {
+		if key == "_provenance" {
+			continue
+		}
+		data[key] = loadValue(value, key, types)
+	}
+	return data, nil
+}
+
+// Writes a document loaded with LoadDocument, or built from basetypes values, as indented
+// OSCEM JSON, dropping unset values like a conversion does.
+func MarshalDocument(doc map[string]interface{}) ([]byte, error) {
+	return json.MarshalIndent(cleanValue(doc, false), "", "  ")
+}
+
+// Converts a decoded JSON value at the given field path, with [N] marking array elements,
+// into basetypes values.
+func loadValue(value interface{}, path string, types map[string]string) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if number, ok := v["value"].(json.Number); ok && isValueObject(v) {
+			unit, _ := v["unit"].(string)
+			return loadNumber(number, unit, types[path])
+		}
+		for key, elem := range v {
+			v[key] = loadValue(elem, path+"."+key, types)
+		}
+		return v
+	case []interface{}:
+		for i, elem := range v {
+			v[i] = loadValue(elem, path+"[N]", types)
+		}
+		return v
+	case json.Number:
+		return loadNumber(v, "", types[path])
+	case bool:
+		var out basetypes.Bool
+		out.Set(v)
+		return out
+	case string:
+		var out basetypes.String
+		out.Set(v)
+		return out
+	case nil:
+		return unsetValue(types[path])
+	default:
+		return v
+	}
+}
+
+// Reports whether an object is a number with its unit, as written for fields with units.
+func isValueObject(m map[string]interface{}) bool {
+	for key := range m {
+		if key != "value" && key != "unit" {
+			return false
+		}
+	}
+	return true
+}
+
+// Types a JSON number as an Int or Float64, preferring the type of the mapping field and
+// falling back to a Float64 for numbers that are not whole.
+func loadNumber(number json.Number, unit string, fieldType string) interface{} {
+	if fieldType != "float" && fieldType != "float64" {
+		if val, err := strconv.ParseInt(number.String(), 10, 64); err == nil {
+			var out basetypes.Int
+			out.Set(val, unit)
+			return out
+		}
+	}
+	val, err := number.Float64()
+	if err != nil {
+		// Out of the float64 range, keep it as written
+		return number
+	}
+	var out basetypes.Float64
+	out.Set(val, unit)
+	return out
+}
+
+// Returns the unset basetype for a mapping field type, or nil for unknown fields.
+func unsetValue(fieldType string) interface{} {
+	switch fieldType {
+	case "int":
+		return basetypes.Int{}
+	case "float", "float64":
+		return basetypes.Float64{}
+	case "bool":
+		return basetypes.Bool{}
+	case "string":
+		return basetypes.String{}
+	default:
+		return nil
+	}
+}
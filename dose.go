@@ -0,0 +1,107 @@
+package conversion
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A dose unit the crunch column can name for values that are not given per Å², such as the
+// per-pixel dose rates reported by counting cameras.
+type doseUnit struct {
+	PerPixel  bool // divide by the pixel area, taken from acquisition.pixel_size
+	PerSecond bool // multiply by the exposure time, taken from acquisition.exposure_time
+}
+
+// Dose units known to the converter, converted to the electrons per Å² of the OSCEM dose fields.
+var doseUnits = map[string]doseUnit{
+	"e/px":    {PerPixel: true},
+	"e/px/s":  {PerPixel: true, PerSecond: true},
+	"e/Å^2/s": {PerSecond: true},
+	"e/Å²/s":  {PerSecond: true},
+}
+
+// Units of the OSCEM dose fields, all meaning electrons per Å².
+var areaDoseUnits = map[string]bool{
+	"1/Å^2": true,
+	"1/Å²":  true,
+	"e/Å^2": true,
+	"e/Å²":  true,
+}
+
+// Converts a dose to electrons per Å², using the pixel size and exposure time the same input
+// yields through the mapping. The raw value is returned along with the error when the reference
+// values are unavailable.
+//
+// Parameters:
+//   - rawValue: The dose as found in the input
+//   - unit: The dose unit of the input, one of doseUnits
+//   - row: The mapping row of the dose field, whose unit must be an area dose
+//
+// Returns:
+//   - string: The converted dose
+//   - error: If the row's unit is not per Å², or the pixel size or exposure time is missing
+func (c *converter) convertDose(rawValue string, unit doseUnit, row csvextract) (string, error) {
+	if !areaDoseUnits[normalizeUnit(row.Units)] {
+		return rawValue, fmt.Errorf("dose can only be converted to electrons per Å², not %q", row.Units)
+	}
+	dose, err := strconv.ParseFloat(strings.TrimSpace(rawValue), 64)
+	if err != nil {
+		return rawValue, err
+	}
+	if unit.PerPixel {
+		pixelSize, err := c.referenceValue("acquisition.pixel_size", "Å")
+		if err != nil {
+			return rawValue, err
+		}
+		dose /= pixelSize * pixelSize
+	}
+	if unit.PerSecond {
+		exposure, err := c.referenceValue("acquisition.exposure_time", "s")
+		if err != nil {
+			return rawValue, err
+		}
+		dose *= exposure
+	}
+	return strconv.FormatFloat(dose, 'g', 15, 64), nil
+}
+
+// Looks up a regular field the mapping fills from the current input, such as the pixel size
+// a per-pixel dose refers to, converted to the given unit.
+func (c *converter) referenceValue(oscem string, unit string) (float64, error) {
+	// Only plain keys are looked up, so the lookup has no side effects on the conversion
+	lookup := func(_ csvextract, input map[string]string, key string) ([]string, bool) {
+		value, ok := input[key]
+		return []string{value}, ok && !strings.Contains(key, ";")
+	}
+	for _, row := range c.rows {
+		if row.OSCEM != oscem {
+			continue
+		}
+		values, crunch, _, found := findMatchingValues(row, c.input, lookup)
+		if !found || allEmpty(values) {
+			continue
+		}
+		extracted, ok := extractValue(row, values[0])
+		if !ok {
+			return 0, fmt.Errorf("%s is unusable: %q does not match the extract pattern %s", oscem, values[0], row.Extract)
+		}
+		converted, err := applyUnitCrunch(elementCrunch(crunch, 0), extracted, row.Units)
+		if err != nil {
+			return 0, fmt.Errorf("%s is unusable: %w", oscem, err)
+		}
+		value, err := strconv.ParseFloat(strings.TrimSpace(converted), 64)
+		if err != nil || value <= 0 {
+			return 0, fmt.Errorf("%s is unusable: %q is no positive number", oscem, converted)
+		}
+		if normalizeUnit(row.Units) != unit {
+			factor, err := crunchMultiplier(row.Units, unit)
+			if err != nil {
+				return 0, fmt.Errorf("%s is unusable: %w", oscem, err)
+			}
+			value *= factor
+		}
+		return value, nil
+	}
+	return 0, fmt.Errorf("%s is unavailable in the input", oscem)
+}
@@ -0,0 +1,176 @@
+package conversion
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// ElasticsearchSink posts converted documents to an Elasticsearch or OpenSearch index
+// using the bulk API. Requests that fail with a network error, 429 or a 5xx status
+// are retried with exponential backoff. Every document is indexed under the SHA-256 of its
+// canonical JSON as _id, so a retry after a partial or timed-out bulk request replaces the
+// documents already indexed instead of duplicating them.
+type ElasticsearchSink struct {
+	URL        string        // base URL of the cluster, e.g. http://localhost:9200
+	Index      string        // target index name
+	Client     *http.Client  // optional, defaults to a client with a 30s timeout
+	MaxRetries int           // number of retries after the first attempt
+	Backoff    time.Duration // initial wait between retries, doubled after each attempt
+	Token      string        // optional bearer token, takes precedence over basic auth
+	Username   string        // optional basic auth user
+	Password   string        // optional basic auth password
+}
+
+// Builds a sink for the given cluster and index, taking credentials from the
+// OSCEM_ES_TOKEN, or OSCEM_ES_USER and OSCEM_ES_PASSWORD environment variables.
+func NewElasticsearchSinkFromEnv(url, index string) *ElasticsearchSink {
+	return &ElasticsearchSink{
+		URL:        url,
+		Index:      index,
+		MaxRetries: 3,
+		Token:      os.Getenv("OSCEM_ES_TOKEN"),
+		Username:   os.Getenv("OSCEM_ES_USER"),
+		Password:   os.Getenv("OSCEM_ES_PASSWORD"),
+	}
+}
+
+// Sends all documents to the configured index in a single bulk request.
+// Cancelling the context aborts the request in flight and any pending retries.
+//
+// Parameters:
+//   - ctx: Context bounding the whole push, including retries
+//   - docs: Converted OSCEM documents, as returned by Convert
+//
+// Returns:
+//   - error: Transport errors after all retries, or the first item-level error reported by the cluster
+func (s *ElasticsearchSink) Push(ctx context.Context, docs [][]byte) error {
+	if len(docs) == 0 {
+		return nil
+	}
+	if s.URL == "" || s.Index == "" {
+		return fmt.Errorf("elasticsearch sink requires both a URL and an index")
+	}
+	body, err := buildBulkBody(s.Index, docs)
+	if err != nil {
+		return err
+	}
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	backoff := s.Backoff
+	if backoff <= 0 {
+		backoff = 500 * time.Millisecond
+	}
+	endpoint := strings.TrimRight(s.URL, "/") + "/_bulk"
+
+	var lastErr error
+	for attempt := 0; attempt <= s.MaxRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+		if err != nil {
+			return fmt.Errorf("failed to build bulk request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/x-ndjson")
+		if s.Token != "" {
+			req.Header.Set("Authorization", "Bearer "+s.Token)
+		} else if s.Username != "" {
+			req.SetBasicAuth(s.Username, s.Password)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			lastErr = fmt.Errorf("bulk request to %s failed: %w", endpoint, err)
+			continue
+		}
+		respBody, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			lastErr = fmt.Errorf("failed to read bulk response: %w", err)
+			continue
+		}
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+			lastErr = fmt.Errorf("bulk request to %s returned %s", endpoint, resp.Status)
+			continue
+		}
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("bulk request to %s returned %s: %s", endpoint, resp.Status, strings.TrimSpace(string(respBody)))
+		}
+		return checkBulkResponse(respBody)
+	}
+	return lastErr
+}
+
+// Builds the newline-delimited bulk request body, one index action per document, with an
+// _id derived from the content of the document.
+func buildBulkBody(index string, docs [][]byte) ([]byte, error) {
+	var buf bytes.Buffer
+	for i, doc := range docs {
+		// the canonical form is on a single line, as the bulk API requires
+		canonical, err := CanonicalJSON(doc)
+		if err != nil {
+			return nil, fmt.Errorf("document %d: %w", i, err)
+		}
+		action, err := json.Marshal(map[string]interface{}{
+			"index": map[string]string{"_index": index, "_id": documentID(canonical)},
+		})
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(action)
+		buf.WriteByte('\n')
+		buf.Write(canonical)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
+
+// Returns the hex SHA-256 of a canonical document, its _id in the index.
+func documentID(canonical []byte) string {
+	sum := sha256.Sum256(canonical)
+	return hex.EncodeToString(sum[:])
+}
+
+// Inspects a bulk response and reports the first item that was rejected by the cluster.
+func checkBulkResponse(body []byte) error {
+	var parsed struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			Status int             `json:"status"`
+			Error  json.RawMessage `json:"error"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return fmt.Errorf("could not parse bulk response: %w", err)
+	}
+	if !parsed.Errors {
+		return nil
+	}
+	for i, item := range parsed.Items {
+		for _, result := range item {
+			if result.Status >= 300 {
+				return fmt.Errorf("document %d was rejected with status %d: %s", i, result.Status, string(result.Error))
+			}
+		}
+	}
+	return fmt.Errorf("bulk request reported errors")
+}
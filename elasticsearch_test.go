@@ -0,0 +1,61 @@
+package conversion
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Retried bulk requests index every document under the same _id, derived from its content.
+func TestElasticsearchSinkRetriesAreIdempotent(t *testing.T) {
+	var ids [][]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var attempt []string
+		scanner := bufio.NewScanner(bytes.NewReader(body))
+		for line := 0; scanner.Scan(); line++ {
+			if line%2 == 1 {
+				continue
+			}
+			var action struct {
+				Index struct {
+					ID string `json:"_id"`
+				} `json:"index"`
+			}
+			if err := json.Unmarshal(scanner.Bytes(), &action); err != nil {
+				t.Errorf("malformed action %s: %v", scanner.Bytes(), err)
+			}
+			attempt = append(attempt, action.Index.ID)
+		}
+		ids = append(ids, attempt)
+		if len(ids) == 1 {
+			// timed out after indexing some of the documents
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"errors": false, "items": []}`))
+	}))
+	defer server.Close()
+	sink := &ElasticsearchSink{URL: server.URL, Index: "oscem", MaxRetries: 1, Backoff: time.Millisecond}
+	docs := [][]byte{[]byte(`{"a": 1, "b": "x"}`), []byte("{\n  \"b\": \"x\",\n  \"a\": 1\n}"), []byte(`{"a": 2}`)}
+	if err := sink.Push(context.Background(), docs); err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 2 || len(ids[0]) != 3 {
+		t.Fatalf("got requests with ids %v, want 2 requests of 3 documents", ids)
+	}
+	for i, id := range ids[0] {
+		if id == "" || id != ids[1][i] {
+			t.Errorf("document %d: ids %q and %q, want the same non-empty id on retry", i, id, ids[1][i])
+		}
+	}
+	if ids[0][0] != ids[0][1] || ids[0][0] == ids[0][2] {
+		t.Errorf("got ids %v, want one id per distinct document", ids[0])
+	}
+}
@@ -0,0 +1,215 @@
+package conversion
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Returned, wrapped, by enrichers that find no record for the identifier of an input. The
+// conversion goes on without their fields and reports an enrichment warning instead.
+var ErrNoRecord = errors.New("no record found")
+
+// An Enricher adds metadata from external systems, such as a proposal database or an
+// electronic lab notebook, to a converted document.
+type Enricher interface {
+	// Returns OSCEM fields for the given flat input, keyed by "." separated OSCEM path.
+	// An enricher that finds nothing to add returns an empty map, one that finds no record
+	// for the identifier of the input an error wrapping ErrNoRecord.
+	Enrich(ctx context.Context, input map[string]string) (map[string]interface{}, error)
+}
+
+// A field of a REST response copied into the converted document.
+type EnrichmentField struct {
+	Response string // "." separated path into the response JSON
+	OSCEM    string // target OSCEM path
+	Type     string // mapping type the value is cast to, string when empty
+	Units    string
+}
+
+// RESTEnricher looks up the proposal or sample ID found in the input at a REST endpoint,
+// e.g. SciCat proposals, eLabFTW items or openBIS samples, and copies fields of the JSON response.
+type RESTEnricher struct {
+	URL    string            // endpoint, "{id}" is replaced by the escaped identifier
+	IDKey  string            // input key holding the identifier
+	Fields []EnrichmentField // response fields to copy
+	Token  string            // optional bearer token
+	Client *http.Client      // optional, defaults to a client with a 30s timeout
+}
+
+// Reads the response fields of a REST enricher from a CSV file with the columns
+// response, oscem and the optional type and units, e.g.
+//
+//	response,oscem,type,units
+//	title,organizational.grants.grant_name,String,
+//	pi.email,organizational.authors.email,String,
+func LoadEnrichmentFields(path string) ([]EnrichmentField, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open enrichment fields: %w", err)
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	reader.Comment = '#'
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+	colIdx := make(map[string]int)
+	for i, h := range header {
+		colIdx[strings.ToLower(strings.TrimSpace(strings.TrimLeft(h, "\ufeff")))] = i
+	}
+	for _, col := range []string{"response", "oscem"} {
+		if _, ok := colIdx[col]; !ok {
+			return nil, fmt.Errorf("missing required column: %s", col)
+		}
+	}
+	get := func(row []string, col string) string {
+		idx, ok := colIdx[col]
+		if !ok || idx >= len(row) {
+			return ""
+		}
+		return strings.TrimSpace(row[idx])
+	}
+
+	var fields []EnrichmentField
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read enrichment fields: %w", err)
+		}
+		field := EnrichmentField{
+			Response: get(row, "response"),
+			OSCEM:    get(row, "oscem"),
+			Type:     get(row, "type"),
+			Units:    get(row, "units"),
+		}
+		if field.Response == "" && field.OSCEM == "" {
+			continue
+		}
+		if field.Response == "" || field.OSCEM == "" {
+			return nil, fmt.Errorf("enrichment field %q needs both a response path and an OSCEM path", field.Response+field.OSCEM)
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// Fetches the record for the identifier found in the input and returns the configured fields.
+// Inputs without the identifier yield no fields, identifiers unknown to the endpoint ErrNoRecord.
+func (e *RESTEnricher) Enrich(ctx context.Context, input map[string]string) (map[string]interface{}, error) {
+	id := strings.TrimSpace(input[e.IDKey])
+	if id == "" {
+		return nil, nil
+	}
+	endpoint := strings.ReplaceAll(e.URL, "{id}", url.PathEscape(id))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build enrichment request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if e.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+e.Token)
+	}
+	client := e.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("enrichment request to %s failed: %w", endpoint, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w for %s at %s", ErrNoRecord, id, endpoint)
+	}
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("enrichment request to %s returned %s", endpoint, resp.Status)
+	}
+	var record interface{}
+	decoder := json.NewDecoder(resp.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(&record); err != nil {
+		return nil, fmt.Errorf("could not parse enrichment response from %s: %w", endpoint, err)
+	}
+
+	fields := make(map[string]interface{})
+	for _, field := range e.Fields {
+		value := lookupResponsePath(record, strings.Split(field.Response, "."))
+		var text string
+		switch v := value.(type) {
+		case nil, map[string]interface{}, []interface{}:
+			continue
+		case string:
+			text = v
+		default:
+			text = fmt.Sprint(v)
+		}
+		t := field.Type
+		if t == "" {
+			t = "string"
+		}
+		fields[field.OSCEM] = castToBaseType(text, t, field.Units)
+	}
+	return fields, nil
+}
+
+// Returns the value at a path in a decoded JSON response; numeric segments index into arrays.
+func lookupResponsePath(node interface{}, path []string) interface{} {
+	for _, key := range path {
+		switch v := node.(type) {
+		case map[string]interface{}:
+			node = v[key]
+		case []interface{}:
+			var i int
+			if _, err := fmt.Sscanf(key, "%d", &i); err != nil || i < 0 || i >= len(v) {
+				return nil
+			}
+			node = v[i]
+		default:
+			return nil
+		}
+	}
+	return node
+}
+
+// Runs the enrichers and adds their fields to the document, returning the paths added.
+// Fields already extracted from the input are kept, as the instrument is the primary source.
+// Enrichers finding no record are reported to warn and skipped.
+func applyEnrichers(ctx context.Context, out map[string]interface{}, input map[string]string, enrichers []Enricher, warn func(Warning)) ([]string, error) {
+	var added []string
+	for _, enricher := range enrichers {
+		fields, err := enricher.Enrich(ctx, input)
+		if errors.Is(err, ErrNoRecord) {
+			warn(Warning{Code: WarnEnrichment, Message: err.Error()})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for oscem, value := range fields {
+			path := strings.Split(oscem, ".")
+			if lookupPath(out, path) != nil {
+				continue
+			}
+			if err := insertNested(out, path, value); err != nil {
+				return nil, fmt.Errorf("cannot add enriched field %s: %w", oscem, err)
+			}
+			added = append(added, oscem)
+		}
+	}
+	return added, nil
+}
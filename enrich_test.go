@@ -0,0 +1,48 @@
+package conversion
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// An identifier unknown to the endpoint is reported as a warning of the conversion.
+func TestRESTEnricherNoRecord(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/proposals/p1" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"title": "Apoferritin"}`))
+	}))
+	defer server.Close()
+	mapping, err := ParseMapping(strings.NewReader("oscem,fromformat,optionals,units,crunch,type\nsample.name,Name,,,,String\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := Options{Mapping: mapping, Enrichers: []Enricher{&RESTEnricher{
+		URL:    server.URL + "/proposals/{id}",
+		IDKey:  "Proposal",
+		Fields: []EnrichmentField{{Response: "title", OSCEM: "organizational.grants.grant_name"}},
+	}}}
+	for _, tt := range []struct {
+		proposal string
+		warnings int
+	}{
+		{"p1", 0},
+		{"p2", 1},
+	} {
+		res, err := convertValues(context.Background(), map[string]string{"Name": "apo", "Proposal": tt.proposal}, opts)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.proposal, err)
+		}
+		if len(res.Warnings) != tt.warnings || tt.warnings > 0 && res.Warnings[0].Code != WarnEnrichment {
+			t.Errorf("%s: got warnings %v, want %d enrichment warnings", tt.proposal, res.Warnings, tt.warnings)
+		}
+		if found := strings.Contains(string(res.Document), "Apoferritin"); found != (tt.warnings == 0) {
+			t.Errorf("%s: unexpected document:\n%s", tt.proposal, res.Document)
+		}
+	}
+}
@@ -0,0 +1,449 @@
+package conversion
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Explains how a conversion arrives at the value of a single output path, for debugging
+// why a value is what it is.
+type FieldExplanation struct {
+	Path     string      `json:"path"`               // the explained OSCEM path
+	Rows     []RowTrace  `json:"rows"`               // the mapping rows targeting the path, in mapping order
+	Notes    []string    `json:"notes,omitempty"`    // what happens to the path after the mapping rows ran
+	Value    interface{} `json:"value"`              // the value in the converted document, nil if missing
+	Warnings []Warning   `json:"warnings,omitempty"` // warnings of the conversion concerning the path
+}
+
+// Traces a single mapping row targeting the explained path.
+type RowTrace struct {
+	Line   int           `json:"line,omitempty"` // line of the row in its mapping file, 0 if unknown
+	Target string        `json:"target"`         // OSCEM path of the row
+	Type   string        `json:"type"`
+	Units  string        `json:"units,omitempty"`
+	Active bool          `json:"active"`           // false when the row's profile excludes the modality
+	Checks []SourceCheck `json:"checks,omitempty"` // the source keys looked up, in priority order
+	Source string        `json:"source,omitempty"` // the source key the values were taken from, "" if none matched
+	Crunch string        `json:"crunch,omitempty"` // the unit conversion factor of the source
+	Values []ValueTrace  `json:"values,omitempty"` // the values processed, one per array element for lists and [N] patterns
+	// Warnings raised while processing the row's values.
+	Warnings []Warning `json:"warnings,omitempty"`
+}
+
+// A source key looked up for a mapping row.
+type SourceCheck struct {
+	Column string `json:"column"` // the mapping column naming the key, e.g. frommdoc or fallbacks
+	Key    string `json:"key"`    // the key, ";"-separated for lists or an [N] pattern
+	Found  bool   `json:"found"`
+	Value  string `json:"value,omitempty"` // the value found, ";"-separated for lists
+}
+
+// Traces the processing of a single input value.
+type ValueTrace struct {
+	Input string      `json:"input"`           // the input key holding the value
+	Raw   string      `json:"raw"`             // the value as read from the input
+	Steps []string    `json:"steps,omitempty"` // the transformations applied, in order
+	Value interface{} `json:"value"`           // the typed value written by the row
+}
+
+// Matches the index of an array segment such as detectors[0], detectors[EF-CCD] or detectors[N].
+var arrayIndexPattern = regexp.MustCompile(`\[[^\]]*\]`)
+
+// Explains how a conversion with the given options arrives at the value of an OSCEM path:
+// every mapping row targeting the path, including rows another modality's profile activates,
+// the source keys each row checks in priority order, which of them matched and the
+// transformations applied to the value. Notes report options replacing, deriving, dropping
+// or rewriting the value after the mapping, and the value and warnings of the actual
+// conversion complete the picture.
+//
+// Array elements may be addressed by position, captured identifier or [N], e.g.
+// acquisition.detectors[0].name; all of them explain the rows of acquisition.detectors[N].name.
+//
+// Parameters:
+//   - ctx: Context of the conversion
+//   - jsonin: The input, read with opts.Extractor
+//   - path: The OSCEM path to explain
+//   - opts: The options of the conversion
+//
+// Returns:
+//   - *FieldExplanation: How the value came about
+//   - error: If the input cannot be read or the mapping cannot be loaded
+func Explain(ctx context.Context, jsonin []byte, path string, opts Options) (*FieldExplanation, error) {
+	path = strings.Trim(strings.TrimSpace(path), ".")
+	if path == "" {
+		return nil, fmt.Errorf("no path to explain")
+	}
+	gen, err := resolveSchemaGeneration(opts.SchemaVersion)
+	if err != nil {
+		return nil, err
+	}
+	input, err := extractInput(ctx, opts.Extractor, jsonin)
+	if err != nil {
+		return nil, err
+	}
+	values := applyOverlay(input, opts.Overlay)
+	resolved := opts.withRegistry(values)
+	m, err := selectMapping(resolved, gen)
+	if err != nil {
+		return nil, err
+	}
+	all := m.rows
+	plan, err := m.forModality(resolved.Modality)
+	if err != nil {
+		return nil, err
+	}
+	active := plan.rows
+	for _, hook := range resolved.Hooks.Input {
+		values = hook(values)
+	}
+
+	explanation := &FieldExplanation{Path: path}
+	template := arrayIndexPattern.ReplaceAllString(path, "[N]")
+	c := &converter{hooks: resolved.Hooks, keepEmptySlots: resolved.KeepEmptySlots, listPolicy: resolved.ListPolicy, regexps: plan.regexps, tracing: true}
+	c.rows, c.input = active, values
+	modality := strings.ToLower(strings.TrimSpace(resolved.Modality))
+	for _, row := range all {
+		if row.OSCEM != template {
+			continue
+		}
+		trace := c.traceRow(row, values)
+		trace.Active = len(row.Profiles) == 0 || hasProfile(row, modality)
+		explanation.Rows = append(explanation.Rows, trace)
+	}
+	if len(explanation.Rows) == 0 {
+		explanation.Notes = append(explanation.Notes, "no mapping row targets "+template)
+	}
+	for key := range opts.Overlay {
+		for _, row := range explanation.Rows {
+			if row.Source == key {
+				explanation.Notes = append(explanation.Notes, fmt.Sprintf("input key %s is set by the overlay", key))
+				break
+			}
+		}
+	}
+	explanation.Notes = append(explanation.Notes, afterMappingNotes(template, resolved)...)
+
+	// the actual conversion, with provenance naming what wrote the value last
+	convertOpts := opts
+	convertOpts.Provenance = true
+	res, err := convertValues(ctx, input, convertOpts)
+	if err != nil {
+		explanation.Notes = append(explanation.Notes, fmt.Sprintf("the conversion fails: %v", err))
+		return explanation, nil
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(res.Document, &decoded); err != nil {
+		return nil, fmt.Errorf("failed to read the converted document: %w", err)
+	}
+	explanation.Value = outputValue(decoded, strings.Split(path, "."))
+	if records, ok := decoded["_provenance"].(map[string]interface{}); ok {
+		if record, ok := records[path].(map[string]interface{}); ok {
+			explanation.Notes = append(explanation.Notes, describeProvenance(record))
+		}
+	}
+	for _, w := range res.Warnings {
+		if arrayIndexPattern.ReplaceAllString(w.Path, "[N]") == template {
+			explanation.Warnings = append(explanation.Warnings, w)
+		}
+	}
+	return explanation, nil
+}
+
+// Traces how the converter reads a row: the source keys it checks, the one it takes and the
+// processing of the values found, like processRegularMappings and the dynamic array step do.
+func (c *converter) traceRow(row csvextract, input map[string]string) RowTrace {
+	trace := RowTrace{Line: row.Line, Target: row.OSCEM, Type: row.Type, Units: row.Units}
+	c.dynamicFieldPatterns = nil
+	c.warnings = nil
+	record := func(row csvextract, input map[string]string, key string) ([]string, bool) {
+		values, found := c.extractValuesFromInput(row, input, key)
+		trace.Checks = append(trace.Checks, SourceCheck{
+			Column: sourceColumn(row, key),
+			Key:    key,
+			Found:  found,
+			Value:  strings.Join(values, ";"),
+		})
+		return values, found
+	}
+	if row.Reference {
+		for _, candidate := range rowSourceKeys(row) {
+			_, ok := input[candidate]
+			trace.Checks = append(trace.Checks, SourceCheck{Column: sourceColumn(row, candidate), Key: candidate, Found: ok, Value: input[candidate]})
+			if ok && trace.Source == "" {
+				trace.Source = candidate
+			}
+		}
+		parentPath, arrayName, property := parseArrayPath(row.OSCEM)
+		arrayPath := strings.Join(append(append([]string{}, parentPath...), arrayName), ".")
+		for _, value := range referencedValues(row, input) {
+			step := fmt.Sprintf("compared with the %s and identifier of each element of %s, writing nothing", property, arrayPath)
+			trace.Values = append(trace.Values, ValueTrace{Raw: value, Steps: []string{step}})
+		}
+		return trace
+	}
+	if row.Presence {
+		key, present := c.presentKey(row, input)
+		for _, candidate := range rowSourceKeys(row) {
+			found, ok := c.keyPresent(candidate, input)
+			check := SourceCheck{Column: sourceColumn(row, candidate), Key: candidate, Found: ok}
+			if found != candidate {
+				check.Value = found // the key matching an [N] pattern
+			}
+			trace.Checks = append(trace.Checks, check)
+		}
+		step := "no source key exists, writing false"
+		if present {
+			step = fmt.Sprintf("%s exists, writing true", key)
+		}
+		trace.Source = key
+		trace.Values = append(trace.Values, ValueTrace{Input: key, Raw: input[key], Steps: []string{step}, Value: present})
+		return trace
+	}
+	rawValues, crunch, source, found := findMatchingValues(row, input, record)
+	switch {
+	case found && strings.Contains(row.OSCEM, "[N]"):
+		trace.Source, trace.Crunch = source, crunch
+		sources := strings.Split(source, ";")
+		for i, raw := range rawValues {
+			if raw == "" {
+				continue
+			}
+			key := source
+			if i < len(sources) {
+				key = strings.TrimSpace(sources[i])
+			}
+			trace.Values = append(trace.Values, c.traceValue(key, raw, elementCrunch(crunch, i), row))
+		}
+	case found && len(rawValues) > 1:
+		trace.Source, trace.Crunch = source, crunch
+		entries, err := c.resolveList(row, rawValues, crunch, source)
+		if err != nil {
+			c.warn(Warning{Code: WarnListConflict, Path: row.OSCEM, Row: row.Line, Message: err.Error()})
+		}
+		for _, entry := range entries {
+			trace.Values = append(trace.Values, c.traceValue(entry.key, entry.value, elementCrunch(crunch, entry.index), row))
+		}
+	case found:
+		trace.Source, trace.Crunch = source, crunch
+		if len(rawValues) > 0 {
+			trace.Values = append(trace.Values, c.traceValue(source, rawValues[0], elementCrunch(crunch, 0), row))
+		}
+	case len(c.dynamicFieldPatterns) > 0:
+		// the dynamic array step matches the pattern against every input key
+		pattern := c.dynamicFieldPatterns[0]
+		fieldPattern := getFieldPattern(pattern)
+		regex := c.patternRegexp(fieldPattern)
+		var keys []string
+		for key := range input {
+			if regex.MatchString(key) {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		trace.Checks = append(trace.Checks, SourceCheck{
+			Column: "[N] pattern",
+			Key:    fieldPattern,
+			Found:  len(keys) > 0,
+			Value:  fmt.Sprintf("%d matching keys", len(keys)),
+		})
+		if len(keys) > 0 {
+			trace.Source, trace.Crunch = fieldPattern, getCrunchFactor(pattern)
+		}
+		for _, key := range keys {
+			crunch := c.elementCrunchFactor(trace.Crunch, regex.FindStringSubmatch(key)[1], pattern)
+			trace.Values = append(trace.Values, c.traceValue(key, input[key], crunch, pattern))
+		}
+	}
+	trace.Warnings = c.warnings
+	return trace
+}
+
+// Processes a single value of a row and returns the transformations applied.
+func (c *converter) traceValue(key string, raw string, crunch string, row csvextract) ValueTrace {
+	value := c.processValue(raw, crunch, row)
+	typed, _ := json.Marshal(value)
+	var decoded interface{}
+	json.Unmarshal(typed, &decoded)
+	return ValueTrace{Input: key, Raw: raw, Steps: c.steps, Value: decoded}
+}
+
+// Names the mapping column a source key of a row comes from, in the terms of the row's layout.
+func sourceColumn(row csvextract, key string) string {
+	columns := []struct {
+		cell, full, reduced string
+	}{
+		{row.OptionalsMDOC, "optionals_mdoc", "optionals"},
+		{row.FromMDOC, "frommdoc", "fromformat"},
+		{row.OptionalsXML, "optionals_xml", "optionals_xml"},
+		{row.FromXML, "fromxml", "fromxml"},
+	}
+	for _, column := range columns {
+		if column.cell == key {
+			if row.Layout == layoutReduced {
+				return column.reduced
+			}
+			return column.full
+		}
+	}
+	return "fallbacks"
+}
+
+// Describes what the options do to a path once the mapping rows have run.
+func afterMappingNotes(template string, opts Options) []string {
+	var notes []string
+	rights := map[string]struct{ option, value string }{
+		"organizational.license":            {"license", opts.Rights.License},
+		"organizational.doi":                {"doi", opts.Rights.DOI},
+		"organizational.authors.orcid":      {"orcid", opts.Rights.ORCID},
+		"organizational.funder.funder_name": {"funder", opts.Rights.Funder},
+	}
+	if field, ok := rights[template]; ok && field.value != "" {
+		notes = append(notes, fmt.Sprintf("the %s option sets the value to %q after the mapping, replacing any mapped value", field.option, field.value))
+	}
+	// the last injection of a path wins
+	var injected string
+	option := [...]string{"the cs option", "the gain_flip_rotate option"}
+	for i, inj := range opts.injections() {
+		if strings.TrimSpace(inj.Path) != template {
+			continue
+		}
+		by := "an injection"
+		if i < len(option) {
+			by = option[i]
+		}
+		if inj.Value != "" {
+			injected = fmt.Sprintf("%s sets the value to %q after the mapping, replacing any mapped value", by, inj.Value)
+		} else if i < len(option) {
+			injected = fmt.Sprintf("%s is unset, which drops any mapped value", by)
+		} else {
+			injected = fmt.Sprintf("%s with an empty value drops any mapped value", by)
+		}
+	}
+	if injected != "" {
+		notes = append(notes, injected)
+	}
+	if opts.DerivePixelSize && template == "acquisition.pixel_size" {
+		notes = append(notes, "derived from the detector pixel size, binning and magnification when no row yields a value")
+	}
+	if len(opts.Enrichers) > 0 {
+		notes = append(notes, "enrichers may add or replace the value")
+	}
+	segments := strings.Split(arrayIndexPattern.ReplaceAllString(template, ""), ".")
+	if only := splitPaths(opts.Only); len(only) > 0 && !hasPathPrefix(segments, only) {
+		notes = append(notes, "not among the selected paths, so its rows are not converted")
+	}
+	if includes := splitPaths(opts.Include); len(includes) > 0 && !hasPathPrefix(segments, includes) {
+		notes = append(notes, "not among the included paths, so it is dropped from the output")
+	}
+	if hasPathPrefix(segments, splitPaths(opts.Exclude)) {
+		notes = append(notes, "excluded, so it is dropped from the output")
+	}
+	var redacted []string
+	for _, p := range opts.Redact {
+		if strings.TrimSpace(p) == "personal" {
+			redacted = append(redacted, PersonalDataPaths...)
+		} else {
+			redacted = append(redacted, p)
+		}
+	}
+	if hasPathPrefix(segments, splitPaths(redacted)) {
+		if len(opts.PseudonymKey) > 0 {
+			notes = append(notes, "redacted: string values are replaced by a pseudonym")
+		} else {
+			notes = append(notes, "redacted, so it is dropped from the output")
+		}
+	}
+	if hasPathPrefix(segments, splitPaths(opts.Hash)) {
+		notes = append(notes, "hashed with the site salt")
+	}
+	return notes
+}
+
+// Describes a _provenance record of the converted document.
+func describeProvenance(record map[string]interface{}) string {
+	text := func(key string) string {
+		s, _ := record[key].(string)
+		return s
+	}
+	switch text("source") {
+	case "mapping":
+		desc := fmt.Sprintf("written by the mapping row %s", text("target"))
+		if line, ok := record["row"].(float64); ok {
+			desc += fmt.Sprintf(" (line %d)", int(line))
+		}
+		if input := text("input"); input != "" {
+			desc += " from input key " + input
+		}
+		if crunch := text("crunch"); crunch != "" {
+			desc += ", converted by " + crunch
+		}
+		return desc
+	case "option":
+		return "set from an option"
+	case "enrichment":
+		return "added by an enricher"
+	case "derived":
+		return "derived from other fields"
+	default:
+		return "written by an unknown source"
+	}
+}
+
+// Returns the value at a path of a decoded document. Array segments are addressed by
+// position or, for keyed arrays, by identifier; [N] collects the value of every element.
+func outputValue(node interface{}, segments []string) interface{} {
+	if len(segments) == 0 {
+		return node
+	}
+	name, index, indexed := strings.Cut(segments[0], "[")
+	obj, ok := node.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	child, ok := obj[name]
+	if !ok {
+		return nil
+	}
+	if !indexed {
+		return outputValue(child, segments[1:])
+	}
+	index = strings.TrimSuffix(index, "]")
+	var elements []interface{}
+	switch v := child.(type) {
+	case []interface{}:
+		if index != "N" {
+			i, err := strconv.Atoi(index)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil
+			}
+			return outputValue(v[i], segments[1:])
+		}
+		elements = v
+	case map[string]interface{}:
+		if index != "N" {
+			return outputValue(v[index], segments[1:])
+		}
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			elements = append(elements, v[key])
+		}
+	default:
+		return nil
+	}
+	var collected []interface{}
+	for _, element := range elements {
+		if value := outputValue(element, segments[1:]); value != nil {
+			collected = append(collected, value)
+		}
+	}
+	return collected
+}
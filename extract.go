@@ -0,0 +1,48 @@
+package conversion
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Compiles the optional extract column, a regular expression applied to the source value
+// of a row before its unit conversion, e.g. `(\d+)x\d+` for the width of "Falcon 4i, 4096x4096".
+func parseExtract(cell string) (*regexp.Regexp, error) {
+	cell = strings.TrimSpace(cell)
+	if cell == "" {
+		return nil, nil
+	}
+	re, err := regexp.Compile(cell)
+	if err != nil {
+		return nil, fmt.Errorf("invalid extract pattern %q: %w", cell, err)
+	}
+	return re, nil
+}
+
+// Applies the extract pattern of a row to a source value. The value becomes the first
+// capture group that took part in the match, or the whole match of a pattern without
+// groups, so rows sharing a source can each pick their part of it.
+//
+// Parameters:
+//   - row: CSV mapping rule, possibly with an extract pattern
+//   - value: The source value
+//
+// Returns:
+//   - string: The extracted part, the value itself for rows without a pattern
+//   - bool: Whether the pattern matched
+func extractValue(row csvextract, value string) (string, bool) {
+	if row.Extract == nil {
+		return value, true
+	}
+	match := row.Extract.FindStringSubmatchIndex(value)
+	if match == nil {
+		return "", false
+	}
+	for group := 1; group < len(match)/2; group++ {
+		if match[2*group] >= 0 {
+			return value[match[2*group]:match[2*group+1]], true
+		}
+	}
+	return value[match[0]:match[1]], true
+}
@@ -0,0 +1,103 @@
+package conversion
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Produces flat key-value metadata from a raw source, which is then fed through the
+// mapping pipeline like any other input. Facilities implement it for sources the
+// converter does not read natively (proprietary databases, binary headers, ...) and
+// register it from an init function, the same way database/sql drivers register.
+type Extractor interface {
+	// Name under which the extractor is registered and selected via Options.Extractor.
+	Name() string
+	// Extract turns the raw source into flat key-value pairs.
+	Extract(ctx context.Context, src []byte) (map[string]string, error)
+}
+
+var (
+	extractorsMu sync.RWMutex
+	extractors   = make(map[string]Extractor)
+)
+
+// The name of the built-in extractor decoding a flat JSON object, used when Options.Extractor is empty.
+const DefaultExtractor = "json"
+
+func init() {
+	RegisterExtractor(jsonExtractor{})
+}
+
+// Makes an extractor available under its name. Registering two extractors with the
+// same name is an error.
+func RegisterExtractor(e Extractor) error {
+	extractorsMu.Lock()
+	defer extractorsMu.Unlock()
+	name := e.Name()
+	if name == "" {
+		return fmt.Errorf("extractor name must not be empty")
+	}
+	if _, exists := extractors[name]; exists {
+		return fmt.Errorf("extractor %q is already registered", name)
+	}
+	extractors[name] = e
+	return nil
+}
+
+// Returns the extractor registered under the given name.
+func LookupExtractor(name string) (Extractor, bool) {
+	extractorsMu.RLock()
+	defer extractorsMu.RUnlock()
+	e, ok := extractors[name]
+	return e, ok
+}
+
+// Lists the names of all registered extractors, sorted.
+func Extractors() []string {
+	extractorsMu.RLock()
+	defer extractorsMu.RUnlock()
+	names := make([]string, 0, len(extractors))
+	for name := range extractors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Runs the named extractor, or the default JSON one when the name is empty.
+func extractInput(ctx context.Context, name string, src []byte) (map[string]string, error) {
+	if name == "" {
+		name = DefaultExtractor
+	}
+	e, ok := LookupExtractor(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown extractor %q, registered: %v", name, Extractors())
+	}
+	return e.Extract(ctx, src)
+}
+
+// Decodes the flat JSON object most extraction tools produce. The input may be UTF-16 or
+// carry a byte order mark, as written by some Windows tools, see decodeText. Non-string
+// values are skipped, as they always have been, but malformed JSON is an error.
+type jsonExtractor struct{}
+
+func (jsonExtractor) Name() string { return DefaultExtractor }
+
+func (jsonExtractor) Extract(_ context.Context, src []byte) (map[string]string, error) {
+	text, err := decodeText(src)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode input: %w", err)
+	}
+	var values map[string]string
+	if err := json.Unmarshal([]byte(text), &values); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if !errors.As(err, &typeErr) {
+			return nil, fmt.Errorf("input is not a JSON object: %w", err)
+		}
+	}
+	return values, nil
+}
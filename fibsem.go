@@ -0,0 +1,146 @@
+package conversion
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The name of the built-in extractor reading cryo-FIB/SEM milling session logs.
+const FIBLogExtractor = "fib-log"
+
+func init() {
+	RegisterExtractor(fibLogExtractor{})
+}
+
+// Reads the milling log of a cryo-FIB/SEM session, so the preparation of the lamellae can be
+// attached to the OSCEM record of their later acquisition, e.g. as an Options.Overlay.
+//
+// The log is a CSV file with one row per milling step and the columns lamella, current and
+// angle, plus any others, which are ignored. Currents carry their unit ("30 pA", "1.0nA"),
+// bare numbers are taken as nA; angles are in degrees unless a unit is given.
+// For every lamella it yields the keys
+//
+//	FIB.Lamella-<id>.Name, .MillingAngle, .MillingCurrent_max, .MillingCurrent_final, .MillingSteps
+//
+// where the final current is the one of its last step, usually the polishing. Dots in lamella
+// IDs are replaced by underscores, as the mapping's [N] patterns cannot capture them.
+type fibLogExtractor struct{}
+
+func (fibLogExtractor) Name() string { return FIBLogExtractor }
+
+func (fibLogExtractor) Extract(_ context.Context, src []byte) (map[string]string, error) {
+	reader := csv.NewReader(bytes.NewReader(src))
+	reader.FieldsPerRecord = -1
+	reader.Comment = '#'
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the milling log header: %w", err)
+	}
+	colIdx := columnIndex(header)
+	for _, col := range []string{"lamella", "current", "angle"} {
+		if _, ok := colIdx[col]; !ok {
+			return nil, fmt.Errorf("milling log is missing the column %s", col)
+		}
+	}
+	get := func(row []string, col string) string {
+		idx := colIdx[col]
+		if idx >= len(row) {
+			return ""
+		}
+		return strings.TrimSpace(row[idx])
+	}
+
+	type lamella struct {
+		angle      string
+		maxCurrent float64
+		current    float64
+		steps      int
+	}
+	lamellae := make(map[string]*lamella)
+	var order []string
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the milling log: %w", err)
+		}
+		line, _ := reader.FieldPos(0)
+		id := strings.ReplaceAll(get(row, "lamella"), ".", "_")
+		if id == "" {
+			continue
+		}
+		l, ok := lamellae[id]
+		if !ok {
+			l = &lamella{}
+			lamellae[id] = l
+			order = append(order, id)
+		}
+		l.steps++
+		if text := get(row, "current"); text != "" {
+			current, err := parseQuantity(text, "nA", "nA")
+			if err != nil {
+				return nil, fmt.Errorf("milling log line %d: %w", line, err)
+			}
+			l.current = current
+			if current > l.maxCurrent {
+				l.maxCurrent = current
+			}
+		}
+		if text := get(row, "angle"); text != "" {
+			angle, err := parseQuantity(text, "deg", "deg")
+			if err != nil {
+				return nil, fmt.Errorf("milling log line %d: %w", line, err)
+			}
+			l.angle = strconv.FormatFloat(angle, 'g', 15, 64)
+		}
+	}
+
+	values := make(map[string]string)
+	for _, id := range order {
+		l := lamellae[id]
+		prefix := "FIB.Lamella-" + id + "."
+		values[prefix+"Name"] = id
+		values[prefix+"MillingSteps"] = strconv.Itoa(l.steps)
+		if l.angle != "" {
+			values[prefix+"MillingAngle"] = l.angle
+		}
+		if l.maxCurrent > 0 {
+			values[prefix+"MillingCurrent_max"] = strconv.FormatFloat(l.maxCurrent, 'g', 15, 64)
+			values[prefix+"MillingCurrent_final"] = strconv.FormatFloat(l.current, 'g', 15, 64)
+		}
+	}
+	return values, nil
+}
+
+// Splits a number from its optional unit, e.g. "30 pA" or "1.0nA".
+var quantityPattern = regexp.MustCompile(`^([-+]?[0-9]*\.?[0-9]+(?:[eE][-+]?[0-9]+)?)\s*(\S*)$`)
+
+// Parses a quantity written with an optional unit and converts it to the target unit.
+// Values without a unit are taken to be in defaultUnit.
+func parseQuantity(text string, defaultUnit string, target string) (float64, error) {
+	match := quantityPattern.FindStringSubmatch(strings.TrimSpace(text))
+	if match == nil {
+		return 0, fmt.Errorf("%q is not a number with an optional unit", text)
+	}
+	value, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a number: %w", text, err)
+	}
+	unit := match[2]
+	if unit == "" {
+		unit = defaultUnit
+	}
+	factor, err := crunchMultiplier(unit, target)
+	if err != nil {
+		return 0, err
+	}
+	return value * factor, nil
+}
@@ -0,0 +1,101 @@
+package conversion
+
+import (
+	"bytes"
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// Fuzzes the mapping loader: parsing must not panic for any file, and a mapping that
+// parses and validates must convert an input holding all of its source keys.
+func FuzzParseMapping(f *testing.F) {
+	f.Add([]byte("oscem,fromformat,optionals,units,crunch,type,mode\ninstrument.microscope.model,Instrument.InstrumentModel,,,,String,\n"))
+	f.Add([]byte("oscem;fromformat;units;crunch;type\nacquisition.voltage;Optics.Voltage;kV;1e-3;Float64\n"))
+	f.Add([]byte("oscem\tfromformat\ttype\nacquisition.detectors[N].name\tDetectors.Detector-[N].DetectorName\tString\n"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, strict := range []bool{false, true} {
+			rows, _, err := parseMappingCSV(bytes.NewReader(data), strict)
+			if err != nil || validateMapping(rows, strict) != nil {
+				continue
+			}
+			input := make(map[string]string)
+			for _, row := range rows {
+				for _, key := range rowSourceKeys(row) {
+					input[strings.ReplaceAll(key, "[N]", "1")] = "1"
+				}
+			}
+			// conversions may fail, e.g. on colliding targets, but must not panic
+			convertValues(context.Background(), input, Options{Mapping: &Mapping{rows: rows}})
+		}
+	})
+}
+
+// Fuzzes the translation of [N] source patterns: the expression must compile and match the
+// pattern with [N] replaced by an index, capturing the index.
+func FuzzConvertPatternToRegex(f *testing.F) {
+	f.Add("Detectors.Detector-[N].DetectorName")
+	f.Add("Frames[N]")
+	f.Add("a(b)+c.[N].*")
+	f.Fuzz(func(t *testing.T, pattern string) {
+		expr := convertPatternToRegex(pattern)
+		if !strings.Contains(pattern, "[N]") {
+			if expr != "" {
+				t.Fatalf("pattern %q without [N] translated to %q", pattern, expr)
+			}
+			return
+		}
+		if !utf8.ValidString(pattern) {
+			return
+		}
+		regex := regexp.MustCompile(expr)
+		key := strings.ReplaceAll(pattern, "[N]", "7")
+		matches := regex.FindStringSubmatch(key)
+		if matches == nil {
+			t.Fatalf("%q does not match %q", expr, key)
+		}
+		if strings.Count(pattern, "[N]") == 1 && matches[1] != "7" {
+			t.Fatalf("%q captured %q of %q, want 7", expr, matches[1], key)
+		}
+	})
+}
+
+// Fuzzes the array path parsers: they must agree and split a path into its parts, and only
+// paths naming the array and a property of its elements may pass validation.
+func FuzzParseArrayPath(f *testing.F) {
+	f.Add("acquisition.detectors[N].name")
+	f.Add("acquisition.detectors[N]")
+	f.Add("[N].name")
+	f.Add("a[N].b[N].c")
+	f.Fuzz(func(t *testing.T, oscem string) {
+		parent, arrayName, property := parseArrayPath(oscem)
+		before, after, found := strings.Cut(oscem, "[N]")
+		if joined := strings.Join(append(append([]string{}, parent...), arrayName), "."); joined != before {
+			t.Fatalf("%q: parent %q and array %q do not rebuild %q", oscem, parent, arrayName, before)
+		}
+		if property != strings.TrimPrefix(after, ".") {
+			t.Fatalf("%q: property %q, want %q", oscem, property, strings.TrimPrefix(after, "."))
+		}
+		if !found {
+			return
+		}
+		otherParent, otherName := parseArrayPathFromOSCEM(oscem)
+		if strings.Join(otherParent, ".") != strings.Join(parent, ".") || otherName != arrayName {
+			t.Fatalf("%q: parseArrayPathFromOSCEM gives %q %q, parseArrayPath %q %q", oscem, otherParent, otherName, parent, arrayName)
+		}
+		row := csvextract{OSCEM: oscem, FromMDOC: "Key", Type: "String"}
+		if validateMapping([]csvextract{row}, true) != nil {
+			return
+		}
+		if arrayName == "" {
+			t.Fatalf("%q: validated without an array name", oscem)
+		}
+		for _, segment := range strings.Split(property, ".") {
+			if segment == "" {
+				t.Fatalf("%q: validated with an empty property segment", oscem)
+			}
+		}
+	})
+}
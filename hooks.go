@@ -0,0 +1,63 @@
+package conversion
+
+import "strings"
+
+// Rewrites the flat input before the mapping is applied, e.g. to rename or drop keys.
+type InputHook func(input map[string]string) map[string]string
+
+// Transforms a single value after unit conversion and before type casting.
+// It receives the OSCEM path the value is mapped to.
+type ValueHook func(oscem string, value string) string
+
+// Modifies the converted document before it is cleaned and serialized.
+type OutputHook func(doc map[string]interface{}) error
+
+// Site-specific processing stages run around the core mapping. Hooks of each
+// stage are executed in the order they were added.
+type Hooks struct {
+	Input  []InputHook
+	Value  []ValueHook
+	Output []OutputHook
+}
+
+// Appends an input preprocessor.
+func (h *Hooks) AddInput(hook InputHook) { h.Input = append(h.Input, hook) }
+
+// Appends a value transformer.
+func (h *Hooks) AddValue(hook ValueHook) { h.Value = append(h.Value, hook) }
+
+// Appends an output postprocessor.
+func (h *Hooks) AddOutput(hook OutputHook) { h.Output = append(h.Output, hook) }
+
+// Returns an input hook renaming keys according to the given old -> new table.
+// Keys already present under their new name are not overwritten.
+func RenameKeys(renames map[string]string) InputHook {
+	return func(input map[string]string) map[string]string {
+		for oldKey, newKey := range renames {
+			val, exists := input[oldKey]
+			if !exists {
+				continue
+			}
+			if _, taken := input[newKey]; !taken {
+				input[newKey] = val
+			}
+			delete(input, oldKey)
+		}
+		return input
+	}
+}
+
+// Returns an input hook dropping every key that starts with one of the given prefixes.
+func DropKeys(prefixes ...string) InputHook {
+	return func(input map[string]string) map[string]string {
+		for key := range input {
+			for _, prefix := range prefixes {
+				if strings.HasPrefix(key, prefix) {
+					delete(input, key)
+					break
+				}
+			}
+		}
+		return input
+	}
+}
@@ -0,0 +1,94 @@
+package conversion
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A value set at an output path after the mapping, replacing whatever the mapping rows
+// wrote there, e.g. an instrument setting the input does not record. Options.CS and
+// Options.GainFlipRotate are injections of instrument.cs and acquisition.gainref_flip_rotate.
+type Injection struct {
+	Path  string // "." separated path of the field, without array elements
+	Value string // the value, cast like a mapped one; empty drops any mapped value
+	Type  string // Int, Float, Float64, Bool or String; that of the mapping row targeting Path when empty, else String
+	Unit  string // unit of the value; that of the mapping row targeting Path when both Type and Unit are empty
+}
+
+// Returns the injections of a conversion: cs and gain reference flip/rotate first, then
+// Options.Inject in order, so a later injection of the same path wins.
+func (opts Options) injections() []Injection {
+	injections := []Injection{
+		{Path: "instrument.cs", Value: opts.CS, Type: "float64", Unit: "mm"},
+		{Path: "acquisition.gainref_flip_rotate", Value: opts.GainFlipRotate, Type: "string"},
+	}
+	return append(injections, opts.Inject...)
+}
+
+// Fills in the type and unit of an injection from the mapping row targeting its path and
+// checks that both the path and the type can be written.
+//
+// Parameters:
+//   - inj: The injection as given in the options
+//   - rows: All rows of the mapping, consulted for the defaults
+//
+// Returns:
+//   - Injection: The injection with its type and unit resolved
+//   - error: If the path is empty or addresses array elements, or the type is unknown
+func resolveInjection(inj Injection, rows []csvextract) (Injection, error) {
+	inj.Path = strings.TrimSpace(inj.Path)
+	if inj.Path == "" || strings.Contains(inj.Path, "[") {
+		return inj, fmt.Errorf("cannot inject %q: the path must name a field outside of arrays", inj.Path)
+	}
+	if inj.Type == "" {
+		inj.Type = "string"
+		for _, row := range rows {
+			if row.OSCEM == inj.Path && row.Type != "" {
+				inj.Type = row.Type
+				if inj.Unit == "" {
+					inj.Unit = row.Units
+				}
+				break
+			}
+		}
+	}
+	switch strings.ToLower(inj.Type) {
+	case "int", "float", "float64", "bool", "string":
+	default:
+		return inj, fmt.Errorf("cannot inject %s: unknown type %q", inj.Path, inj.Type)
+	}
+	return inj, nil
+}
+
+// Writes the injections into the document. Values that do not fit their type are reported
+// as lossy casts, like mapped values.
+//
+// Parameters:
+//   - out: The document produced by the mapping
+//   - injections: The injections, see Options.injections
+//
+// Returns:
+//   - error: If an injection is invalid or its path collides with a mapped object or array
+func (c *converter) applyInjections(out map[string]interface{}, injections []Injection) error {
+	for _, inj := range injections {
+		inj, err := resolveInjection(inj, c.rows)
+		if err != nil {
+			return err
+		}
+		value, err := castToBaseTypeChecked(inj.Value, inj.Type, inj.Unit)
+		if err != nil && inj.Value != "" {
+			c.warn(Warning{Code: WarnLossyCast, Path: inj.Path, Message: err.Error()})
+		}
+		if err := insertNested(out, strings.Split(inj.Path, "."), value); err != nil {
+			return fmt.Errorf("cannot inject %s: %w", inj.Path, err)
+		}
+		if c.provenance != nil {
+			if inj.Value != "" {
+				c.provenance[inj.Path] = provenanceRecord{Source: "option"}
+			} else {
+				delete(c.provenance, inj.Path)
+			}
+		}
+	}
+	return nil
+}
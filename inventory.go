@@ -0,0 +1,188 @@
+package conversion
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// An item of equipment found in converted documents, such as a detector or an aperture.
+type InventoryItem struct {
+	Kind      string `json:"kind"`           // detector, or the OSCEM field naming it, e.g. c2_aperture or energy_filter
+	Name      string `json:"name"`           // name, model or size, with its unit
+	Mode      string `json:"mode,omitempty"` // detector mode, e.g. ScanningDetector
+	Documents int    `json:"documents"`      // documents listing the item
+}
+
+// The equipment of one microscope, identified by its manufacturer and model.
+type InventoryInstrument struct {
+	Manufacturer   string          `json:"manufacturer,omitempty"`
+	Model          string          `json:"model,omitempty"`
+	ElectronSource string          `json:"electron_source,omitempty"`
+	Documents      int             `json:"documents"`
+	Detectors      []InventoryItem `json:"detectors,omitempty"`
+	Apertures      []InventoryItem `json:"apertures,omitempty"`
+	Accessories    []InventoryItem `json:"accessories,omitempty"`
+}
+
+// InstrumentInventory collects the detectors, apertures and accessories of each microscope
+// from the documents of a batch into a static document for facility asset registries,
+// separate from the per-acquisition output. Acquisition settings such as exposures or
+// collection angles are left out, and items are listed once however many documents mention them.
+type InstrumentInventory struct {
+	Instruments []*InventoryInstrument `json:"instruments"`
+}
+
+// Paths of converted documents naming accessories, by the kind they are listed as.
+var inventoryAccessories = []struct {
+	kind string
+	path string
+}{
+	{"energy_filter", "acquisition.energy_filter.model"},
+	{"phaseplate", "acquisition.specialist_optics.phaseplate.instrument_type"},
+	{"spherical_aberration_corrector", "acquisition.specialist_optics.spherical_aberration_corrector.instrument_type"},
+	{"chromatic_aberration_corrector", "acquisition.specialist_optics.chromatic_aberration_corrector.instrument_type"},
+	{"holder", "acquisition.holder"},
+}
+
+// Creates an empty inventory.
+func NewInstrumentInventory() *InstrumentInventory {
+	return &InstrumentInventory{Instruments: []*InventoryInstrument{}}
+}
+
+// Adds the equipment a converted document lists to the inventory of its microscope. The
+// detectors are read from instrument.inventory.detectors when the document has one, as
+// written with Options.Inventory, and otherwise from acquisition.detectors.
+func (inv *InstrumentInventory) Add(doc []byte) error {
+	var data map[string]interface{}
+	if err := json.Unmarshal(doc, &data); err != nil {
+		return fmt.Errorf("document is not valid JSON: %w", err)
+	}
+	instrument := inv.instrument(
+		nodeText(lookupPath(data, []string{"instrument", "microscope", "manufacturer"})),
+		nodeText(lookupPath(data, []string{"instrument", "microscope", "model"})),
+	)
+	instrument.Documents++
+	if source := nodeText(lookupPath(data, []string{"instrument", "electron_source"})); source != "" {
+		instrument.ElectronSource = source
+	}
+
+	detectors := lookupPath(data, []string{"instrument", "inventory", "detectors"})
+	if detectors == nil {
+		detectors = lookupPath(data, []string{"acquisition", "detectors"})
+	}
+	var elements []interface{}
+	switch d := detectors.(type) {
+	case []interface{}:
+		elements = d
+	case map[string]interface{}:
+		// keyed by identifier
+		for _, id := range sortedKeys(d) {
+			elements = append(elements, d[id])
+		}
+	}
+	seen := make(map[string]bool)
+	for _, element := range elements {
+		m, ok := element.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		countItem(seen, &instrument.Detectors, InventoryItem{Kind: "detector", Name: nodeText(m["name"]), Mode: nodeText(m["mode"])})
+	}
+
+	for _, section := range []string{"instrument", "acquisition"} {
+		fields, _ := data[section].(map[string]interface{})
+		for _, key := range sortedKeys(fields) {
+			if strings.Contains(key, "aperture") {
+				countItem(seen, &instrument.Apertures, InventoryItem{Kind: key, Name: nodeText(fields[key])})
+			}
+		}
+	}
+	for _, accessory := range inventoryAccessories {
+		name := nodeText(lookupPath(data, strings.Split(accessory.path, ".")))
+		countItem(seen, &instrument.Accessories, InventoryItem{Kind: accessory.kind, Name: name})
+	}
+	return nil
+}
+
+// Returns the inventory of a microscope, adding it when it is new.
+func (inv *InstrumentInventory) instrument(manufacturer, model string) *InventoryInstrument {
+	for _, instrument := range inv.Instruments {
+		if strings.EqualFold(instrument.Manufacturer, manufacturer) && strings.EqualFold(instrument.Model, model) {
+			return instrument
+		}
+	}
+	instrument := &InventoryInstrument{Manufacturer: manufacturer, Model: model}
+	inv.Instruments = append(inv.Instruments, instrument)
+	sort.SliceStable(inv.Instruments, func(i, j int) bool {
+		a, b := inv.Instruments[i], inv.Instruments[j]
+		if a.Manufacturer != b.Manufacturer {
+			return a.Manufacturer < b.Manufacturer
+		}
+		return a.Model < b.Model
+	})
+	return instrument
+}
+
+// Counts an item for a microscope once per document, adding it to the list when it is new.
+// Items without a name are skipped, as are repeated ones within the document, tracked in seen.
+func countItem(seen map[string]bool, list *[]InventoryItem, item InventoryItem) {
+	if item.Name == "" {
+		return
+	}
+	key := strings.ToLower(item.Kind + "\x00" + item.Name)
+	if seen[key] {
+		return
+	}
+	seen[key] = true
+	for i := range *list {
+		if strings.EqualFold((*list)[i].Kind, item.Kind) && strings.EqualFold((*list)[i].Name, item.Name) {
+			(*list)[i].Documents++
+			return
+		}
+	}
+	item.Documents = 1
+	*list = append(*list, item)
+	sort.SliceStable(*list, func(i, j int) bool {
+		a, b := (*list)[i], (*list)[j]
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.Name < b.Name
+	})
+}
+
+// Returns a decoded document value as text, with the unit of value and unit objects, e.g.
+// "50 um", or "" for missing values, objects and arrays.
+func nodeText(value interface{}) string {
+	if m, ok := value.(map[string]interface{}); ok {
+		inner, hasValue := m["value"]
+		if !hasValue {
+			return ""
+		}
+		text := nodeText(inner)
+		if unit, ok := m["unit"].(string); ok && unit != "" && text != "" {
+			text += " " + unit
+		}
+		return text
+	}
+	switch v := value.(type) {
+	case nil, []interface{}:
+		return ""
+	case string:
+		return strings.TrimSpace(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Returns the keys of a decoded object in order.
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,59 @@
+package conversion
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+)
+
+// The name of the built-in extractor reading JEOL CRYO ARM parameter files.
+const JEOLExtractor = "jeol"
+
+// Embedded mapping tables used when a conversion selects their extractor but no mapping,
+// because the keys of these sources differ from the EPU and SerialEM ones of the default mapping.
+var extractorMappings = map[string]string{
+	JEOLExtractor:    "csv/jeol_conversions.csv",
+	LeginonExtractor: "csv/leginon_conversions.csv",
+}
+
+func init() {
+	RegisterExtractor(jeolExtractor{})
+}
+
+// Reads the parameter files JEOL CRYO ARM microscopes save next to their images: "key = value"
+// lines grouped by [Section] headers, with ";" or "#" starting comments. Keys are prefixed with
+// their section, e.g. HighTension in [EOS] becomes EOS.HighTension, matching csv/jeol_conversions.csv.
+type jeolExtractor struct{}
+
+func (jeolExtractor) Name() string { return JEOLExtractor }
+
+func (jeolExtractor) Extract(_ context.Context, src []byte) (map[string]string, error) {
+	values := make(map[string]string)
+	section := ""
+	scanner := bufio.NewScanner(bytes.NewReader(bytes.TrimPrefix(src, []byte("\ufeff"))))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, ";") || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if strings.HasPrefix(text, "[") && strings.HasSuffix(text, "]") {
+			section = strings.TrimSpace(text[1 : len(text)-1])
+			continue
+		}
+		key, value, ok := strings.Cut(text, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key = value, got %q", line, text)
+		}
+		key = strings.TrimSpace(key)
+		if section != "" {
+			key = section + "." + key
+		}
+		values[key] = strings.Trim(strings.TrimSpace(value), `"`)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read JEOL parameters: %w", err)
+	}
+	return values, nil
+}
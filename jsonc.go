@@ -0,0 +1,94 @@
+package conversion
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// The name of the built-in extractor decoding a flat JSON object that may contain comments
+// and trailing commas, for hand-curated inputs.
+const JSONCExtractor = "jsonc"
+
+func init() {
+	RegisterExtractor(jsoncExtractor{})
+}
+
+// Decodes a flat JSON object written by hand, with // and /* */ comments and trailing commas
+// allowed, see stripJSONC. Unlike the default extractor it rejects non-string values.
+type jsoncExtractor struct{}
+
+func (jsoncExtractor) Name() string { return JSONCExtractor }
+
+func (jsoncExtractor) Extract(_ context.Context, src []byte) (map[string]string, error) {
+	text, err := decodeText(src)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode input: %w", err)
+	}
+	var values map[string]string
+	if err := json.Unmarshal(stripJSONC([]byte(text)), &values); err != nil {
+		return nil, fmt.Errorf("input is not a flat JSON object: %w", err)
+	}
+	return values, nil
+}
+
+// Turns JSON with comments (JSONC) into plain JSON: // line and /* */ block comments are
+// removed, as are commas directly before a closing bracket or brace. String contents are
+// left untouched. Comments are replaced by spaces and newlines, so offsets reported by the
+// JSON decoder still point to the right line.
+func stripJSONC(src []byte) []byte {
+	out := make([]byte, 0, len(src))
+	// index in out of a comma that is only valid if a value follows, -1 if none
+	pendingComma := -1
+	for i := 0; i < len(src); i++ {
+		c := src[i]
+		switch {
+		case c == '"':
+			pendingComma = -1
+			start := i
+			for i++; i < len(src) && src[i] != '"'; i++ {
+				if src[i] == '\\' {
+					i++
+				}
+			}
+			if i >= len(src) {
+				i = len(src) - 1
+			}
+			out = append(out, src[start:i+1]...)
+		case c == '/' && i+1 < len(src) && src[i+1] == '/':
+			for i < len(src) && src[i] != '\n' {
+				out = append(out, ' ')
+				i++
+			}
+			if i < len(src) {
+				out = append(out, '\n')
+			}
+		case c == '/' && i+1 < len(src) && src[i+1] == '*':
+			out = append(out, ' ', ' ')
+			for i += 2; i < len(src) && !(src[i] == '*' && i+1 < len(src) && src[i+1] == '/'); i++ {
+				if src[i] == '\n' {
+					out = append(out, '\n')
+				} else {
+					out = append(out, ' ')
+				}
+			}
+			out = append(out, ' ', ' ')
+			i++
+		case c == ',':
+			pendingComma = len(out)
+			out = append(out, c)
+		case c == '}' || c == ']':
+			if pendingComma >= 0 {
+				out[pendingComma] = ' '
+				pendingComma = -1
+			}
+			out = append(out, c)
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			out = append(out, c)
+		default:
+			pendingComma = -1
+			out = append(out, c)
+		}
+	}
+	return out
+}
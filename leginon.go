@@ -0,0 +1,315 @@
+package conversion
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// The name of the built-in extractor reading Leginon/Appion session exports.
+const LeginonExtractor = "leginon"
+
+func init() {
+	RegisterExtractor(leginonExtractor{})
+}
+
+// Reads the tables of a Leginon session exported from its database, so facilities running
+// Leginon can back-fill OSCEM records of past sessions. Both a mysqldump of the session's
+// tables and a JSON export are accepted; the latter is an object mapping table names to an
+// array of row objects, or to a single row object.
+//
+// Each table yields the keys
+//
+//	<Table>.<column>, <Table>.<column>_min, <Table>.<column>_max, <Table>._count
+//
+// where the plain key holds the value of the first row, the _min and _max keys the range of
+// numeric columns over all rows and _count the number of rows, e.g. ScopeEMData.defocus_min
+// or AcquisitionImageData._count. NULL values are skipped. Values keep the units of the
+// Leginon database (SI units, exposure times in ms), csv/leginon_conversions.csv converts them.
+type leginonExtractor struct{}
+
+func (leginonExtractor) Name() string { return LeginonExtractor }
+
+func (leginonExtractor) Extract(_ context.Context, src []byte) (map[string]string, error) {
+	src = bytes.TrimSpace(bytes.TrimPrefix(src, []byte("\ufeff")))
+	var tables map[string][]map[string]string
+	var order []string
+	var err error
+	if bytes.HasPrefix(src, []byte("{")) {
+		tables, order, err = parseLeginonJSON(src)
+	} else {
+		tables, order, err = parseLeginonDump(string(src))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string)
+	for _, table := range order {
+		rows := tables[table]
+		values[table+"._count"] = strconv.Itoa(len(rows))
+		if len(rows) == 0 {
+			continue
+		}
+		for column, value := range rows[0] {
+			values[table+"."+column] = value
+		}
+		for column, bounds := range leginonRanges(rows) {
+			values[table+"."+column+"_min"] = strconv.FormatFloat(bounds[0], 'g', 15, 64)
+			values[table+"."+column+"_max"] = strconv.FormatFloat(bounds[1], 'g', 15, 64)
+		}
+	}
+	return values, nil
+}
+
+// Computes the minimum and maximum of every column whose values are all numbers.
+func leginonRanges(rows []map[string]string) map[string][2]float64 {
+	ranges := make(map[string][2]float64)
+	skip := make(map[string]bool)
+	for _, row := range rows {
+		for column, value := range row {
+			if skip[column] {
+				continue
+			}
+			number, err := strconv.ParseFloat(value, 64)
+			if err != nil || math.IsNaN(number) || math.IsInf(number, 0) {
+				skip[column] = true
+				delete(ranges, column)
+				continue
+			}
+			bounds, ok := ranges[column]
+			if !ok {
+				bounds = [2]float64{number, number}
+			}
+			bounds[0] = math.Min(bounds[0], number)
+			bounds[1] = math.Max(bounds[1], number)
+			ranges[column] = bounds
+		}
+	}
+	return ranges
+}
+
+// Parses a JSON export into its tables, keeping the order in which they appear.
+// Nested objects within a row are flattened with ".".
+func parseLeginonJSON(src []byte) (map[string][]map[string]string, []string, error) {
+	dec := json.NewDecoder(bytes.NewReader(src))
+	dec.UseNumber()
+	if _, err := dec.Token(); err != nil {
+		return nil, nil, fmt.Errorf("failed to read Leginon export: %w", err)
+	}
+	tables := make(map[string][]map[string]string)
+	var order []string
+	for dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read Leginon export: %w", err)
+		}
+		table := token.(string)
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return nil, nil, fmt.Errorf("failed to read table %s of the Leginon export: %w", table, err)
+		}
+		var rows []map[string]interface{}
+		if bytes.HasPrefix(bytes.TrimSpace(raw), []byte("{")) {
+			var row map[string]interface{}
+			err = decodeNumbers(raw, &row)
+			rows = append(rows, row)
+		} else {
+			err = decodeNumbers(raw, &rows)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("table %s of the Leginon export is neither a row nor a list of rows: %w", table, err)
+		}
+		if _, seen := tables[table]; !seen {
+			order = append(order, table)
+		}
+		for _, row := range rows {
+			flat := make(map[string]string)
+			flattenOverlay(row, "", flat)
+			tables[table] = append(tables[table], flat)
+		}
+	}
+	return tables, order, nil
+}
+
+// Decodes JSON keeping numbers as their literal text.
+func decodeNumbers(raw []byte, target interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	return dec.Decode(target)
+}
+
+// Parses the CREATE TABLE and INSERT INTO statements of a mysqldump into its tables, keeping
+// the order in which they appear. Inserts without a column list take the columns of the
+// table's CREATE TABLE statement, in the form mysqldump writes them, one statement per line.
+func parseLeginonDump(src string) (map[string][]map[string]string, []string, error) {
+	tables := make(map[string][]map[string]string)
+	columns := make(map[string][]string)
+	var order []string
+	creating := ""
+	for i, line := range strings.Split(src, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case creating != "":
+			if strings.HasPrefix(line, "`") {
+				if name, _, ok := cutQuotedName(line); ok {
+					columns[creating] = append(columns[creating], name)
+				}
+			} else if strings.HasPrefix(line, ")") {
+				creating = ""
+			}
+		case strings.HasPrefix(line, "CREATE TABLE "):
+			name, _, ok := cutQuotedName(strings.TrimPrefix(strings.TrimPrefix(line, "CREATE TABLE "), "IF NOT EXISTS "))
+			if !ok {
+				return nil, nil, fmt.Errorf("line %d: cannot read the table name of %q", i+1, line)
+			}
+			creating = name
+			columns[name] = nil
+		case strings.HasPrefix(line, "INSERT INTO "):
+			table, rest, ok := cutQuotedName(strings.TrimPrefix(line, "INSERT INTO "))
+			if !ok {
+				return nil, nil, fmt.Errorf("line %d: cannot read the table name of the insert", i+1)
+			}
+			rows, err := parseInsertValues(rest, columns[table])
+			if err != nil {
+				return nil, nil, fmt.Errorf("line %d: insert into %s: %w", i+1, table, err)
+			}
+			if _, seen := tables[table]; !seen {
+				order = append(order, table)
+			}
+			tables[table] = append(tables[table], rows...)
+		}
+	}
+	if len(order) == 0 {
+		return nil, nil, fmt.Errorf("no INSERT INTO statements found, expected a mysqldump or JSON export of a Leginon session")
+	}
+	return tables, order, nil
+}
+
+// Splits a name quoted with backticks off the start of text, returning the rest after it.
+func cutQuotedName(text string) (string, string, bool) {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, "`") {
+		return "", "", false
+	}
+	end := strings.IndexByte(text[1:], '`')
+	if end < 0 {
+		return "", "", false
+	}
+	return text[1 : end+1], strings.TrimSpace(text[end+2:]), true
+}
+
+// Parses the optional column list and the VALUES tuples of an insert statement.
+func parseInsertValues(text string, columns []string) ([]map[string]string, error) {
+	if strings.HasPrefix(text, "(") {
+		end := strings.IndexByte(text, ')')
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated column list")
+		}
+		columns = nil
+		for _, name := range strings.Split(text[1:end], ",") {
+			columns = append(columns, strings.Trim(strings.TrimSpace(name), "`"))
+		}
+		text = strings.TrimSpace(text[end+1:])
+	}
+	if !strings.HasPrefix(strings.ToUpper(text), "VALUES") {
+		return nil, fmt.Errorf("expected VALUES")
+	}
+	text = strings.TrimSpace(text[len("VALUES"):])
+
+	var rows []map[string]string
+	for len(text) > 0 && text[0] == '(' {
+		tuple, rest, err := parseSQLTuple(text[1:])
+		if err != nil {
+			return nil, err
+		}
+		if len(tuple) != len(columns) {
+			return nil, fmt.Errorf("row %d has %d values for %d columns", len(rows)+1, len(tuple), len(columns))
+		}
+		row := make(map[string]string)
+		for i, value := range tuple {
+			if value != nil {
+				row[columns[i]] = *value
+			}
+		}
+		rows = append(rows, row)
+		text = strings.TrimLeft(strings.TrimSpace(rest), ",")
+		text = strings.TrimSpace(text)
+	}
+	if text != "" && text != ";" {
+		return nil, fmt.Errorf("unexpected %q after the values", text)
+	}
+	return rows, nil
+}
+
+// Parses one value tuple up to its closing parenthesis, returning the values and the text
+// after it; NULL values are nil. Strings may use backslash escapes or doubled quotes.
+func parseSQLTuple(text string) ([]*string, string, error) {
+	var values []*string
+	for i := 0; i < len(text); {
+		switch text[i] {
+		case ' ', ',':
+			i++
+		case ')':
+			return values, text[i+1:], nil
+		case '\'':
+			var b strings.Builder
+			i++
+			for ; i < len(text); i++ {
+				if text[i] == '\\' && i+1 < len(text) {
+					i++
+					b.WriteString(sqlEscape(text[i]))
+					continue
+				}
+				if text[i] == '\'' {
+					if i+1 < len(text) && text[i+1] == '\'' {
+						b.WriteByte('\'')
+						i++
+						continue
+					}
+					break
+				}
+				b.WriteByte(text[i])
+			}
+			if i >= len(text) {
+				return nil, "", fmt.Errorf("unterminated string")
+			}
+			value := b.String()
+			values = append(values, &value)
+			i++
+		default:
+			end := strings.IndexAny(text[i:], ",)")
+			if end < 0 {
+				return nil, "", fmt.Errorf("unterminated row")
+			}
+			value := strings.TrimSpace(text[i : i+end])
+			if strings.EqualFold(value, "NULL") {
+				values = append(values, nil)
+			} else {
+				values = append(values, &value)
+			}
+			i += end
+		}
+	}
+	return nil, "", fmt.Errorf("unterminated row")
+}
+
+// Returns the character a MySQL backslash escape stands for.
+func sqlEscape(c byte) string {
+	switch c {
+	case 'n':
+		return "\n"
+	case 'r':
+		return "\r"
+	case 't':
+		return "\t"
+	case '0':
+		return "\x00"
+	default:
+		return string(c)
+	}
+}
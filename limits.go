@@ -0,0 +1,74 @@
+package conversion
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Limits caps the size and complexity of an input, so the server and daemon modes reject
+// pathological inputs, e.g. from a buggy extractor, with a clear error instead of spending
+// unbounded memory and time on them. A zero field sets no limit.
+type Limits struct {
+	MaxInputKeys     int // keys of the flat input, including those of the overlay
+	MaxArrayElements int // elements of one array captured by [N] patterns, e.g. detectors
+	MaxDepth         int // "." separated segments of an input key, e.g. 3 for Detectors.Detector-1.DetectorName
+}
+
+// The limits the server and JSON-RPC modes apply unless configured otherwise, far above
+// the inputs of any real acquisition.
+var DefaultLimits = Limits{MaxInputKeys: 100000, MaxArrayElements: 10000, MaxDepth: 64}
+
+// LimitError reports an input exceeding one of the Limits.
+type LimitError struct {
+	Limit string // the Limits field exceeded, e.g. MaxInputKeys
+	At    string // the input key or array concerned, if any
+	Value int    // the size of the input
+	Max   int    // the limit
+}
+
+func (e *LimitError) Error() string {
+	at := e.At
+	if len(at) > 100 {
+		// a pathological key must not blow up the message
+		at = at[:100] + "..."
+	}
+	switch e.Limit {
+	case "MaxArrayElements":
+		return fmt.Sprintf("input exceeds the limit of %d array elements: %s has %d", e.Max, at, e.Value)
+	case "MaxDepth":
+		return fmt.Sprintf("input exceeds the limit of %d key segments: %s has %d", e.Max, at, e.Value)
+	}
+	return fmt.Sprintf("input exceeds the limit of %d keys: it has %d", e.Max, e.Value)
+}
+
+// Checks the number of keys of an input and the depth of each key against the limits.
+// Of several keys too deep, the first in order is reported, so the error is the same on
+// every run.
+func (l Limits) checkInput(values map[string]string) error {
+	if l.MaxInputKeys > 0 && len(values) > l.MaxInputKeys {
+		return &LimitError{Limit: "MaxInputKeys", Value: len(values), Max: l.MaxInputKeys}
+	}
+	if l.MaxDepth <= 0 {
+		return nil
+	}
+	var deep []string
+	for key := range values {
+		if strings.Count(key, ".")+1 > l.MaxDepth {
+			deep = append(deep, key)
+		}
+	}
+	if len(deep) == 0 {
+		return nil
+	}
+	sort.Strings(deep)
+	return &LimitError{Limit: "MaxDepth", At: deep[0], Value: strings.Count(deep[0], ".") + 1, Max: l.MaxDepth}
+}
+
+// Checks the number of elements of an array against the limits.
+func (l Limits) checkArray(at string, elements int) error {
+	if l.MaxArrayElements > 0 && elements > l.MaxArrayElements {
+		return &LimitError{Limit: "MaxArrayElements", At: at, Value: elements, Max: l.MaxArrayElements}
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package conversion
+
+import (
+	"fmt"
+	"strings"
+)
+
+// How a ";"-separated list of sources mapped to a single field resolves entries holding
+// different values, selected by Options.ListPolicy.
+const (
+	ListPolicyFirst = "first" // the first non-empty entry, also the default when empty
+	ListPolicyLast  = "last"  // the last non-empty entry
+	ListPolicyError = "error" // fail the conversion
+	ListPolicyArray = "array" // every distinct value, in list order, as an array
+)
+
+// Rejects list policies the converter does not know.
+func checkListPolicy(policy string) error {
+	switch policy {
+	case "", ListPolicyFirst, ListPolicyLast, ListPolicyError, ListPolicyArray:
+		return nil
+	}
+	return fmt.Errorf("unknown list policy %q, expected %s, %s, %s or %s", policy, ListPolicyFirst, ListPolicyLast, ListPolicyError, ListPolicyArray)
+}
+
+// A non-empty entry of a ";"-separated list, with the source key it was read from.
+type listEntry struct {
+	index int
+	key   string
+	value string
+}
+
+// Returns the entries of a ";"-separated list that hold a value, and the first entry of
+// each distinct value among them. Values are compared after their extraction and unit
+// conversion, so sources in different units agree when they describe the same quantity.
+func listEntries(row csvextract, rawValues []string, crunchFactor string, source string) (entries []listEntry, distinct []listEntry) {
+	sources := strings.Split(source, ";")
+	seen := make(map[string]bool)
+	for i, raw := range rawValues {
+		if strings.TrimSpace(raw) == "" {
+			continue
+		}
+		value, _ := extractValue(row, strings.TrimSpace(raw))
+		value, _ = applyUnitCrunch(elementCrunch(crunchFactor, i), value, row.Units)
+		key := source
+		if i < len(sources) {
+			key = strings.TrimSpace(sources[i])
+		}
+		entries = append(entries, listEntry{index: i, key: key, value: raw})
+		if !seen[value] {
+			seen[value] = true
+			distinct = append(distinct, listEntry{index: i, key: key, value: raw})
+		}
+	}
+	return entries, distinct
+}
+
+// Picks the entries of a ";"-separated list that make up the value of a single field under
+// the converter's list policy, reporting lists whose entries disagree.
+//
+// Parameters:
+//   - row: CSV mapping rule of the field
+//   - rawValues: Values found in the input data, one per source key
+//   - crunchFactor: Unit conversion factor, or one per source key
+//   - source: The ";"-separated source keys
+//
+// Returns:
+//   - []listEntry: The entry to write, or with ListPolicyArray all distinct ones; none if the list is empty
+//   - error: If the entries disagree under ListPolicyError
+func (c *converter) resolveList(row csvextract, rawValues []string, crunchFactor string, source string) ([]listEntry, error) {
+	entries, distinct := listEntries(row, rawValues, crunchFactor, source)
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	if len(distinct) > 1 {
+		described := make([]string, len(distinct))
+		for i, entry := range distinct {
+			described[i] = fmt.Sprintf("%s=%q", entry.key, strings.TrimSpace(entry.value))
+		}
+		message := fmt.Sprintf("the sources hold conflicting values %s", strings.Join(described, ", "))
+		switch c.listPolicy {
+		case ListPolicyError:
+			return nil, fmt.Errorf("row %d (%s): %s", row.Line, row.OSCEM, message)
+		case ListPolicyArray:
+			message += ", writing all of them"
+		case ListPolicyLast:
+			message += ", using the last"
+		default:
+			message += ", using the first"
+		}
+		c.warn(Warning{Code: WarnListConflict, Path: row.OSCEM, Row: row.Line, Message: message})
+	}
+	switch {
+	case c.listPolicy == ListPolicyArray && len(distinct) > 1:
+		return distinct, nil
+	case c.listPolicy == ListPolicyLast:
+		return entries[len(entries)-1:], nil
+	default:
+		return entries[:1], nil
+	}
+}
+
+// Reports whether any source column of a row lists several ";"-separated keys.
+func hasSourceList(row csvextract) bool {
+	for _, field := range []string{row.OptionalsMDOC, row.FromMDOC, row.OptionalsXML, row.FromXML} {
+		if strings.Contains(field, ";") {
+			return true
+		}
+	}
+	for _, fallback := range row.Fallbacks {
+		if strings.Contains(fallback.Field, ";") {
+			return true
+		}
+	}
+	return false
+}
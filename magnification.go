@@ -0,0 +1,82 @@
+package conversion
+
+import (
+	"fmt"
+
+	"github.com/osc-em/oscem-converter-extracted/basetypes"
+)
+
+// Fills in acquisition.pixel_size when the input lacks it, from the physical pixel size of
+// the detector, the camera binning and the magnification. The calibrated magnification is
+// preferred; falling back to the nominal one is reported, as it is usually off by a few percent.
+//
+// Parameters:
+//   - out: The converted document, before cleaning
+//
+// Returns:
+//   - error: If the derived pixel size cannot be stored
+func (c *converter) derivePixelSize(out map[string]interface{}) error {
+	if _, ok := numberAt(out, "acquisition", "pixel_size"); ok {
+		return nil
+	}
+	physical, ok := numberAt(out, "acquisition", "physical_pixel_size")
+	if !ok {
+		return nil
+	}
+	magnification, ok := numberAt(out, "acquisition", "calibrated_magnification")
+	if !ok {
+		if magnification, ok = numberAt(out, "acquisition", "nominal_magnification"); !ok {
+			return nil
+		}
+		c.warn(Warning{
+			Code:    WarnDerived,
+			Path:    "acquisition.pixel_size",
+			Message: "derived from the nominal magnification, as no calibrated magnification is given",
+		})
+	}
+	if magnification.value <= 0 {
+		return nil
+	}
+	factor, err := crunchMultiplier(physical.unit, "Å")
+	if err != nil {
+		c.warn(Warning{
+			Code:    WarnDerived,
+			Path:    "acquisition.pixel_size",
+			Message: fmt.Sprintf("not derived from the physical pixel size: %v", err),
+		})
+		return nil
+	}
+	binning := 1.0
+	if width, ok := numberAt(out, "acquisition", "binning_camera", "width"); ok && width.value > 0 {
+		binning = width.value
+	}
+
+	var pixelSize basetypes.Float64
+	// written with the angstrom sign, like the pixel sizes of the mapping tables
+	pixelSize.Set(physical.value*factor*binning/magnification.value, "\u212b")
+	if err := insertNested(out, []string{"acquisition", "pixel_size"}, pixelSize); err != nil {
+		return fmt.Errorf("cannot set the derived pixel size: %w", err)
+	}
+	if c.provenance != nil {
+		c.provenance["acquisition.pixel_size"] = provenanceRecord{Source: "derived"}
+	}
+	return nil
+}
+
+// A number read back from the document being built, with its unit.
+type documentNumber struct {
+	value float64
+	unit  string
+}
+
+// Returns the set Int or Float64 at a path of the document being built.
+func numberAt(out map[string]interface{}, path ...string) (documentNumber, bool) {
+	switch v := lookupPath(out, path).(type) {
+	case basetypes.Int:
+		return documentNumber{float64(v.Value), v.Unit}, v.HasSet
+	case basetypes.Float64:
+		return documentNumber{v.Value, v.Unit}, v.HasSet
+	default:
+		return documentNumber{}, false
+	}
+}
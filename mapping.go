@@ -1,26 +1,88 @@
 package conversion
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"os"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 
 	"github.com/osc-em/oscem-converter-extracted/basetypes"
 )
 
-// Global storage for dynamic field patterns that weren't found in input and contain [N] notation.
-var dynamicFieldPatterns []csvextract
+// Holds the state of a single conversion, so concurrent conversions share nothing.
+type converter struct {
+	hooks Hooks
+	// Dynamic field patterns that weren't found in input and contain [N] notation.
+	dynamicFieldPatterns []csvextract
+	// The row that last wrote each output path, to report rows overwriting each other.
+	writtenBy map[string]csvextract
+	// Whether empty entries of ";"-separated lists still occupy their array element.
+	keepEmptySlots bool
+	// How a ";"-separated list mapped to a single field resolves disagreeing entries, see ListPolicyFirst.
+	listPolicy string
+	// Whether arrays are reduced to the elements reference rows name as in use, and the complete ones kept under instrument.inventory.
+	activeOnly, inventory bool
+	// Caps on the size of the input, see Options.Limits.
+	limits Limits
+	// Where each output value came from, keyed by output path; nil unless provenance is requested.
+	provenance map[string]provenanceRecord
+	// The identifiers captured for [N] of the elements of positional arrays, by array path and position.
+	elementIDs map[string]map[int]string
+	// Problems that did not stop the conversion.
+	warnings []Warning
+	// The rows that wrote a value, by rowKey, and the statistics of the conversion.
+	rowsWritten map[string]bool
+	stats       Stats
+	// The mapping rows and input of the conversion, for values derived from other fields;
+	// the rows default to those converted.
+	rows  []csvextract
+	input map[string]string
+	// Input keys by their folded form, see foldKey; built on the first missing key.
+	nearKeys map[string][]string
+	// Source keys of the mapping by their folded form, limiting nearKeys to them; nil indexes
+	// every input key.
+	sourceFolds map[string]bool
+	// The compiled [N] patterns of the plan, by pattern, see patternRegexp.
+	regexps map[string]*regexp.Regexp
+	// The transformations processValue applied to the last value; only recorded by Explain.
+	tracing bool
+	steps   []string
+}
 
-func convertToHierarchicalJSON(rows []csvextract, input map[string]string) (map[string]interface{}, error) {
+func (c *converter) convertToHierarchicalJSON(ctx context.Context, rows []csvextract, input map[string]string) (map[string]interface{}, error) {
 	result := make(map[string]interface{})
 
 	// Clear any previously stored dynamic field patterns
-	dynamicFieldPatterns = nil
+	c.dynamicFieldPatterns = nil
+	c.writtenBy = make(map[string]csvextract)
+	c.elementIDs = make(map[string]map[int]string)
+	c.rowsWritten = make(map[string]bool)
+	if c.rows == nil {
+		c.rows = rows
+	}
+	c.input = input
+	c.nearKeys = nil
 	// Process regular mappings first - these handle direct field-to-field mappings
-	processRegularMappings(result, rows, input)
+	if err := c.processRegularMappings(ctx, result, rows, input); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	// Then process dynamic array fields - these handle patterns like [N]
-	processDynamicArrayFields(result, dynamicFieldPatterns, input)
+	if err := c.processDynamicArrayFields(result, c.dynamicFieldPatterns, input); err != nil {
+		return nil, err
+	}
+	// Finally check that the input only references array elements that were captured
+	if err := c.checkReferences(result, rows, input); err != nil {
+		return nil, err
+	}
 
 	return result, nil
 }
@@ -33,20 +95,41 @@ func convertToHierarchicalJSON(rows []csvextract, input map[string]string) (map[
 //   - result: The output map being built
 //   - rows: CSV mapping rules
 //   - input: Source data as key-value pairs
-func processRegularMappings(result map[string]interface{}, rows []csvextract, input map[string]string) {
+//
+// Returns:
+//   - error: The context error if the conversion was cancelled, or a collision between rows
+func (c *converter) processRegularMappings(ctx context.Context, result map[string]interface{}, rows []csvextract, input map[string]string) error {
 	for _, row := range rows {
+		err := ctx.Err()
+		if err != nil {
+			return err
+		}
+		if row.Presence {
+			if err := c.handlePresenceField(result, row, input); err != nil {
+				return err
+			}
+			continue
+		}
+		if row.Reference {
+			continue // checked once the arrays are complete
+		}
 		// Try to find a matching value in the input data
-		rawValues, crunchFactor, found := findMatchingValues(row, input, extractValuesFromInput)
+		rawValues, crunchFactor, source, found := findMatchingValues(row, input, c.extractValuesFromInput)
 		if !found {
+			c.suggestNearMisses(row, input)
 			continue
 		}
 		// Determine if this is an array field (contains [N] notation) or regular field
 		if strings.Contains(row.OSCEM, "[N]") {
-			handleArrayField(result, row, rawValues, crunchFactor)
+			err = c.handleArrayField(result, row, rawValues, crunchFactor, source)
 		} else {
-			handleRegularField(result, row, rawValues, crunchFactor)
+			err = c.handleRegularField(result, row, rawValues, crunchFactor, source)
+		}
+		if err != nil {
+			return err
 		}
 	}
+	return nil
 }
 
 // A function type that defines how to extract values from input data.
@@ -54,6 +137,7 @@ type ValueExtractor func(csvextract, map[string]string, string) ([]string, bool)
 
 // Searches for input data that matches a CSV mapping row using a priority system.
 // The first matching field found is used, along with its corresponding unit conversion factor.
+// If none of the fixed columns yields a non-empty value, the row's fallbacks are tried in order.
 //
 // Parameters:
 //   - row: CSV mapping rules
@@ -63,8 +147,9 @@ type ValueExtractor func(csvextract, map[string]string, string) ([]string, bool)
 // Returns:
 //   - []string: Array of values found
 //   - string: Unit conversion factor to apply
+//   - string: The source field the values were taken from, ";"-separated for lists
 //   - bool: Whether any matching values were found
-func findMatchingValues(row csvextract, input map[string]string, extractor ValueExtractor) ([]string, string, bool) {
+func findMatchingValues(row csvextract, input map[string]string, extractor ValueExtractor) ([]string, string, string, bool) {
 	// Priority order: optionals_mdoc > frommdoc > optionals_xml > fromxml
 	checks := []struct {
 		field  string
@@ -79,11 +164,37 @@ func findMatchingValues(row csvextract, input map[string]string, extractor Value
 	for _, check := range checks {
 		if check.field != "" {
 			if values, found := extractor(row, input, check.field); found {
-				return values, check.crunch, true
+				if len(row.Fallbacks) > 0 && allEmpty(values) {
+					// a present but empty value still lets the fallbacks have a go
+					if fbValues, fbCrunch, fbField, ok := findFallbackValues(row, input, extractor); ok {
+						return fbValues, fbCrunch, fbField, true
+					}
+				}
+				return values, check.crunch, check.field, true
 			}
 		}
 	}
-	return nil, "", false
+	return findFallbackValues(row, input, extractor)
+}
+
+// Tries the row's fallback source keys in order and returns the first one holding a non-empty value.
+func findFallbackValues(row csvextract, input map[string]string, extractor ValueExtractor) ([]string, string, string, bool) {
+	for _, fallback := range row.Fallbacks {
+		if values, found := extractor(row, input, fallback.Field); found && !allEmpty(values) {
+			return values, fallback.Crunch, fallback.Field, true
+		}
+	}
+	return nil, "", "", false
+}
+
+// Reports whether every value is an empty string.
+func allEmpty(values []string) bool {
+	for _, v := range values {
+		if strings.TrimSpace(v) != "" {
+			return false
+		}
+	}
+	return true
 }
 
 // Extracts values from input data based on field patterns.
@@ -99,7 +210,7 @@ func findMatchingValues(row csvextract, input map[string]string, extractor Value
 // Returns:
 //   - []string: Array of values found
 //   - bool: Whether any matching values were found
-func extractValuesFromInput(row csvextract, input map[string]string, key string) ([]string, bool) {
+func (c *converter) extractValuesFromInput(row csvextract, input map[string]string, key string) ([]string, bool) {
 	if strings.Contains(key, ";") {
 		// Handle semicolon-separated field names (e.g., "field1;field2;field3")
 		fieldNames := strings.Split(key, ";")
@@ -119,7 +230,7 @@ func extractValuesFromInput(row csvextract, input map[string]string, key string)
 				result = append(result, val)
 				foundAny = true
 			} else {
-				storeUnmappedField(row, fieldName)
+				c.storeUnmappedField(row, fieldName, len(result))
 				// Append an empty string to maintain alignment
 				result = append(result, "")
 			}
@@ -130,7 +241,7 @@ func extractValuesFromInput(row csvextract, input map[string]string, key string)
 		if val, exists := input[key]; exists {
 			return []string{val}, true
 		} else {
-			storeUnmappedField(row, key)
+			c.storeUnmappedField(row, key, 0)
 			return nil, false
 		}
 	}
@@ -141,11 +252,12 @@ func extractValuesFromInput(row csvextract, input map[string]string, key string)
 //
 // Parameters:
 //   - fieldName: The field name that wasn't found in the input data
-func storeUnmappedField(row csvextract, fieldName string) {
+//   - index: Position of the field in its ";"-separated source list, selecting its crunch factor
+func (c *converter) storeUnmappedField(row csvextract, fieldName string, index int) {
 	if strings.Contains(fieldName, "[N]") {
 		// Check if we haven't already stored this pattern
 		alreadyStored := false
-		for _, stored := range dynamicFieldPatterns {
+		for _, stored := range c.dynamicFieldPatterns {
 			if stored.FromMDOC == fieldName {
 				alreadyStored = true
 				break
@@ -153,14 +265,18 @@ func storeUnmappedField(row csvextract, fieldName string) {
 		}
 		if !alreadyStored && strings.Contains(row.OSCEM, "[N]") {
 			newRow := csvextract{
+				Layout:         row.Layout,
+				Line:           row.Line,
 				OSCEM:          row.OSCEM,
 				FromMDOC:       fieldName,
 				OptionalsMDOC:  row.OptionalsMDOC,
 				Units:          row.Units,
-				CrunchFromMDOC: row.CrunchFromMDOC,
+				CrunchFromMDOC: elementCrunch(row.CrunchFromMDOC, index),
 				Type:           row.Type,
+				ArrayKeyed:     row.ArrayKeyed,
+				Extract:        row.Extract,
 			}
-			dynamicFieldPatterns = append(dynamicFieldPatterns, newRow)
+			c.dynamicFieldPatterns = append(c.dynamicFieldPatterns, newRow)
 		}
 	}
 }
@@ -172,13 +288,69 @@ func storeUnmappedField(row csvextract, fieldName string) {
 //   - row: CSV mapping rule for this field
 //   - rawValues: Values found in the input data
 //   - crunchFactor: Unit conversion factor to apply
-func handleRegularField(result map[string]interface{}, row csvextract, rawValues []string, crunchFactor string) {
+//   - source: The source field the values were taken from
+//
+// Returns:
+//   - error: If the value collides with the output of another row
+func (c *converter) handleRegularField(result map[string]interface{}, row csvextract, rawValues []string, crunchFactor string, source string) error {
+	if len(rawValues) > 1 {
+		return c.handleListField(result, row, rawValues, crunchFactor, source)
+	}
 	if len(rawValues) > 0 {
 		// Process the first value (apply unit conversion and type casting)
-		value := processValue(rawValues[0], crunchFactor, row)
+		crunchFactor = elementCrunch(crunchFactor, 0)
+		value := c.processValue(rawValues[0], crunchFactor, row)
+		if value == nil {
+			return nil // dropped by the extract pattern
+		}
 		// Insert the value at the specified path in the output structure
-		insertNested(result, strings.Split(row.OSCEM, "."), value)
+		if err := c.insertValue(result, "", row.oscemPath(), value, row); err != nil {
+			return err
+		}
+		c.recordProvenance(row.OSCEM, row, source, crunchFactor)
+	}
+	return nil
+}
+
+// Processes a ";"-separated list of sources mapped to a field outside of arrays, writing
+// the entries the list policy picks; see resolveList.
+//
+// Parameters:
+//   - result: The output map being built
+//   - row: CSV mapping rule for this field
+//   - rawValues: Values found in the input data, one per source key
+//   - crunchFactor: Unit conversion factor to apply
+//   - source: The ";"-separated source fields the values were taken from
+//
+// Returns:
+//   - error: If the entries disagree under ListPolicyError, or the value collides with the output of another row
+func (c *converter) handleListField(result map[string]interface{}, row csvextract, rawValues []string, crunchFactor string, source string) error {
+	entries, err := c.resolveList(row, rawValues, crunchFactor, source)
+	if err != nil || len(entries) == 0 {
+		return err
+	}
+	var values []interface{}
+	var keys, crunches []string
+	for _, entry := range entries {
+		crunch := elementCrunch(crunchFactor, entry.index)
+		if value := c.processValue(entry.value, crunch, row); value != nil {
+			values = append(values, value)
+			keys = append(keys, entry.key)
+			crunches = append(crunches, crunch)
+		}
+	}
+	if len(values) == 0 {
+		return nil // dropped by the extract pattern
+	}
+	var value interface{} = values
+	if len(values) == 1 {
+		value = values[0]
+	}
+	if err := c.insertValue(result, "", row.oscemPath(), value, row); err != nil {
+		return err
 	}
+	c.recordProvenance(row.OSCEM, row, strings.Join(keys, ";"), strings.Join(crunches, ";"))
+	return nil
 }
 
 // Processes fields that contain the [N] notation, creating arrays in the output structure.
@@ -189,38 +361,257 @@ func handleRegularField(result map[string]interface{}, row csvextract, rawValues
 //   - row: CSV mapping rule for this array field
 //   - rawValues: Values found in the input data
 //   - crunchFactor: Unit conversion factor to apply
-func handleArrayField(result map[string]interface{}, row csvextract, rawValues []string, crunchFactor string) {
+//   - source: The ";"-separated source fields the values were taken from
+//
+// Returns:
+//   - error: If a value collides with the output of another row
+func (c *converter) handleArrayField(result map[string]interface{}, row csvextract, rawValues []string, crunchFactor string, source string) error {
 	// Parse the array path (e.g., "acquisition.detectors[N].mode" -> ["acquisition"], "detectors", "mode")
 	arrayPath, arrayName, propertyName := parseArrayPath(row.OSCEM)
 
 	// Navigate to the parent container of the array
-	parent := result
-	for _, segment := range arrayPath {
-		if _, exists := parent[segment]; !exists {
-			parent[segment] = make(map[string]interface{})
-		}
-		parent = parent[segment].(map[string]interface{})
-	}
-	// Ensure the array exists
-	if _, exists := parent[arrayName]; !exists {
-		parent[arrayName] = make([]interface{}, 0)
+	parent, collision := arrayParent(result, arrayPath, arrayName, false)
+	if collision != nil {
+		return c.collisionError("", collision, row)
 	}
 	// Add values to array elements
 	arr := parent[arrayName].([]interface{})
+	if c.keepEmptySlots {
+		// Reserve an element per list entry, so empty entries end up as null rather than shifting later values
+		for len(arr) < len(rawValues) {
+			arr = append(arr, make(map[string]interface{}))
+		}
+	}
 	for i, rawValue := range rawValues {
 		if rawValue == "" {
 			continue // Skip empty values
 		}
 		// Process the value (apply unit conversion and type casting)
-		value := processValue(rawValue, crunchFactor, row)
+		crunch := elementCrunch(crunchFactor, i)
+		value := c.processValue(rawValue, crunch, row)
+		if value == nil {
+			continue // dropped by the extract pattern
+		}
 		// Ensure array has enough elements
 		for len(arr) < i+1 {
 			arr = append(arr, make(map[string]interface{}))
 		}
+		base := fmt.Sprintf("%s[%d]", joinOutputPath(strings.Join(arrayPath, "."), arrayName), i)
+		element, ok := arr[i].(map[string]interface{})
+		if !ok {
+			elementPath := append(append([]string{}, arrayPath...), fmt.Sprintf("%s[%d]", arrayName, i))
+			return c.collisionError("", &pathCollisionError{Path: elementPath, Want: "an object", Have: kindOf(arr[i])}, row)
+		}
 		// Insert the value into the correct array element at the specified property path
-		insertNested(arr[i].(map[string]interface{}), strings.Split(propertyName, "."), value)
+		if err := c.insertValue(element, base, strings.Split(propertyName, "."), value, row); err != nil {
+			return err
+		}
+		sources := strings.Split(source, ";")
+		if i < len(sources) {
+			c.recordProvenance(joinOutputPath(base, propertyName), row, strings.TrimSpace(sources[i]), crunch)
+		}
 	}
 	parent[arrayName] = arr
+	return nil
+}
+
+// Matches integers written with "," thousands separators, e.g. 4,096 or -1,000,000.5.
+var thousandsPattern = regexp.MustCompile(`^[+-]?\d{1,3}(,\d{3})+(\.\d*)?$`)
+
+// Parses an integer as instruments write them: plain, with thousands separators ("4,096"),
+// in hex ("0x1000"), or as a float ("4096.0"), which is truncated towards zero.
+// The error reports values that are no number at all, or whose fraction was dropped.
+func parseTolerantInt(value string) (int64, error) {
+	text := strings.TrimSpace(value)
+	if thousandsPattern.MatchString(text) {
+		text = strings.ReplaceAll(text, ",", "")
+	}
+	if val, err := strconv.ParseInt(text, 10, 64); err == nil {
+		return val, nil
+	}
+	unsigned := strings.TrimLeft(text, "+-")
+	if len(unsigned) > 2 && (unsigned[:2] == "0x" || unsigned[:2] == "0X") {
+		val, err := strconv.ParseInt(unsigned[2:], 16, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%q is not a hex integer", value)
+		}
+		if strings.HasPrefix(text, "-") {
+			val = -val
+		}
+		return val, nil
+	}
+	f, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not an integer, using 0", value)
+	}
+	if f >= math.MaxInt64 || f <= math.MinInt64 || math.IsNaN(f) {
+		return 0, fmt.Errorf("%q is out of the integer range, using 0", value)
+	}
+	truncated := math.Trunc(f)
+	if truncated != f {
+		return int64(truncated), fmt.Errorf("%q truncated to the integer %d", value, int64(truncated))
+	}
+	return int64(truncated), nil
+}
+
+// Parses a float, accepting thousands separators ("4,096.5") and, like the scan it replaces,
+// trailing text after the number such as "1.5 mm". The error reports values that hold no number.
+func parseTolerantFloat(value string) (float64, error) {
+	text := strings.TrimSpace(value)
+	if thousandsPattern.MatchString(text) {
+		text = strings.ReplaceAll(text, ",", "")
+	}
+	if val, err := strconv.ParseFloat(text, 64); err == nil {
+		return val, nil
+	}
+	var val float64
+	if _, err := fmt.Sscanf(text, "%g", &val); err != nil {
+		return 0, fmt.Errorf("%q is not a number, using 0", value)
+	}
+	return val, nil
+}
+
+// Parses an on/off flag as instruments write them, e.g. an inserted energy filter slit as
+// "true", "1", "yes" or "on". The error reports values that are neither, which count as false.
+func parseFlag(value string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "true", "1", "yes", "on":
+		return true, nil
+	case "false", "0", "no", "off":
+		return false, nil
+	}
+	return false, fmt.Errorf("%q is not a boolean, using false", value)
+}
+
+// Inserts a processed value below obj, reporting overwrites and naming both rows involved
+// when the value collides with the structure written by an earlier row.
+//
+// Parameters:
+//   - obj: The map to insert into, the output document or one of its array elements
+//   - base: The concrete output path of obj, empty for the document itself
+//   - path: Path segments of the value below obj
+//   - value: The processed value
+//   - row: CSV mapping rule producing the value
+//
+// Returns:
+//   - error: If the path collides with a value or object written by another row
+func (c *converter) insertValue(obj map[string]interface{}, base string, path []string, value interface{}, row csvextract) error {
+	old := lookupPath(obj, path)
+	err := insertNested(obj, path, value)
+	var collision *pathCollisionError
+	if errors.As(err, &collision) {
+		return c.collisionError(base, collision, row)
+	}
+	if err != nil {
+		return err
+	}
+	c.checkOverwrite(joinOutputPath(base, strings.Join(path, ".")), old, value, row)
+	if c.rowsWritten != nil {
+		c.rowsWritten[rowKey(row)] = true
+	}
+	return nil
+}
+
+// Turns a collision at a path below base into an error naming the row that caused it
+// and the row that wrote the conflicting part of the document.
+func (c *converter) collisionError(base string, collision *pathCollisionError, row csvextract) error {
+	at := joinOutputPath(base, strings.Join(collision.Path, "."))
+	other, found := c.writtenBy[at]
+	if !found {
+		// any row that wrote below the path will do, pick the first for a stable message
+		var below []string
+		for written := range c.writtenBy {
+			if strings.HasPrefix(written, at+".") || strings.HasPrefix(written, at+"[") {
+				below = append(below, written)
+			}
+		}
+		sort.Strings(below)
+		if len(below) > 0 {
+			other, found = c.writtenBy[below[0]], true
+		}
+	}
+	if !found {
+		return fmt.Errorf("mapping row %s (%s) collides with the document: %w", describeRow(row), row.OSCEM, collision)
+	}
+	return fmt.Errorf("mapping rows %s (%s) and %s (%s) collide: %w",
+		describeRow(other), other.OSCEM, describeRow(row), row.OSCEM, collision)
+}
+
+// Joins a concrete output path and a relative path below it.
+func joinOutputPath(base, rel string) string {
+	if base == "" {
+		return rel
+	}
+	return base + "." + rel
+}
+
+// Records that a row writes to an output path and warns when this replaces a
+// different value set earlier by another row.
+//
+// Parameters:
+//   - path: The concrete output path, with array indices filled in
+//   - old: The value currently stored at the path, nil if there is none
+//   - value: The value about to be written
+//   - row: CSV mapping rule writing the value
+func (c *converter) checkOverwrite(path string, old interface{}, value interface{}, row csvextract) {
+	previous, written := c.writtenBy[path]
+	c.writtenBy[path] = row
+	if !written || old == nil || reflect.DeepEqual(old, value) {
+		return
+	}
+	c.warn(Warning{
+		Code: WarnOverwrite,
+		Path: path,
+		Row:  row.Line,
+		Message: fmt.Sprintf("%s overwrites %s, set by %s, with %s",
+			describeRow(row), formatValue(old), describeRow(previous), formatValue(value)),
+	})
+}
+
+// Formats an output value for messages, in the JSON form it would be written as.
+func formatValue(value interface{}) string {
+	if encoded, err := json.Marshal(value); err == nil {
+		return string(encoded)
+	}
+	return fmt.Sprint(value)
+}
+
+// Navigates to the map holding an array, creating missing objects and an empty array on the way.
+//
+// Parameters:
+//   - result: The output map being built
+//   - arrayPath: Parent path segments leading to the array
+//   - arrayName: Name of the array field
+//   - keyed: Whether the array is emitted as an object keyed by identifier rather than a list
+//
+// Returns:
+//   - map[string]interface{}: The map holding the array under arrayName
+//   - *pathCollisionError: If a segment holds something other than an object, or the array something else than expected
+func arrayParent(result map[string]interface{}, arrayPath []string, arrayName string, keyed bool) (map[string]interface{}, *pathCollisionError) {
+	parent := result
+	for i, segment := range arrayPath {
+		if _, exists := parent[segment]; !exists {
+			parent[segment] = make(map[string]interface{})
+		}
+		next, ok := parent[segment].(map[string]interface{})
+		if !ok {
+			return nil, &pathCollisionError{Path: arrayPath[:i+1], Want: "an object", Have: kindOf(parent[segment])}
+		}
+		parent = next
+	}
+	// Ensure the array exists
+	var empty interface{} = make([]interface{}, 0)
+	if keyed {
+		empty = make(map[string]interface{})
+	}
+	if _, exists := parent[arrayName]; !exists {
+		parent[arrayName] = empty
+	}
+	if kindOf(parent[arrayName]) != kindOf(empty) {
+		path := append(append([]string{}, arrayPath...), arrayName)
+		return nil, &pathCollisionError{Path: path, Want: kindOf(empty), Have: kindOf(parent[arrayName])}
+	}
+	return parent, nil
 }
 
 // Parses an OSCEM path containing the [N] notation into its components.
@@ -239,9 +630,8 @@ func handleArrayField(result map[string]interface{}, row csvextract, rawValues [
 //   - string: Property name within each array element
 func parseArrayPath(oscem string) ([]string, string, string) {
 	// e.g., "acquisition.detectors[N].mode"
-	parts := strings.Split(oscem, "[N]")
-	beforeArray := parts[0] // "acquisition.detectors"
-	afterArray := parts[1]  // ".mode"
+	// a path without [N] names the array itself, with no property
+	beforeArray, afterArray, _ := strings.Cut(oscem, "[N]") // "acquisition.detectors", ".mode"
 	// Split the before part to get parent path and array name
 	beforeParts := strings.Split(beforeArray, ".")
 	arrayParentPath := beforeParts[:len(beforeParts)-1] // ["acquisition"]
@@ -251,86 +641,235 @@ func parseArrayPath(oscem string) ([]string, string, string) {
 	return arrayParentPath, arrayName, propertyName
 }
 
-// Applies unit conversion and type casting to a raw string value.
-func processValue(rawValue, crunchFactor string, row csvextract) interface{} {
-	// Apply unit conversion if a conversion factor is specified
-	processedValue := applyUnitCrunch(crunchFactor, rawValue, row)
-	// Cast to the appropriate data type based on the CSV mapping
-	return castToBaseType(processedValue, row.Type, row.Units)
+// Applies unit conversion, value hooks and type casting to a raw string value.
+func (c *converter) processValue(rawValue, crunchFactor string, row csvextract) interface{} {
+	// Apply unit conversion if a conversion factor is specified; doses per pixel or
+	// per second also need the pixel size or exposure time of the same input
+	var processedValue string
+	var err error
+	c.steps = nil
+	if row.Extract != nil {
+		extracted, ok := extractValue(row, rawValue)
+		if !ok {
+			c.warn(Warning{
+				Code:    WarnNoMatch,
+				Path:    row.OSCEM,
+				Row:     row.Line,
+				Message: fmt.Sprintf("%q does not match the extract pattern %s, dropping it", rawValue, row.Extract),
+			})
+			c.traceStep("extraction by %s: %q does not match", row.Extract, rawValue)
+			return nil
+		}
+		c.traceStep("extraction by %s: %q -> %q", row.Extract, rawValue, extracted)
+		rawValue = extracted
+	}
+	if unit, ok := doseUnits[normalizeUnit(crunchFactor)]; ok {
+		processedValue, err = c.convertDose(rawValue, unit, row)
+		c.traceStep("dose conversion from %s: %q -> %q", crunchFactor, rawValue, processedValue)
+	} else {
+		processedValue, err = applyUnitCrunch(crunchFactor, rawValue, row.Units)
+		if crunchFactor != "" {
+			c.traceStep("unit conversion by %s: %q -> %q", crunchFactor, rawValue, processedValue)
+		}
+	}
+	if err != nil {
+		c.warn(Warning{
+			Code:    WarnUnitConversion,
+			Path:    row.OSCEM,
+			Row:     row.Line,
+			Message: fmt.Sprintf("skipped unit conversion by %s, keeping %q: %v", crunchFactor, rawValue, err),
+		})
+	} else if crunchFactor != "" {
+		c.stats.UnitConversions++
+	}
+	// Let site-specific value hooks adjust the value before it is typed
+	for i, hook := range c.hooks.Value {
+		hooked := hook(row.OSCEM, processedValue)
+		if hooked != processedValue {
+			c.traceStep("value hook %d: %q -> %q", i+1, processedValue, hooked)
+		}
+		processedValue = hooked
+	}
+	// Cast to the appropriate data type based on the CSV mapping; a type name the
+	// converter does not know, typically a typo, keeps the value as a string
+	valueType := row.Type
+	if !isKnownType(valueType) {
+		c.warn(Warning{
+			Code:    WarnUnknownType,
+			Path:    row.OSCEM,
+			Row:     row.Line,
+			Message: fmt.Sprintf("unknown type %q, keeping %q as a string", row.Type, processedValue),
+		})
+		valueType = "string"
+	}
+	value, err := castToBaseTypeChecked(processedValue, valueType, row.Units)
+	if err != nil {
+		c.warn(Warning{Code: WarnLossyCast, Path: row.OSCEM, Row: row.Line, Message: err.Error()})
+	}
+	if c.tracing {
+		typed, _ := json.Marshal(value)
+		c.traceStep("cast to %s: %q -> %s", strings.ToLower(valueType), processedValue, typed)
+	}
+	return value
+}
+
+// Records a transformation of the value being processed, when tracing.
+func (c *converter) traceStep(format string, args ...interface{}) {
+	if c.tracing {
+		c.steps = append(c.steps, fmt.Sprintf(format, args...))
+	}
+}
+
+// Returns the crunch factor of the i-th entry of a ";"-separated source list. A crunch cell
+// may list one factor per entry, e.g. "1e-6;1e-9" for sources in µm and nm, with empty
+// entries leaving their value unconverted; a single factor applies to every entry.
+func elementCrunch(crunchFactor string, i int) string {
+	if !strings.Contains(crunchFactor, ";") {
+		return crunchFactor
+	}
+	factors := strings.Split(crunchFactor, ";")
+	if i < 0 || i >= len(factors) {
+		return ""
+	}
+	return strings.TrimSpace(factors[i])
 }
 
 // Applies unit conversion to a raw value if a conversion factor is specified.
-func applyUnitCrunch(crunchFactor string, rawValue string, row csvextract) string {
+// The factor may also name the unit of the raw value, which is then converted to unit.
+// On failure the raw value is returned along with the error.
+func applyUnitCrunch(crunchFactor string, rawValue string, unit string) (string, error) {
 	// Apply unit conversion if crunch factor is defined
 	if crunchFactor != "" {
-		converted, err := unitCrunch(rawValue, crunchFactor)
-		if err == nil {
-			rawValue = converted
-		} else {
-			fmt.Fprintln(os.Stderr, "Unit crunching failed for", row.OSCEM, ":", err)
+		converted, err := unitCrunch(rawValue, crunchFactor, unit)
+		if err != nil {
+			return rawValue, err
 		}
+		rawValue = converted
 	}
-	return rawValue
+	return rawValue, nil
 }
 
-// Applies a multiplication factor to a numeric string value for unit conversion.
-func unitCrunch(value string, factor string) (string, error) {
-	check, err := strconv.ParseFloat(value, 64)
-	fac, _ := strconv.ParseFloat(factor, 64)
+// Applies a multiplication factor, or the conversion from a named unit, to a numeric string value.
+func unitCrunch(value string, factor string, unit string) (string, error) {
+	// values are read like the Float64 cast does, e.g. "4,096" with thousands separators
+	check, err := parseTolerantFloat(value)
 	if err != nil {
 		return value, err
 	}
-	val := check * fac
-	back := strconv.FormatFloat(val, 'f', 16, 64)
+	val, err := crunchValue(check, factor, unit)
+	if err != nil {
+		return value, err
+	}
+	// the shortest digits reading back as val, in exponent notation only for magnitudes
+	// below 1e-4 or from 1e15 on
+	if abs := math.Abs(val); abs == 0 || (abs >= 1e-4 && abs < 1e15) {
+		return strconv.FormatFloat(val, 'f', -1, 64), nil
+	}
+	return strconv.FormatFloat(val, 'g', -1, 64), nil
+}
 
-	return back, nil
+// Reports whether castToBaseType supports the given type name.
+func isKnownType(t string) bool {
+	switch strings.ToLower(t) {
+	case "int", "float", "float64", "bool", "string":
+		return true
+	}
+	return false
 }
 
 // Converts a string value to the appropriate data type based on the type specification.
 func castToBaseType(value string, t string, unit string) interface{} {
+	out, _ := castToBaseTypeChecked(value, t, unit)
+	return out
+}
+
+// Converts a string value like castToBaseType, and reports casts that lost information,
+// e.g. an int field holding "4096.5".
+func castToBaseTypeChecked(value string, t string, unit string) (interface{}, error) {
 	switch strings.ToLower(t) {
 	case "int":
-		var val int64
-		fmt.Sscanf(value, "%d", &val)
+		val, err := parseTolerantInt(value)
 		var out basetypes.Int
 		out.Set(val, unit) // sets .HasSet = true
-		return out
+		return out, err
 
 	case "float", "float64":
-		var val float64
-		fmt.Sscanf(value, "%f", &val)
+		val, err := parseTolerantFloat(value)
 		var out basetypes.Float64
+		if math.IsNaN(val) || math.IsInf(val, 0) {
+			// JSON has no representation for these, so the field is left unset
+			return out, fmt.Errorf("%q is not a finite number, dropping it", value)
+		}
 		out.Set(val, unit) // sets .HasSet = true
-		return out
+		return out, err
 
 	case "bool":
+		val, err := parseFlag(value)
 		var out basetypes.Bool
-		out.Set(strings.ToLower(value) == "true") // sets .HasSet = true
-		return out
+		out.Set(val) // sets .HasSet = true
+		return out, err
 
 	case "string":
 		var out basetypes.String
 		out.Set(value) // sets .HasSet = true
-		return out
+		return out, nil
 
 	default:
-		return nil
+		return nil, nil
+	}
+}
+
+// Describes a value that cannot be inserted because the path and the existing document disagree
+// about what a segment holds, e.g. a value where an object is needed to descend further.
+type pathCollisionError struct {
+	Path []string // the colliding prefix of the insertion path
+	Want string   // what the insertion needs at Path
+	Have string   // what the document holds at Path
+}
+
+func (e *pathCollisionError) Error() string {
+	return fmt.Sprintf("%s already holds %s where %s is needed", strings.Join(e.Path, "."), e.Have, e.Want)
+}
+
+// Names the kind of a decoded output value for collision messages.
+func kindOf(value interface{}) string {
+	switch value.(type) {
+	case map[string]interface{}:
+		return "an object"
+	case []interface{}:
+		return "an array"
+	default:
+		return "a value"
 	}
 }
 
 // Inserts a value into a nested map structure at the specified path.
-func insertNested(obj map[string]interface{}, path []string, val interface{}) {
+// It fails with a *pathCollisionError rather than replacing an object or array with a value, or descending into a value.
+func insertNested(obj map[string]interface{}, path []string, val interface{}) error {
 	curr := obj
 	for i, key := range path {
 		if i == len(path)-1 {
-			// Last key in path - set the value
+			// Last key in path - set the value, unless it would drop nested fields
+			if existing, exists := curr[key]; exists {
+				switch existing.(type) {
+				case map[string]interface{}, []interface{}:
+					if kindOf(existing) != kindOf(val) {
+						return &pathCollisionError{Path: path, Want: kindOf(val), Have: kindOf(existing)}
+					}
+				}
+			}
 			curr[key] = val
 		} else {
 			// Intermediate key - ensure nested map exists
 			if _, ok := curr[key]; !ok {
 				curr[key] = make(map[string]interface{})
 			}
-			curr = curr[key].(map[string]interface{})
+			next, ok := curr[key].(map[string]interface{})
+			if !ok {
+				return &pathCollisionError{Path: path[:i+1], Want: "an object", Have: kindOf(curr[key])}
+			}
+			curr = next
 		}
 	}
+	return nil
 }
@@ -0,0 +1,100 @@
+package conversion
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// A mapping file parsed once, with what identifies the version it was parsed from.
+type cachedMapping struct {
+	size    int64
+	modTime time.Time
+	sum     [sha256.Size]byte
+	mapping *Mapping
+}
+
+// Mapping files parsed by conversions naming them in Options.MappingFile, by path and
+// strictness, so batch runs and JSON-RPC daemons converting thousands of inputs with the same
+// mapping parse and validate it once; the embedded mappings are kept alike. The rows are
+// shared read-only, like those of a preloaded Mapping.
+var (
+	mappingCacheMu sync.Mutex
+	mappingCache   = make(map[string]*cachedMapping)
+
+	// the embedded and site mappings, by name
+	embeddedMappingCache = make(map[string]*Mapping)
+)
+
+// Returns a mapping file, parsed on first use and again only once the file
+// changes. A file whose size and modification time are unchanged is not read at all; one
+// that was touched or rewritten is read and only parsed again if its checksum differs.
+// Failed loads are not cached, so a fixed file is picked up by the next conversion.
+//
+// Parameters:
+//   - path: The mapping file
+//   - strict: Whether malformed rows are an error rather than skipped, see Options.Strict
+//
+// Returns:
+//   - *Mapping: The mapping, shared with other conversions
+//   - error: If the file cannot be read or is not a valid mapping, see validateMapping
+func cachedMappingCSV(path string, strict bool) (*Mapping, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open mapping file: %w", err)
+	}
+	key := fmt.Sprintf("%t\x00%s", strict, path)
+	mappingCacheMu.Lock()
+	entry := mappingCache[key]
+	mappingCacheMu.Unlock()
+	if entry != nil && entry.size == info.Size() && entry.modTime.Equal(info.ModTime()) {
+		return entry.mapping, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open mapping file: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	if entry == nil || entry.sum != sum {
+		rows, warnings, err := parseMappingCSV(bytes.NewReader(data), strict)
+		if err != nil {
+			return nil, err
+		}
+		// validated like LoadMapping, so an invalid file is never cached
+		if err := validateMapping(rows, strict); err != nil {
+			return nil, fmt.Errorf("invalid mapping %s: %w", path, err)
+		}
+		entry = &cachedMapping{sum: sum, mapping: &Mapping{Source: path, rows: rows, warnings: warnings}}
+	} else {
+		entry = &cachedMapping{sum: sum, mapping: entry.mapping}
+	}
+	entry.size, entry.modTime = info.Size(), info.ModTime()
+	mappingCacheMu.Lock()
+	mappingCache[key] = entry
+	mappingCacheMu.Unlock()
+	return entry.mapping, nil
+}
+
+// Returns a mapping compiled into the binary, such as the default table of a schema
+// version, parsed on first use. These never change while the process runs.
+func cachedEmbeddedMapping(name string, load func() ([]csvextract, error)) (*Mapping, error) {
+	mappingCacheMu.Lock()
+	m, ok := embeddedMappingCache[name]
+	mappingCacheMu.Unlock()
+	if ok {
+		return m, nil
+	}
+	rows, err := load()
+	if err != nil {
+		return nil, err
+	}
+	m = &Mapping{rows: rows}
+	mappingCacheMu.Lock()
+	embeddedMappingCache[name] = m
+	mappingCacheMu.Unlock()
+	return m, nil
+}
@@ -0,0 +1,32 @@
+package conversion
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Mapping files are validated like LoadMapping before they are cached, and a fixed file is
+// picked up by the next load.
+func TestCachedMappingValidates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mapping.csv")
+	invalid := "oscem,fromformat,optionals,units,crunch,type\nacquisition.detectors[N],Detectors.Detector-[N].DetectorName,,,,String\n"
+	if err := os.WriteFile(path, []byte(invalid), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cachedMappingCSV(path, false); err == nil {
+		t.Fatal("an array target without a property was accepted")
+	}
+	valid := "oscem,fromformat,optionals,units,crunch,type\nacquisition.detectors[N].name,Detectors.Detector-[N].DetectorName,,,,String\n"
+	if err := os.WriteFile(path, []byte(valid), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Second)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cachedMappingCSV(path, false); err != nil {
+		t.Fatalf("the fixed mapping was rejected: %v", err)
+	}
+}
@@ -0,0 +1,427 @@
+package conversion
+
+import (
+	"embed"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// A single mapping rule, i.e. one row of a mapping table.
+// Reduced layout tables fill the MDOC fields only.
+type csvextract struct {
+	Layout         mappingLayout // layout of the table the row was read from
+	Line           int           // line of the row in its mapping file, 0 if unknown
+	OSCEM          string
+	FromXML        string
+	FromMDOC       string
+	OptionalsMDOC  string
+	Units          string
+	CrunchFromXML  string
+	CrunchFromMDOC string
+	OptionalsXML   string
+	Type           string
+	Fallbacks      []sourceFallback // tried in order when none of the columns above yields a value
+	ArrayKeyed     bool             // emit the [N] array as an object keyed by the captured identifier
+	Profiles       []string         // modalities the row is restricted to, see Modalities; all when empty
+	Presence       bool             // write whether a source key exists instead of its value, see handlePresenceField
+	Reference      bool             // check that the source values name an array element instead of writing them, see checkReferences
+	Extract        *regexp.Regexp   // picks the part of the source value to map, see extractValue; nil maps all of it
+	Path           []string         // OSCEM split at ".", once when the mapping is loaded; see oscemPath
+}
+
+// Returns the OSCEM path of a row split at ".", without splitting it again for rows of a
+// loaded mapping. The segments are shared by all conversions and must not be modified.
+func (row csvextract) oscemPath() []string {
+	if row.Path != nil {
+		return row.Path
+	}
+	return strings.Split(row.OSCEM, ".")
+}
+
+// An alternative source key with its own unit conversion factor.
+type sourceFallback struct {
+	Field  string
+	Crunch string
+}
+
+// Parses the optional fallbacks column: alternatives are separated by "|", each
+// optionally followed by "=" and its crunch factor, e.g. "Defocus|DefocusNm=1".
+func parseFallbacks(cell string) []sourceFallback {
+	var fallbacks []sourceFallback
+	for _, entry := range strings.Split(cell, "|") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		field, crunch, _ := strings.Cut(entry, "=")
+		fallbacks = append(fallbacks, sourceFallback{
+			Field:  strings.TrimSpace(field),
+			Crunch: strings.TrimSpace(crunch),
+		})
+	}
+	return fallbacks
+}
+
+// Distinguishes the two mapping table layouts.
+type mappingLayout int
+
+const (
+	// Legacy layout of the embedded life science table, with separate XML and MDOC
+	// source, optionals and crunch columns.
+	layoutFull mappingLayout = iota
+	// Reduced fromformat/optionals/crunch layout, used by most custom mappings.
+	layoutReduced
+)
+
+func (l mappingLayout) String() string {
+	if l == layoutReduced {
+		return "reduced"
+	}
+	return "full"
+}
+
+// Associates a header name with the row field it fills.
+type mappingColumn struct {
+	name  string
+	field func(*csvextract) *string
+}
+
+// The required columns of each layout. The optional fallbacks column is shared.
+var layoutColumns = map[mappingLayout][]mappingColumn{
+	layoutFull: {
+		{"oscem", func(r *csvextract) *string { return &r.OSCEM }},
+		{"fromxml", func(r *csvextract) *string { return &r.FromXML }},
+		{"frommdoc", func(r *csvextract) *string { return &r.FromMDOC }},
+		{"optionals_mdoc", func(r *csvextract) *string { return &r.OptionalsMDOC }},
+		{"units", func(r *csvextract) *string { return &r.Units }},
+		{"crunchfromxml", func(r *csvextract) *string { return &r.CrunchFromXML }},
+		{"crunchfrommdoc", func(r *csvextract) *string { return &r.CrunchFromMDOC }},
+		{"optionals_xml", func(r *csvextract) *string { return &r.OptionalsXML }},
+		{"type", func(r *csvextract) *string { return &r.Type }},
+	},
+	layoutReduced: {
+		{"oscem", func(r *csvextract) *string { return &r.OSCEM }},
+		{"fromformat", func(r *csvextract) *string { return &r.FromMDOC }},
+		{"optionals", func(r *csvextract) *string { return &r.OptionalsMDOC }},
+		{"units", func(r *csvextract) *string { return &r.Units }},
+		{"crunch", func(r *csvextract) *string { return &r.CrunchFromMDOC }},
+		{"type", func(r *csvextract) *string { return &r.Type }},
+	},
+}
+
+func loadMappingCSV(mappingPath string, strict bool) ([]csvextract, []Warning, error) {
+	// Use alternative file on disk
+	file, err := os.Open(mappingPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open mapping file: %w", err)
+	}
+	defer file.Close()
+	return parseMappingCSV(file, strict)
+}
+
+// Parses a custom mapping in either layout, detected from its header.
+//
+// Parameters:
+//   - r: The CSV content
+//   - strict: Whether malformed rows and rows sharing a target are an error
+//
+// Returns:
+//   - []csvextract: The rows of the mapping
+//   - []Warning: Problems that did not stop the parse, reported by conversions using it
+//   - error: If the mapping cannot be read, or has problems in strict mode
+func parseMappingCSV(r io.Reader, strict bool) ([]csvextract, []Warning, error) {
+	header, records, warnings, err := readCSVRecords(r, strict)
+	if err != nil {
+		return nil, nil, err
+	}
+	layout, err := detectLayout(header)
+	if err != nil {
+		return nil, nil, err
+	}
+	rows, err := buildMappingRows(header, records, layout)
+	if err != nil {
+		return nil, nil, err
+	}
+	if strict {
+		if err := checkDuplicateTargets(rows); err != nil {
+			return nil, nil, err
+		}
+	}
+	return rows, append(warnings, duplicateTargets(rows)...), nil
+}
+
+// Read and parse an embedded mapping table, in either layout
+func readCSVFile(content embed.FS, name string) ([]csvextract, error) {
+	file, err := content.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("could not open %s: %w", name, err)
+	}
+	defer file.Close()
+
+	// the embedded tables ship with the binary, so any malformed row is a bug
+	header, records, _, err := readCSVRecords(file, true)
+	if err == nil {
+		var rows []csvextract
+		var layout mappingLayout
+		if layout, err = detectLayout(header); err == nil {
+			rows, err = buildMappingRows(header, records, layout)
+		}
+		if err == nil {
+			if err = checkDuplicateTargets(rows); err == nil {
+				return rows, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("could not read %s: %w", name, err)
+}
+
+// Determines the layout of a mapping table from its normalized header: a fromformat
+// column marks the reduced layout, fromxml or frommdoc columns the full one.
+func detectLayout(header []string) (mappingLayout, error) {
+	colIdx := columnIndex(header)
+	_, hasXML := colIdx["fromxml"]
+	_, hasMDOC := colIdx["frommdoc"]
+	_, hasFormat := colIdx["fromformat"]
+	switch {
+	case hasFormat && !hasXML && !hasMDOC:
+		return layoutReduced, nil
+	case (hasXML || hasMDOC) && !hasFormat:
+		return layoutFull, nil
+	case hasFormat:
+		return 0, fmt.Errorf("mapping header mixes the reduced (fromformat) and full (fromxml/frommdoc) layouts")
+	default:
+		return 0, fmt.Errorf("unrecognized mapping header: expected a fromformat column (reduced layout) or fromxml/frommdoc columns (full layout)")
+	}
+}
+
+// Turns the data rows of a mapping table of the given layout into mapping rows.
+//
+// Parameters:
+//   - header: Normalized header names, as returned by readCSVRecords
+//   - records: The data rows
+//   - layout: Which set of columns the table must provide
+//
+// Returns:
+//   - []csvextract: One row per data row
+//   - error: If a required column of the layout is missing
+func buildMappingRows(header []string, records []csvRecord, layout mappingLayout) ([]csvextract, error) {
+	colIdx := columnIndex(header)
+
+	columns := layoutColumns[layout]
+	for _, col := range columns {
+		if _, ok := colIdx[col.name]; !ok {
+			return nil, fmt.Errorf("missing required column for the %s layout: %s", layout, col.name)
+		}
+	}
+	fallbackIdx, hasFallbacks := colIdx["fallbacks"]
+	arrayIdx, hasArray := colIdx["array"]
+	profileIdx, hasProfiles := colIdx["profile"]
+	modeIdx, hasMode := colIdx["mode"]
+	extractIdx, hasExtract := colIdx["extract"]
+
+	rows := make([]csvextract, 0, len(records))
+	for _, record := range records {
+		row := csvextract{Layout: layout, Line: record.Line}
+		for _, col := range columns {
+			*col.field(&row) = record.Cells[colIdx[col.name]]
+		}
+		if hasFallbacks {
+			row.Fallbacks = parseFallbacks(record.Cells[fallbackIdx])
+		}
+		if hasArray {
+			switch mode := strings.ToLower(strings.TrimSpace(record.Cells[arrayIdx])); mode {
+			case "", "list":
+			case "keyed":
+				row.ArrayKeyed = true
+			default:
+				return nil, fmt.Errorf("line %d: unknown array mode %q, expected list or keyed", record.Line, mode)
+			}
+		}
+		if hasProfiles {
+			profiles, err := parseProfiles(record.Cells[profileIdx])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", record.Line, err)
+			}
+			row.Profiles = profiles
+		}
+		if hasMode {
+			if err := parseRowMode(&row, record.Cells[modeIdx]); err != nil {
+				return nil, fmt.Errorf("line %d: %w", record.Line, err)
+			}
+		}
+		if hasExtract {
+			extract, err := parseExtract(record.Cells[extractIdx])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", record.Line, err)
+			}
+			row.Extract = extract
+		}
+		row.Path = strings.Split(row.OSCEM, ".")
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// Reports rows that write to the same OSCEM path, where the later row would silently
+// overwrite the value of the earlier one, as an error listing them all.
+func checkDuplicateTargets(rows []csvextract) error {
+	var conflicts []string
+	for _, w := range duplicateTargets(rows) {
+		conflicts = append(conflicts, w.Message)
+	}
+	if len(conflicts) > 0 {
+		return fmt.Errorf("conflicting mapping rows: %s", strings.Join(conflicts, "; "))
+	}
+	return nil
+}
+
+// Returns a warning for each row writing to the same OSCEM path as an earlier row. Rows
+// restricted to different modalities may share a target, as they are never active together.
+func duplicateTargets(rows []csvextract) []Warning {
+	seen := make(map[string][]csvextract)
+	var warnings []Warning
+	for _, row := range rows {
+		if row.OSCEM == "" || row.Reference {
+			continue // reference rows write nothing
+		}
+		for _, first := range seen[row.OSCEM] {
+			if profilesOverlap(first, row) {
+				warnings = append(warnings, Warning{
+					Code:    WarnDuplicateRows,
+					Path:    row.OSCEM,
+					Row:     row.Line,
+					Message: fmt.Sprintf("%s is targeted by %s and %s", row.OSCEM, describeRow(first), describeRow(row)),
+				})
+				break
+			}
+		}
+		seen[row.OSCEM] = append(seen[row.OSCEM], row)
+	}
+	return warnings
+}
+
+// Names a mapping row for messages, by its line if known and otherwise by its target.
+func describeRow(row csvextract) string {
+	if row.Line > 0 {
+		return fmt.Sprintf("line %d", row.Line)
+	}
+	return fmt.Sprintf("the row for %s", row.OSCEM)
+}
+
+// A data row of a mapping CSV along with the line it was read from.
+type csvRecord struct {
+	Line  int
+	Cells []string
+}
+
+// Reads the header and data rows of a mapping CSV. The encoding and delimiter are
+// detected first (see decodeText and detectDelimiter). Comment lines and blank rows
+// are skipped, and header names are normalized to lower case without BOM or whitespace.
+// Ragged rows and malformed quoting abort the read in strict mode; otherwise short
+// rows are padded with empty cells, extra cells are dropped, unparsable rows are
+// skipped, and each of these is reported as a warning with its line and column.
+//
+// Parameters:
+//   - r: The CSV content
+//   - strict: Whether malformed rows are an error
+//
+// Returns:
+//   - []string: Normalized header names
+//   - []csvRecord: Data rows, each with exactly as many cells as the header
+//   - []Warning: The malformed rows outside strict mode
+//   - error: Read errors, or malformed rows in strict mode
+func readCSVRecords(r io.Reader, strict bool) ([]string, []csvRecord, []Warning, error) {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to read mapping: %w", err)
+	}
+	text, err := decodeText(raw)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to decode mapping: %w", err)
+	}
+	delimiter, err := detectDelimiter(text)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	reader := csv.NewReader(strings.NewReader(text))
+	reader.Comma = delimiter
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1 // row lengths are checked below, with better messages
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil, nil, fmt.Errorf("empty CSV file")
+	}
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to read header: %w", err)
+	}
+	for i, h := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(strings.TrimLeft(h, "\ufeff")))
+	}
+
+	var records []csvRecord
+	var warnings []Warning
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if !strict && errors.As(err, &parseErr) {
+				warnings = append(warnings, Warning{Code: WarnMalformedRow, Row: parseErr.StartLine, Message: fmt.Sprintf("skipping malformed mapping row: %v", err)})
+				continue
+			}
+			return nil, nil, nil, fmt.Errorf("failed to read mapping: %w", err)
+		}
+		if isBlankRow(row) {
+			continue
+		}
+		line, _ := reader.FieldPos(0)
+
+		switch {
+		case len(row) < len(header):
+			missing := header[len(row)]
+			if strict {
+				return nil, nil, nil, fmt.Errorf("mapping line %d has %d of %d columns, column %q is missing", line, len(row), len(header), missing)
+			}
+			warnings = append(warnings, Warning{Code: WarnMalformedRow, Row: line, Message: fmt.Sprintf("mapping line %d has %d of %d columns, treating %q and later columns as empty", line, len(row), len(header), missing)})
+			row = append(row, make([]string, len(header)-len(row))...)
+		case len(row) > len(header):
+			_, col := reader.FieldPos(len(header))
+			if strict {
+				return nil, nil, nil, fmt.Errorf("mapping line %d, column %d: %d cells but only %d columns in the header", line, col, len(row), len(header))
+			}
+			warnings = append(warnings, Warning{Code: WarnMalformedRow, Row: line, Message: fmt.Sprintf("mapping line %d, column %d: ignoring cells beyond the %d header columns", line, col, len(header))})
+			row = row[:len(header)]
+		}
+		records = append(records, csvRecord{Line: line, Cells: row})
+	}
+	return header, records, warnings, nil
+}
+
+// Maps normalized header names to their column index.
+func columnIndex(header []string) map[string]int {
+	idx := make(map[string]int, len(header))
+	for i, h := range header {
+		idx[h] = i
+	}
+	return idx
+}
+
+// Reports whether every cell of a CSV row is empty, like the ",,,," separator rows
+// curators use to structure a mapping.
+func isBlankRow(row []string) bool {
+	for _, cell := range row {
+		if strings.TrimSpace(cell) != "" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,52 @@
+package conversion
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Problems of a mapping file loaded outside strict mode are warnings of the conversions
+// using it, and errors in strict mode.
+func TestMappingWarnings(t *testing.T) {
+	csv := "oscem,fromformat,optionals,units,crunch,type,profile\n" +
+		"sample.name,Name,,,,String,\n" +
+		"sample.name,Title,,,,String,\n" +
+		"sample.grid,Grid,,,,String,spa\n" +
+		"sample.grid,GridType,,,,String,tomo\n" +
+		"sample.short,Short\n"
+	_, warnings, err := parseMappingCSV(strings.NewReader(csv), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	codes := make(map[string]int)
+	for _, w := range warnings {
+		codes[w.Code]++
+	}
+	if codes[WarnDuplicateRows] != 1 || codes[WarnMalformedRow] != 1 {
+		t.Errorf("warnings %v, want one duplicate_rows and one malformed_row", warnings)
+	}
+	if _, _, err := parseMappingCSV(strings.NewReader(csv), true); err == nil {
+		t.Error("strict parse accepted a malformed mapping")
+	}
+
+	path := filepath.Join(t.TempDir(), "mapping.csv")
+	if err := os.WriteFile(path, []byte(csv), 0644); err != nil {
+		t.Fatal(err)
+	}
+	res, err := ConvertDocument(context.Background(), []byte(`{"Name": "apoferritin"}`), Options{MappingFile: path, Modality: "spa"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var duplicates []Warning
+	for _, w := range res.Warnings {
+		if w.Code == WarnDuplicateRows {
+			duplicates = append(duplicates, w)
+		}
+	}
+	if len(duplicates) != 1 || duplicates[0].Path != "sample.name" || duplicates[0].Row != 3 {
+		t.Errorf("duplicate warnings %v, want one for sample.name at line 3", duplicates)
+	}
+}
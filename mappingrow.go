@@ -0,0 +1,131 @@
+package conversion
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A row of a mapping table, for building mappings in code, e.g. from a database, instead of
+// from CSV files; see NewMapping. The fields correspond to the columns of the reduced
+// layout, plus the XML columns of the full layout.
+//
+// For each row the converter takes the first source holding a value, in the order
+// Optional, Source, XMLOptional, XMLSource and then the Fallbacks.
+type MappingRow struct {
+	OSCEM       string            // target path, [N] marks the elements of an array
+	Source      string            // input key, a ";"-separated list of keys or an [N] pattern (fromformat, frommdoc)
+	Optional    string            // input key preferred over Source (optionals, optionals_mdoc)
+	Crunch      string            // unit conversion factor or source unit of Source and Optional (crunch, crunchfrommdoc)
+	XMLSource   string            // input key of the full layout's XML column (fromxml)
+	XMLOptional string            // input key preferred over XMLSource (optionals_xml)
+	XMLCrunch   string            // unit conversion factor of XMLSource and XMLOptional (crunchfromxml)
+	Units       string            // unit of the output value
+	Type        string            // Int, Float, Float64, Bool or String
+	Fallbacks   []MappingFallback // tried in order when none of the sources above yields a value
+	Keyed       bool              // emit the [N] array as an object keyed by the captured identifier
+	Profiles    []string          // modalities the row is restricted to, see Modalities; all when empty
+	Presence    bool              // write whether any source key exists, as a Bool, instead of its value
+	Reference   bool              // check that the source values name an element of the target's array, e.g. the detector in use, instead of writing them
+	Extract     string            // regular expression whose first capture group, or whole match, is mapped instead of the full source value
+	Line        int               // where the row was defined, naming it in messages; 0 if unknown
+}
+
+// An alternative source key of a mapping row, with its own unit conversion factor.
+type MappingFallback struct {
+	Key    string
+	Crunch string
+}
+
+// Builds a mapping from rows constructed in code and validates it like ParseMapping does:
+// the rows must target at least one OSCEM field, use known types and modalities, and not
+// write the same path unless their profiles keep them apart.
+//
+// Parameters:
+//   - rows: The rows of the mapping, in the order they are applied
+//
+// Returns:
+//   - *Mapping: The mapping, usable as Options.Mapping
+//   - error: If a row is invalid or rows conflict
+func NewMapping(rows []MappingRow) (*Mapping, error) {
+	converted := make([]csvextract, 0, len(rows))
+	for i, row := range rows {
+		r := csvextract{
+			Layout:         layoutReduced,
+			Line:           row.Line,
+			OSCEM:          strings.TrimSpace(row.OSCEM),
+			FromMDOC:       row.Source,
+			OptionalsMDOC:  row.Optional,
+			CrunchFromMDOC: row.Crunch,
+			FromXML:        row.XMLSource,
+			OptionalsXML:   row.XMLOptional,
+			CrunchFromXML:  row.XMLCrunch,
+			Units:          row.Units,
+			Type:           row.Type,
+			ArrayKeyed:     row.Keyed,
+			Presence:       row.Presence,
+			Reference:      row.Reference,
+		}
+		if row.XMLSource != "" || row.XMLOptional != "" || row.XMLCrunch != "" {
+			r.Layout = layoutFull
+		}
+		for _, fallback := range row.Fallbacks {
+			r.Fallbacks = append(r.Fallbacks, sourceFallback{Field: fallback.Key, Crunch: fallback.Crunch})
+		}
+		if len(row.Profiles) > 0 {
+			profiles, err := parseProfiles(strings.Join(row.Profiles, "|"))
+			if err != nil {
+				return nil, fmt.Errorf("row %d (%s): %w", i+1, row.OSCEM, err)
+			}
+			r.Profiles = profiles
+		}
+		if err := checkRowMode(&r); err != nil {
+			return nil, fmt.Errorf("row %d (%s): %w", i+1, row.OSCEM, err)
+		}
+		extract, err := parseExtract(row.Extract)
+		if err != nil {
+			return nil, fmt.Errorf("row %d (%s): %w", i+1, row.OSCEM, err)
+		}
+		r.Extract = extract
+		r.Path = strings.Split(r.OSCEM, ".")
+		converted = append(converted, r)
+	}
+	if err := checkDuplicateTargets(converted); err != nil {
+		return nil, err
+	}
+	if err := validateMapping(converted, true); err != nil {
+		return nil, fmt.Errorf("invalid mapping: %w", err)
+	}
+	return &Mapping{rows: converted}, nil
+}
+
+// Returns the rows of the mapping, e.g. to store a mapping loaded from CSV elsewhere or to
+// derive a new one for NewMapping. Changing them does not affect the mapping.
+func (m *Mapping) Rows() []MappingRow {
+	rows := make([]MappingRow, 0, len(m.rows))
+	for _, r := range m.rows {
+		row := MappingRow{
+			OSCEM:       r.OSCEM,
+			Source:      r.FromMDOC,
+			Optional:    r.OptionalsMDOC,
+			Crunch:      r.CrunchFromMDOC,
+			XMLSource:   r.FromXML,
+			XMLOptional: r.OptionalsXML,
+			XMLCrunch:   r.CrunchFromXML,
+			Units:       r.Units,
+			Type:        r.Type,
+			Keyed:       r.ArrayKeyed,
+			Profiles:    append([]string(nil), r.Profiles...),
+			Presence:    r.Presence,
+			Reference:   r.Reference,
+			Line:        r.Line,
+		}
+		if r.Extract != nil {
+			row.Extract = r.Extract.String()
+		}
+		for _, fallback := range r.Fallbacks {
+			row.Fallbacks = append(row.Fallbacks, MappingFallback{Key: fallback.Field, Crunch: fallback.Crunch})
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
@@ -0,0 +1,92 @@
+package conversion
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// A leaf that had a different value in the existing document than in the update.
+type MergeConflict struct {
+	Path string      // "." separated OSCEM path
+	Old  interface{} // value in the existing document
+	New  interface{} // value from the update, which is kept
+}
+
+func (c MergeConflict) String() string {
+	old, _ := json.Marshal(c.Old)
+	updated, _ := json.Marshal(c.New)
+	return fmt.Sprintf("%s: %s -> %s", c.Path, old, updated)
+}
+
+// Deep-merges a newly converted document into an existing one. Objects are merged
+// key by key; any other value from the update replaces the existing one, and every
+// replaced value that differed is reported as a conflict.
+//
+// Parameters:
+//   - existing: The previously converted OSCEM document
+//   - update: The document with newly extracted fields
+//
+// Returns:
+//   - []byte: The merged, indented document
+//   - []MergeConflict: Leaves whose existing value was overwritten, sorted by path
+//   - error: If either document is not a JSON object
+func MergeDocuments(existing, update []byte) ([]byte, []MergeConflict, error) {
+	base, err := decodeDocument(existing)
+	if err != nil {
+		return nil, nil, fmt.Errorf("existing document: %w", err)
+	}
+	incoming, err := decodeDocument(update)
+	if err != nil {
+		return nil, nil, fmt.Errorf("update: %w", err)
+	}
+	var conflicts []MergeConflict
+	mergeInto(base, incoming, "", &conflicts)
+	sort.Slice(conflicts, func(i, j int) bool { return conflicts[i].Path < conflicts[j].Path })
+
+	merged, err := json.MarshalIndent(base, "", "  ")
+	if err != nil {
+		return nil, nil, err
+	}
+	return merged, conflicts, nil
+}
+
+// Decodes a JSON object keeping numbers as written.
+func decodeDocument(doc []byte) (map[string]interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(doc))
+	dec.UseNumber()
+	var data map[string]interface{}
+	if err := dec.Decode(&data); err != nil {
+		return nil, fmt.Errorf("not a JSON object: %w", err)
+	}
+	if data == nil {
+		data = make(map[string]interface{})
+	}
+	return data, nil
+}
+
+func mergeInto(dst, src map[string]interface{}, prefix string, conflicts *[]MergeConflict) {
+	for key, newVal := range src {
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+		oldVal, exists := dst[key]
+		if !exists {
+			dst[key] = newVal
+			continue
+		}
+		oldMap, oldIsMap := oldVal.(map[string]interface{})
+		newMap, newIsMap := newVal.(map[string]interface{})
+		if oldIsMap && newIsMap {
+			mergeInto(oldMap, newMap, path, conflicts)
+			continue
+		}
+		if !reflect.DeepEqual(oldVal, newVal) {
+			*conflicts = append(*conflicts, MergeConflict{Path: path, Old: oldVal, New: newVal})
+		}
+		dst[key] = newVal
+	}
+}
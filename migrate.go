@@ -0,0 +1,258 @@
+package conversion
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// A single declarative step transforming an OSCEM document from one schema version to another.
+//
+// Supported actions:
+//   - rename: renames the last key of From to To, keeping it in place
+//   - move: moves the value at From to the full path To
+//   - split: splits the string at From on Separator and stores the parts at the ";"-separated paths in To
+//   - delete: removes the value at From
+//
+// Paths are "." separated. A segment suffixed with [N] applies the rule to every element
+// of that array; From and To must then share the same array prefix.
+type MigrationRule struct {
+	Action    string
+	From      string
+	To        string
+	Separator string
+}
+
+// Reads migration rules from a CSV file with the columns action, from, to and an optional separator.
+// Blank rows and rows starting with # are skipped.
+func LoadMigrationRules(path string) ([]MigrationRule, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open migration rules: %w", err)
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	reader.Comment = '#'
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+	colIdx := make(map[string]int)
+	for i, h := range header {
+		colIdx[strings.ToLower(strings.TrimSpace(strings.TrimLeft(h, "\ufeff")))] = i
+	}
+	for _, col := range []string{"action", "from", "to"} {
+		if _, ok := colIdx[col]; !ok {
+			return nil, fmt.Errorf("missing required column: %s", col)
+		}
+	}
+	get := func(row []string, col string) string {
+		idx, ok := colIdx[col]
+		if !ok || idx >= len(row) {
+			return ""
+		}
+		return strings.TrimSpace(row[idx])
+	}
+
+	var rules []MigrationRule
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration rules: %w", err)
+		}
+		rule := MigrationRule{
+			Action:    strings.ToLower(get(row, "action")),
+			From:      get(row, "from"),
+			To:        get(row, "to"),
+			Separator: get(row, "separator"),
+		}
+		if rule.Action == "" && rule.From == "" {
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// Applies migration rules in order to an OSCEM JSON document and stamps the target version.
+//
+// Parameters:
+//   - doc: The OSCEM JSON document to migrate
+//   - rules: The rules to apply, in order
+//   - from: Expected oscem_schema_version of the document; not checked when empty
+//   - to: Version written to oscem_schema_version; left unchanged when empty
+//
+// Returns:
+//   - []byte: The migrated, indented document
+//   - error: If the document does not match from, or a rule cannot be applied
+func Migrate(doc []byte, rules []MigrationRule, from string, to string) ([]byte, error) {
+	// numbers are kept as written, e.g. large integer IDs and exact decimals
+	data, err := decodeDocument(doc)
+	if err != nil {
+		return nil, fmt.Errorf("document: %w", err)
+	}
+	if from != "" {
+		if current, ok := data["oscem_schema_version"].(string); ok && !versionMatches(current, from) {
+			return nil, fmt.Errorf("document has schema version %s, expected %s", current, from)
+		}
+	}
+	for i, rule := range rules {
+		if err := applyMigrationRule(data, rule); err != nil {
+			return nil, fmt.Errorf("rule %d (%s %s): %w", i+1, rule.Action, rule.From, err)
+		}
+	}
+	if to != "" {
+		data["oscem_schema_version"] = to
+	}
+	return json.MarshalIndent(data, "", "  ")
+}
+
+// Reports whether a concrete version falls under a requested version or version prefix like 1.x.
+func versionMatches(version, requested string) bool {
+	version = strings.TrimPrefix(version, "v")
+	requested = strings.TrimPrefix(requested, "v")
+	prefix := strings.TrimSuffix(strings.TrimSuffix(requested, "x"), ".")
+	return version == requested || strings.HasPrefix(version, prefix+".")
+}
+
+// Applies one rule to the document, descending into arrays for [N] paths.
+func applyMigrationRule(data map[string]interface{}, rule MigrationRule) error {
+	if rule.From == "" {
+		return fmt.Errorf("missing from path")
+	}
+	if strings.Contains(rule.From, "[N]") {
+		fromPrefix, fromRest := splitAtArray(rule.From)
+		if fromRest == "" {
+			return fmt.Errorf("rules on whole arrays must not use [N]")
+		}
+		sub := rule
+		sub.From = fromRest
+		if rule.Action != "delete" && rule.Action != "rename" {
+			var toTargets []string
+			for _, target := range strings.Split(rule.To, ";") {
+				toPrefix, toRest := splitAtArray(target)
+				if toPrefix != fromPrefix {
+					return fmt.Errorf("target %s must stay within array %s[N]", target, fromPrefix)
+				}
+				toTargets = append(toTargets, toRest)
+			}
+			sub.To = strings.Join(toTargets, ";")
+		}
+		arr, ok := lookupPath(data, strings.Split(fromPrefix, ".")).([]interface{})
+		if !ok {
+			return nil
+		}
+		for _, elem := range arr {
+			if m, ok := elem.(map[string]interface{}); ok {
+				if err := applyMigrationRule(m, sub); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	path := strings.Split(rule.From, ".")
+	parent, ok := lookupPath(data, path[:len(path)-1]).(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	key := path[len(path)-1]
+	value, exists := parent[key]
+	if !exists {
+		return nil
+	}
+
+	switch rule.Action {
+	case "delete":
+		delete(parent, key)
+	case "rename":
+		if rule.To == "" || strings.Contains(rule.To, ".") {
+			return fmt.Errorf("rename target must be a single key, got %q", rule.To)
+		}
+		delete(parent, key)
+		parent[rule.To] = value
+	case "move":
+		if rule.To == "" {
+			return fmt.Errorf("missing to path")
+		}
+		delete(parent, key)
+		if err := setPath(data, strings.Split(rule.To, "."), value); err != nil {
+			return err
+		}
+	case "split":
+		str, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("only string values can be split")
+		}
+		sep := rule.Separator
+		if sep == "" {
+			sep = ";"
+		}
+		parts := strings.Split(str, sep)
+		targets := strings.Split(rule.To, ";")
+		if len(parts) != len(targets) {
+			return fmt.Errorf("value %q has %d parts but %d targets are given", str, len(parts), len(targets))
+		}
+		delete(parent, key)
+		for i, target := range targets {
+			if err := setPath(data, strings.Split(strings.TrimSpace(target), "."), strings.TrimSpace(parts[i])); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unknown action %q", rule.Action)
+	}
+	return nil
+}
+
+// Splits a path at its first [N] marker, e.g. "a.b[N].c.d" -> ("a.b", "c.d").
+func splitAtArray(path string) (string, string) {
+	parts := strings.SplitN(path, "[N]", 2)
+	if len(parts) < 2 {
+		return path, ""
+	}
+	return parts[0], strings.TrimPrefix(parts[1], ".")
+}
+
+// Returns the value at the given path in a decoded JSON document, or nil if it does not exist.
+func lookupPath(data map[string]interface{}, path []string) interface{} {
+	var curr interface{} = data
+	for _, key := range path {
+		m, ok := curr.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		curr = m[key]
+	}
+	return curr
+}
+
+// Sets a value at the given path in a decoded JSON document, creating intermediate objects.
+func setPath(data map[string]interface{}, path []string, value interface{}) error {
+	curr := data
+	for _, key := range path[:len(path)-1] {
+		next, exists := curr[key]
+		if !exists {
+			created := make(map[string]interface{})
+			curr[key] = created
+			curr = created
+			continue
+		}
+		m, ok := next.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("cannot descend into %q, it is not an object", key)
+		}
+		curr = m
+	}
+	curr[path[len(path)-1]] = value
+	return nil
+}
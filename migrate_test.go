@@ -0,0 +1,20 @@
+package conversion
+
+import (
+	"strings"
+	"testing"
+)
+
+// Numbers are migrated as written, not as float64.
+func TestMigrateKeepsNumbers(t *testing.T) {
+	doc := []byte(`{"oscem_schema_version": "1.0", "dataset": {"id": 12345678901234567890, "pixel_size": 0.41501527908716085, "dose": 1.10}}`)
+	migrated, err := Migrate(doc, nil, "1.x", "1.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, number := range []string{"12345678901234567890", "0.41501527908716085", "1.10"} {
+		if !strings.Contains(string(migrated), number) {
+			t.Errorf("%s changed in the migrated document:\n%s", number, migrated)
+		}
+	}
+}
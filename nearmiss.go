@@ -0,0 +1,84 @@
+package conversion
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Reports the source keys of a row that matched nothing in the input but differ from
+// input keys only in case or whitespace, e.g. "Defocus " or "defocus" for "Defocus",
+// with a "did you mean" suggestion. Such near misses usually come from a typo in the
+// mapping or a tool version writing its keys differently, and are otherwise silent.
+//
+// Parameters:
+//   - row: The mapping row that found no value
+//   - input: Source data as key-value pairs
+func (c *converter) suggestNearMisses(row csvextract, input map[string]string) {
+	if c.nearKeys == nil {
+		// the keys sorted by their folded form, so each form maps to a part of one slice
+		// rather than a slice of its own; with a plan only keys folding like a source key
+		// of the mapping can be suggested, and no others are kept
+		type foldedKey struct{ folded, key string }
+		var keys []foldedKey
+		var buf []byte
+		for key := range input {
+			buf = appendFoldedKey(buf[:0], key)
+			if c.sourceFolds != nil && !c.sourceFolds[string(buf)] {
+				continue
+			}
+			keys = append(keys, foldedKey{string(buf), key})
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			if keys[i].folded != keys[j].folded {
+				return keys[i].folded < keys[j].folded
+			}
+			return keys[i].key < keys[j].key
+		})
+		sorted := make([]string, len(keys))
+		c.nearKeys = make(map[string][]string, len(keys))
+		for start := 0; start < len(keys); {
+			end := start
+			for end < len(keys) && keys[end].folded == keys[start].folded {
+				sorted[end] = keys[end].key
+				end++
+			}
+			c.nearKeys[keys[start].folded] = sorted[start:end:end]
+			start = end
+		}
+	}
+	for _, key := range rowSourceKeys(row) {
+		if strings.Contains(key, "[N]") {
+			continue
+		}
+		if _, exists := input[key]; exists {
+			continue
+		}
+		if candidates := c.nearKeys[foldKey(key)]; len(candidates) > 0 {
+			c.warn(Warning{
+				Code:    WarnNearMiss,
+				Path:    row.OSCEM,
+				Row:     row.Line,
+				Message: fmt.Sprintf("input key %q not found, did you mean %q?", key, strings.Join(candidates, `" or "`)),
+			})
+		}
+	}
+}
+
+// Folds a key for near-miss comparisons: lower case, without any whitespace.
+func foldKey(key string) string {
+	return string(appendFoldedKey(nil, key))
+}
+
+// Appends the folded form of a key to buf, see foldKey, so keys can be looked up folded
+// without allocating a string for each.
+func appendFoldedKey(buf []byte, key string) []byte {
+	for _, r := range key {
+		if !unicode.IsSpace(r) {
+			buf = utf8.AppendRune(buf, unicode.ToLower(r))
+		}
+	}
+	return buf
+}
@@ -1,221 +1,521 @@
 package conversion
 
 import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
 	"embed"
-	"encoding/csv"
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/osc-em/oscem-converter-extracted/basetypes"
 )
 
-//go:embed csv/ls_conversions.csv
+//go:embed csv/ls_conversions.csv csv/jeol_conversions.csv csv/leginon_conversions.csv
 var embedded embed.FS
 
+// A site mapping compiled in with -tags sitemapping, which replaces the embedded
+// default mapping when set. See site/README.md.
+var siteMapping []byte
+
+// Describes an OSCEM field this converter can produce.
+// Array elements are addressed with the [N] notation, e.g. ["acquisition", "detectors[N]", "name"].
 type FieldSpec struct {
-	Path []string
-	Type string
+	Path  []string
+	Type  string
+	Units string
 }
 
-func Convert(jsonin []byte, contentFlag string, p1Flag string, p2Flag string, oFlag string) ([]byte, error) {
-	var rows []csvextract
-	if contentFlag != "" {
-		var err error
-		rows, err = loadMappingCSV(contentFlag) // custom
-		if err != nil {
-			log.Fatal(err)
-			return nil, err
+// Lists the OSCEM fields targeted by the embedded mapping of the newest schema generation,
+// in mapping order, plus the fields the converter always sets itself.
+func Fields() ([]FieldSpec, error) {
+	gens := schemaGenerations()
+	m, err := builtinMapping(gens[len(gens)-1])
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	var fields []FieldSpec
+	for _, row := range m.rows {
+		if row.OSCEM == "" || row.Reference || seen[row.OSCEM] {
+			continue
 		}
-	} else {
-		var err error
-		rows, err = readCSVFile(embedded) // default
-		if err != nil {
-			log.Fatal(err)
-			return nil, err
+		seen[row.OSCEM] = true
+		fields = append(fields, FieldSpec{
+			Path:  strings.Split(row.OSCEM, "."),
+			Type:  row.Type,
+			Units: row.Units,
+		})
+	}
+	// written by every conversion, see Options.injections and convertValues
+	for _, field := range []FieldSpec{
+		{Path: []string{"instrument", "cs"}, Type: "Float64", Units: "mm"},
+		{Path: []string{"acquisition", "gainref_flip_rotate"}, Type: "String"},
+		{Path: []string{"oscem_schema_version"}, Type: "String"},
+	} {
+		if !seen[strings.Join(field.Path, ".")] {
+			fields = append(fields, field)
 		}
 	}
+	return fields, nil
+}
+
+// Options configures a single conversion.
+type Options struct {
+	MappingFile     string             // custom CSV mapping file, the embedded mapping is used when empty
+	CS              string             // spherical aberration of the instrument in mm
+	GainFlipRotate  string             // whether and how the gain reference needs to be flipped/rotated
+	Inject          []Injection        // values set at any output path after the mapping, applied after CS and GainFlipRotate
+	Output          string             // output file name, derived from the working directory when empty
+	SchemaVersion   string             // OSCEM schema version to target, the newest embedded one when empty
+	Modality        string             // acquisition modality activating the mapping rows of its profile, see Modalities
+	Mapping         *Mapping           // preloaded mapping, takes precedence over MappingFile
+	Plan            *Plan              // compiled mapping, see CompileMapping; takes precedence over Mapping and MappingFile
+	Registry        *Registry          // picks mapping and injected values by instrument when neither is given
+	Extractor       string             // registered extractor turning the input into flat metadata, flat JSON when empty
+	Hooks           Hooks              // site-specific pre- and postprocessing around the mapping
+	Overlay         map[string]string  // flat metadata added to the input, e.g. sample preparation read with LoadOverlay; wins over input values
+	AppendTo        string             // existing document to merge the result into, also the default output
+	Strict          bool               // treat malformed mapping rows and every conversion warning as errors
+	Only            []string           // "." separated paths whose mapping rows are converted, limiting the output to them; all rows when empty
+	Include         []string           // "." separated paths to keep in the output, everything is kept when empty
+	Exclude         []string           // "." separated paths to drop from the output
+	Redact          []string           // "." separated paths holding personal data, "personal" for PersonalDataPaths
+	PseudonymKey    []byte             // HMAC key replacing redacted strings by stable pseudonyms instead of removing them
+	Hash            []string           // "." separated paths of identifiers replaced by their salted hash
+	HashSalt        []byte             // secret site salt for Hash
+	Rights          Rights             // license, DOI, ORCID and funder written to the organizational fields
+	Enrichers       []Enricher         // external lookups adding fields that are missing from the input
+	ChecksumKeys    []string           // input keys referencing data files whose size and sha256 are written to data_files
+	DataRoot        string             // directory relative data file paths are resolved against, the working directory when empty
+	SigningKey      ed25519.PrivateKey // signs the written document, the detached JWS is stored next to it with a .jws suffix
+	Provenance      bool               // add a _provenance object recording the input key, mapping row and crunch factor behind each value
+	Canonical       bool               // write RFC 8785 canonical JSON instead of indented JSON
+	KeepEmptySlots  bool               // write null for empty entries of ";"-separated lists instead of dropping them, keeping array positions
+	ListPolicy      string             // resolution of ";"-separated lists mapped to a single field whose entries disagree, one of the ListPolicy constants
+	ValueStyle      string             // representation of typed values, one of the ValueStyle constants
+	DerivePixelSize bool               // compute a missing pixel size from the physical pixel size, binning and magnification
+	ActiveOnly      bool               // reduce arrays to the elements reference rows name as in use, e.g. the detector of the acquisition
+	Inventory       bool               // with ActiveOnly, keep the complete arrays under instrument.inventory
+	MinFields       int                // fail instead of returning a document with fewer fields, e.g. from the wrong mapping; no minimum when 0
+	NoClobber       bool               // fail instead of replacing an existing output file
+	Force           bool               // replace an existing output file without a warning
+	Sync            bool               // fsync written files and their directory before returning, for archival pipelines
+	Limits          Limits             // caps on the number of input keys, array elements and key depth; none when zero
+}
 
-	var values map[string]string
-	_ = json.Unmarshal(jsonin, &values)
+// Result holds the outcome of a conversion.
+type Result struct {
+	Document   []byte          // indented OSCEM JSON, or canonical JSON with Options.Canonical
+	OutputPath string          // file the document was written to
+	Conflicts  []MergeConflict // values of the AppendTo document that were overwritten
+	Signature  string          // detached JWS over the document, if a SigningKey was given
+	Warnings   []Warning       // problems that did not stop the conversion
+	Stats      Stats           // fields set and skipped, arrays built, unit conversions and time taken
+}
 
-	out, err := convertToHierarchicalJSON(rows, values)
+// Converts the input with the given mapping file, cs and gain reference flip/rotate, writes
+// the document to the output file and returns it.
+//
+// Deprecated: Use convert.ToFile from pkg/convert, which takes the same settings as Options.
+func Convert(jsonin []byte, contentFlag string, p1Flag string, p2Flag string, oFlag string) ([]byte, error) {
+	res, err := ConvertContext(context.Background(), jsonin, Options{
+		MappingFile:    contentFlag,
+		CS:             p1Flag,
+		GainFlipRotate: p2Flag,
+		Output:         oFlag,
+	})
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	// placeholder for adding from flags later
-	cs := p1Flag
-	gainref_flip_rotate := p2Flag
-
-	casted := castToBaseType(cs, "float64", "mm")
-	casted2 := castToBaseType(gainref_flip_rotate, "string", "")
+	return res.Document, nil
+}
 
-	insertNested(out, []string{"instrument", "cs"}, casted)
-	insertNested(out, []string{"acquisition", "gainref_flip_rotate"}, casted2)
+// ConvertContext behaves like Convert, but stops early and returns ctx.Err()
+// once the context is cancelled or its deadline passes.
+func ConvertContext(ctx context.Context, jsonin []byte, opts Options) (*Result, error) {
+	res, err := ConvertDocument(ctx, jsonin, opts)
+	if err != nil {
+		return nil, err
+	}
+	return writeResult(res, opts)
+}
 
-	// this allows us to obtain nil values for types where Go usually doesnt allow them e.g. int
-	cleaned := CleanMap(out)
+// ConvertFile behaves like ConvertContext, but reads the input file as it converts, like
+// ConvertReader, so giant inputs need not fit in memory.
+func ConvertFile(ctx context.Context, path string, opts Options) (*Result, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open input: %w", err)
+	}
+	defer f.Close()
+	res, err := ConvertReader(ctx, f, opts)
+	if err != nil {
+		return nil, err
+	}
+	return writeResult(res, opts)
+}
 
-	pretty, _ := json.MarshalIndent(cleaned, "", "  ")
-	if oFlag == "" {
+// Writes the document of a conversion like ConvertContext: merged into opts.AppendTo, signed
+// with opts.SigningKey, to opts.Output or a file named after the working directory.
+func writeResult(res *Result, opts Options) (*Result, error) {
+	var err error
+	pretty := res.Document
+	var conflicts []MergeConflict
+	if opts.AppendTo != "" {
+		existing, err := os.ReadFile(opts.AppendTo)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read document to append to: %w", err)
+		}
+		pretty, conflicts, err = MergeDocuments(existing, pretty)
+		if err != nil {
+			return nil, err
+		}
+		if opts.Output == "" {
+			opts.Output = opts.AppendTo
+		}
+		if opts.Canonical {
+			if pretty, err = CanonicalJSON(pretty); err != nil {
+				return nil, err
+			}
+		}
+	}
+	var signature string
+	if opts.SigningKey != nil {
+		signature, err = SignDocument(pretty, opts.SigningKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if opts.NoClobber && opts.Force {
+		return nil, fmt.Errorf("NoClobber and Force exclude each other")
+	}
+	var name string
+	if opts.Output == "" {
 		cwd, _ := os.Getwd()
 		cut := strings.Split(cwd, string(os.PathSeparator))
-		name := cut[len(cut)-1] + ".json"
-		os.WriteFile(name, pretty, 0644)
-		fmt.Println()
-		fmt.Println("Extracted data was written to: ", name)
-
+		name = cut[len(cut)-1] + ".json"
 	} else {
-		twd := oFlag
-		if !strings.Contains(twd, ".json") {
+		name = opts.Output
+		if !strings.Contains(name, ".json") {
 			var conc []string
-			conc = append(conc, twd, "json")
-			twd = strings.Join(conc, ".")
+			conc = append(conc, name, "json")
+			name = strings.Join(conc, ".")
+		}
+	}
+	// appending replaces the document appended to by design
+	warnings := res.Warnings
+	if name != opts.AppendTo {
+		warnings = append(warnings, existingOutputWarning(name, opts)...)
+	}
+	writeOpts := opts
+	writeOpts.NoClobber = opts.NoClobber && name != opts.AppendTo
+	if err := WriteOutput(name, pretty, writeOpts); err != nil {
+		return nil, err
+	}
+	fmt.Println()
+	if opts.Output == "" {
+		fmt.Println("Extracted data was written to: ", name)
+	} else {
+		fmt.Printf("Extracted data was written to: %s", name)
+	}
+	if signature != "" {
+		if err := WriteOutput(name+".jws", []byte(signature+"\n"), Options{Sync: opts.Sync}); err != nil {
+			return nil, fmt.Errorf("failed to write signature: %w", err)
 		}
-		os.WriteFile(twd, pretty, 0644)
-		fmt.Println()
-		fmt.Printf("Extracted data was written to: %s", twd)
 	}
 
-	return pretty, nil
+	return &Result{Document: pretty, OutputPath: name, Conflicts: conflicts, Signature: signature, Warnings: warnings, Stats: res.Stats}, nil
+}
+
+// ConvertBytes converts the input and returns the OSCEM document without writing
+// or printing anything; opts.Output is ignored. Together with Options.Mapping
+// and ParseMapping it needs no file access at all, e.g. when running as WebAssembly.
+// Warnings are printed to stderr; use ConvertDocument to receive them instead.
+func ConvertBytes(ctx context.Context, jsonin []byte, opts Options) ([]byte, error) {
+	res, err := ConvertDocument(ctx, jsonin, opts)
+	if err != nil {
+		return nil, err
+	}
+	printWarnings(res.Warnings)
+	return res.Document, nil
 }
 
-type csvextract struct {
-	OSCEM          string
-	FromXML        string
-	FromMDOC       string
-	OptionalsMDOC  string
-	Units          string
-	CrunchFromXML  string
-	CrunchFromMDOC string
-	OptionalsXML   string
-	Type           string
+// ConvertDocument converts the input like ConvertBytes, without any I/O, and returns
+// the document along with the warnings and statistics of the conversion.
+func ConvertDocument(ctx context.Context, jsonin []byte, opts Options) (*Result, error) {
+	start := time.Now()
+	values, err := extractInput(ctx, opts.Extractor, jsonin)
+	if err != nil {
+		return nil, err
+	}
+	res, err := convertValues(ctx, values, opts)
+	if err != nil {
+		return nil, err
+	}
+	res.Stats.Elapsed = time.Since(start)
+	return res, nil
+}
+
+// ConvertReader converts the input read from r like ConvertDocument. Flat JSON inputs
+// are decoded as they are read and only the keys the mapping reads are kept, so inputs
+// with hundreds of thousands of keys, such as per-frame values of EER movies, take memory in
+// proportion to the document; Options.Limits then apply to the kept keys. Other inputs, and
+// conversions with input hooks, a registry or enrichers, which see every key, read the whole
+// input first.
+func ConvertReader(ctx context.Context, r io.Reader, opts Options) (*Result, error) {
+	start := time.Now()
+	values, err := readInput(ctx, r, opts)
+	if err != nil {
+		return nil, err
+	}
+	res, err := convertValues(ctx, values, opts)
+	if err != nil {
+		return nil, err
+	}
+	res.Stats.Elapsed = time.Since(start)
+	return res, nil
 }
 
-func loadMappingCSV(mappingPath string) ([]csvextract, error) {
-	// Use alternative file on disk (csvextractNew format)
-	file, err := os.Open(mappingPath)
+// Runs the mapping on already extracted flat metadata and returns the indented document,
+// along with the warnings and statistics of the conversion.
+func convertValues(ctx context.Context, values map[string]string, opts Options) (*Result, error) {
+	start := time.Now()
+	gen, err := resolveSchemaGeneration(opts.SchemaVersion)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open mapping file: %w", err)
+		return nil, err
 	}
-	defer file.Close()
+	values = applyOverlay(values, opts.Overlay)
+
+	opts = opts.withRegistry(values)
 
-	reader := csv.NewReader(file)
-	header, err := reader.Read()
+	rights, err := opts.Rights.normalize()
 	if err != nil {
-		return nil, fmt.Errorf("failed to read header: %w", err)
+		return nil, err
 	}
 
-	// Normalize headers
-	for i, h := range header {
-		header[i] = strings.TrimLeft(strings.TrimSpace(h), "\ufeff")
+	plan, err := activePlan(opts, gen)
+	if err != nil {
+		return nil, err
+	}
+	rows := plan.rows
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
 
-	colIdx := make(map[string]int)
-	for i, h := range header {
-		colIdx[strings.ToLower(strings.TrimSpace(h))] = i
+	for _, hook := range opts.Hooks.Input {
+		values = hook(values)
+	}
+	if err := opts.Limits.checkInput(values); err != nil {
+		return nil, err
 	}
 
-	required := []string{"oscem", "fromformat", "optionals", "units", "crunch", "type"}
-	for _, col := range required {
-		if _, ok := colIdx[col]; !ok {
-			return nil, fmt.Errorf("missing required column: %s", col)
+	if err := checkValueStyle(opts.ValueStyle); err != nil {
+		return nil, err
+	}
+	if err := checkListPolicy(opts.ListPolicy); err != nil {
+		return nil, err
+	}
+	// values derived from other fields still find them outside the selected rows
+	c := &converter{hooks: opts.Hooks, keepEmptySlots: opts.KeepEmptySlots, listPolicy: opts.ListPolicy, activeOnly: opts.ActiveOnly, inventory: opts.Inventory, limits: opts.Limits, rows: rows, sourceFolds: plan.folds, regexps: plan.regexps}
+	// what loading the mapping found is reported with every conversion using it
+	c.warnings = append(c.warnings, plan.warnings...)
+	if opts.Provenance {
+		c.provenance = make(map[string]provenanceRecord)
+	}
+	selected := rowsForPaths(rows, opts.Only)
+	out, err := c.convertToHierarchicalJSON(ctx, selected, values)
+	if err != nil {
+		return nil, err
+	}
+	c.stats.FieldsSkipped = c.skippedRows(selected)
+	if opts.DerivePixelSize {
+		if err := c.derivePixelSize(out); err != nil {
+			return nil, err
 		}
 	}
-
-	var rows []csvextract
-	for {
-		row, err := reader.Read()
-		if err == io.EOF {
-			break
+	if err := c.applyInjections(out, opts.injections()); err != nil {
+		return nil, err
+	}
+	if len(opts.ChecksumKeys) > 0 {
+		files, err := checksumDataFiles(ctx, values, opts.ChecksumKeys, opts.DataRoot, opts.Strict, c.warn)
+		if err != nil {
+			return nil, err
 		}
-
-		newRow := csvextract{
-			OSCEM:          row[colIdx["oscem"]],
-			FromMDOC:       row[colIdx["fromformat"]],
-			OptionalsMDOC:  row[colIdx["optionals"]],
-			Units:          row[colIdx["units"]],
-			CrunchFromMDOC: row[colIdx["crunch"]],
-			Type:           row[colIdx["type"]],
+		if len(files) > 0 {
+			out["data_files"] = files
 		}
-		rows = append(rows, newRow)
 	}
-	return rows, nil
-}
-
-// Read and parse the mapping CSV file
-func readCSVFile(content embed.FS) ([]csvextract, error) {
-	file, err := content.Open("csv/ls_conversions.csv")
+	enriched, err := applyEnrichers(ctx, out, values, opts.Enrichers, c.warn)
 	if err != nil {
-		return nil, fmt.Errorf("could not open ls_conversions.csv: %w", err)
+		return nil, err
+	}
+	if err := insertRights(out, rights); err != nil {
+		return nil, err
+	}
+	if c.provenance != nil {
+		injected := map[string]string{
+			"organizational.license":            rights.License,
+			"organizational.doi":                rights.DOI,
+			"organizational.authors.orcid":      rights.ORCID,
+			"organizational.funder.funder_name": rights.Funder,
+		}
+		for path, value := range injected {
+			if value != "" {
+				c.provenance[path] = provenanceRecord{Source: "option"}
+			}
+		}
+		for _, path := range enriched {
+			c.provenance[path] = provenanceRecord{Source: "enrichment"}
+		}
+	}
+	// record which schema generation the document was produced for
+	if err := insertNested(out, []string{"oscem_schema_version"}, castToBaseType(gen.Version, "string", "")); err != nil {
+		return nil, fmt.Errorf("cannot set oscem_schema_version: %w", err)
 	}
-	defer file.Close()
 
-	reader := csv.NewReader(file)
-	records, err := reader.ReadAll()
-	if err != nil {
-		return nil, fmt.Errorf("could not read CSV: %w", err)
+	for _, hook := range opts.Hooks.Output {
+		if err := hook(out); err != nil {
+			return nil, err
+		}
 	}
 
-	if len(records) == 0 {
-		return nil, fmt.Errorf("empty CSV file")
+	out = prunePaths(prunePaths(out, opts.Only, nil), opts.Include, opts.Exclude)
+	redactPaths(out, opts.Redact, opts.PseudonymKey)
+	if len(opts.Hash) > 0 {
+		if len(opts.HashSalt) == 0 {
+			return nil, fmt.Errorf("hashing identifiers requires a site salt")
+		}
+		hashPaths(out, opts.Hash, opts.HashSalt)
 	}
 
-	// Normalize headers
-	header := records[0]
-	for i, h := range header {
-		header[i] = strings.TrimLeft(h, "\ufeff")
+	if opts.Strict && len(c.warnings) > 0 {
+		return nil, fmt.Errorf("strict mode: %s (%d warnings in total)", c.warnings[0], len(c.warnings))
 	}
 
-	// Map header names to column indices
-	columnIndices := make(map[string]int)
-	for idx, col := range header {
-		columnIndices[strings.ToLower(strings.TrimSpace(col))] = idx
+	// this allows us to obtain nil values for types where Go usually doesnt allow them e.g. int
+	cleaned := styleValues(cleanValue(out, opts.KeepEmptySlots), opts.ValueStyle)
+	c.stats.FieldsSet = countFields(cleaned)
+	c.stats.ArraysBuilt = countArrays(cleaned)
+	if opts.MinFields > 0 && c.stats.FieldsSet < opts.MinFields {
+		return nil, fmt.Errorf("the document has %d fields, fewer than the minimum of %d; does the mapping fit the input?", c.stats.FieldsSet, opts.MinFields)
+	}
+	if doc, ok := cleaned.(map[string]interface{}); ok && c.provenance != nil {
+		doc["_provenance"] = pruneProvenance(pruneProvenance(c.provenance, opts.Only, nil), opts.Include, opts.Exclude)
 	}
 
-	// List of required columns
-	requiredCols := []string{
-		"oscem", "fromxml", "frommdoc", "optionals_mdoc", "units",
-		"crunchfromxml", "crunchfrommdoc", "optionals_xml", "type",
+	pretty, _ := json.MarshalIndent(cleaned, "", "  ")
+	if opts.Canonical {
+		if pretty, err = CanonicalJSON(pretty); err != nil {
+			return nil, err
+		}
 	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	c.stats.Elapsed = time.Since(start)
+	return &Result{Document: pretty, Warnings: c.warnings, Stats: c.stats}, nil
+}
 
-	// Check all required columns exist
-	for _, col := range requiredCols {
-		if _, ok := columnIndices[col]; !ok {
-			log.Fatalf("Required column %q not found in CSV header", col)
+// Fills in the mapping, cs and gain reference flip/rotate of the registry profile matching
+// the input, where the options leave them unset.
+func (opts Options) withRegistry(values map[string]string) Options {
+	if opts.Registry == nil {
+		return opts
+	}
+	if profile, ok := opts.Registry.Lookup(values); ok {
+		if opts.Plan == nil && opts.Mapping == nil && opts.MappingFile == "" {
+			opts.MappingFile = profile.Mapping
+		}
+		if opts.CS == "" {
+			opts.CS = profile.CS
 		}
+		if opts.GainFlipRotate == "" {
+			opts.GainFlipRotate = profile.GainFlipRotate
+		}
+	}
+	return opts
+}
+
+// Picks the mapping of a conversion: the compiled or preloaded mapping, the custom mapping
+// file, the embedded table of the extractor or the default mapping, in that order, and
+// returns its plan for the modality.
+func activePlan(opts Options, gen schemaGeneration) (*mappingPlan, error) {
+	m, err := selectMapping(opts, gen)
+	if err != nil {
+		return nil, err
 	}
+	return m.forModality(opts.Modality)
+}
 
-	var rows []csvextract
+// Returns the mapping the options select, with every row regardless of its profile.
+func selectMapping(opts Options, gen schemaGeneration) (*Mapping, error) {
+	switch name, ok := extractorMappings[opts.Extractor]; {
+	case opts.Plan != nil:
+		return opts.Plan.mapping, nil
+	case opts.Mapping != nil:
+		return opts.Mapping, nil
+	case opts.MappingFile != "":
+		return cachedMappingCSV(opts.MappingFile, opts.Strict) // custom, parsed once per version of the file
+	case ok:
+		return cachedEmbeddedMapping(name, func() ([]csvextract, error) { return readCSVFile(embedded, name) }) // vendor table of the extractor
+	default:
+		return builtinMapping(gen) // default
+	}
+}
 
-	for _, row := range records[1:] {
-		data := csvextract{
-			OSCEM:          row[columnIndices["oscem"]],
-			FromXML:        row[columnIndices["fromxml"]],
-			FromMDOC:       row[columnIndices["frommdoc"]],
-			OptionalsMDOC:  row[columnIndices["optionals_mdoc"]],
-			Units:          row[columnIndices["units"]],
-			CrunchFromXML:  row[columnIndices["crunchfromxml"]],
-			CrunchFromMDOC: row[columnIndices["crunchfrommdoc"]],
-			OptionalsXML:   row[columnIndices["optionals_xml"]],
-			Type:           row[columnIndices["type"]],
-		}
-		rows = append(rows, data)
+// Returns the raw embedded mapping table used for the given schema version (newest when empty),
+// as a starting point for custom mappings.
+func DefaultMapping(schemaVersion string) ([]byte, error) {
+	gen, err := resolveSchemaGeneration(schemaVersion)
+	if err != nil {
+		return nil, err
 	}
+	if siteMapping != nil {
+		return siteMapping, nil
+	}
+	return embedded.ReadFile(gen.Mapping)
+}
 
+// Loads the built-in mapping for a schema generation, preferring a compiled-in site mapping.
+// Either is parsed once and shared by later conversions.
+func builtinMapping(gen schemaGeneration) (*Mapping, error) {
+	if siteMapping != nil {
+		return cachedEmbeddedMapping("site", parseSiteMapping)
+	}
+	return cachedEmbeddedMapping(gen.Mapping, func() ([]csvextract, error) { return readCSVFile(embedded, gen.Mapping) })
+}
+
+// Parses the compiled-in site mapping.
+func parseSiteMapping() ([]csvextract, error) {
+	rows, _, err := parseMappingCSV(bytes.NewReader(siteMapping), true)
+	if err != nil {
+		return nil, fmt.Errorf("could not read site mapping: %w", err)
+	}
 	return rows, nil
 }
 
+// Removes unset values and the objects and arrays left empty by them.
+//
+// Deprecated: Conversions return cleaned documents; MarshalDocument cleans edited ones.
 func CleanMap(data interface{}) interface{} {
+	return cleanValue(data, false)
+}
+
+// Cleans a decoded document like CleanMap. With keepSlots, array elements that end up
+// empty are kept as null so the remaining elements stay at their positions, unless
+// every element of the array is empty.
+func cleanValue(data interface{}, keepSlots bool) interface{} {
 	switch v := data.(type) {
 
 	case map[string]interface{}:
 		cleanedMap := make(map[string]interface{})
 		for key, value := range v {
-			cleanedValue := CleanMap(value)
+			cleanedValue := cleanValue(value, keepSlots)
 			if cleanedValue != nil {
 				cleanedMap[key] = cleanedValue
 			}
@@ -227,13 +527,15 @@ func CleanMap(data interface{}) interface{} {
 
 	case []interface{}:
 		var cleanedSlice []interface{}
+		anySet := false
 		for _, elem := range v {
-			cleanedElem := CleanMap(elem)
-			if cleanedElem != nil {
+			cleanedElem := cleanValue(elem, keepSlots)
+			if cleanedElem != nil || keepSlots {
 				cleanedSlice = append(cleanedSlice, cleanedElem)
 			}
+			anySet = anySet || cleanedElem != nil
 		}
-		if len(cleanedSlice) == 0 {
+		if !anySet {
 			return nil
 		}
 		return cleanedSlice
@@ -0,0 +1,27 @@
+package conversion
+
+import (
+	"strings"
+	"testing"
+)
+
+// The fields every conversion writes are listed once, with their type and unit.
+func TestFieldsIncludeConverterFields(t *testing.T) {
+	fields, err := Fields()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := make(map[string]int)
+	for _, field := range fields {
+		path := strings.Join(field.Path, ".")
+		found[path]++
+		if path == "instrument.cs" && field.Units != "mm" {
+			t.Errorf("instrument.cs has unit %q, want mm", field.Units)
+		}
+	}
+	for _, path := range []string{"instrument.cs", "acquisition.gainref_flip_rotate", "oscem_schema_version"} {
+		if found[path] != 1 {
+			t.Errorf("%s is listed %d times, want once", path, found[path])
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package conversion
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// Writes a converted document or another output file atomically: the data goes to a
+// temporary file in the same directory, which replaces the target only once complete, so a
+// crash or a full disk never leaves a truncated document in the archive. With
+// Options.NoClobber an existing file is left alone and reported as an error, and with
+// Options.Sync the file and its directory are flushed to stable storage before returning.
+//
+// Parameters:
+//   - path: The file to write
+//   - data: Its content
+//   - opts: The options of the conversion, consulted for NoClobber and Sync
+//
+// Returns:
+//   - error: If the file exists under NoClobber, or writing or flushing it failed
+func WriteOutput(path string, data []byte, opts Options) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	// removes the temporary file unless it was renamed
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if opts.Sync {
+		if err := tmp.Sync(); err != nil {
+			tmp.Close()
+			return fmt.Errorf("failed to flush %s: %w", path, err)
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if opts.NoClobber {
+		err = linkNoClobber(tmp.Name(), path)
+	} else if err = os.Rename(tmp.Name(), path); err != nil {
+		err = fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err != nil || !opts.Sync {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// Flushes a directory, so a file renamed into it survives a power loss. Windows cannot
+// open directories for this and persists renames on its own.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to flush %s: %w", dir, err)
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("failed to flush %s: %w", dir, err)
+	}
+	return nil
+}
+
+// Moves a complete temporary file to a path that must not exist yet. A hard link fails if
+// the path exists, so no file appearing in the meantime is replaced; file systems without
+// hard links fall back to checking before the rename.
+func linkNoClobber(tmp string, path string) error {
+	err := os.Link(tmp, path)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, fs.ErrExist):
+		return fmt.Errorf("%s already exists, not overwriting it", path)
+	}
+	if _, err := os.Lstat(path); err == nil {
+		return fmt.Errorf("%s already exists, not overwriting it", path)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+// Returns the warning for replacing an existing output file, unless Options.Force or
+// Options.NoClobber settle what happens to it.
+func existingOutputWarning(path string, opts Options) []Warning {
+	if opts.Force || opts.NoClobber {
+		return nil
+	}
+	if _, err := os.Stat(path); err != nil {
+		return nil
+	}
+	return []Warning{{Code: WarnExistingOutput, Message: fmt.Sprintf("replaced the existing file %s", path)}}
+}
@@ -0,0 +1,258 @@
+package conversion
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/osc-em/oscem-converter-extracted/basetypes"
+)
+
+// Describes the documents a conversion with the given options can produce as a JSON Schema
+// (draft 2020-12), so downstream services can validate against what this converter emits
+// rather than the full OSCEM schema. The schema follows the active mapping, with its
+// modality, and the serialization of typed values under opts.ValueStyle, and accounts for
+// the options shaping the document: injected values, rights, checksums, provenance, derived
+// pixel sizes, list policies, selected paths, include and exclude lists, redaction and
+// hashing. Fields are optional, as any of them may be missing from an input, except
+// oscem_schema_version.
+//
+// Options that only become concrete with an input are not described: the mapping a Registry
+// picks (pass it as MappingFile instead), the fields added by Enrichers and changes made by
+// output hooks.
+//
+// Parameters:
+//   - opts: The options of the conversions to describe
+//
+// Returns:
+//   - []byte: The indented JSON Schema
+//   - error: If the mapping cannot be loaded or its rows collide
+func OutputSchema(opts Options) ([]byte, error) {
+	gen, err := resolveSchemaGeneration(opts.SchemaVersion)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkValueStyle(opts.ValueStyle); err != nil {
+		return nil, err
+	}
+	if err := checkListPolicy(opts.ListPolicy); err != nil {
+		return nil, err
+	}
+	plan, err := activePlan(opts, gen)
+	if err != nil {
+		return nil, err
+	}
+	rows := plan.rows
+
+	// a document holding a set value of the right type at every path the conversion can fill
+	template := make(map[string]interface{})
+	var keyed [][]string
+	for _, row := range rowsForPaths(rows, opts.Only) {
+		if row.OSCEM == "" || row.Reference {
+			continue
+		}
+		t := strings.ToLower(row.Type)
+		if !isKnownType(t) {
+			t = "string"
+		}
+		var value interface{} = templateValue(t, row.Units)
+		if opts.ListPolicy == ListPolicyArray && hasSourceList(row) && !strings.Contains(row.OSCEM, "[N]") {
+			value = oneOrManyTemplate{value}
+		}
+		if err := insertTemplate(template, row, value); err != nil {
+			return nil, err
+		}
+		if row.ArrayKeyed {
+			arrayPath, arrayName, _ := parseArrayPath(row.OSCEM)
+			keyed = append(keyed, append(append([]string{}, arrayPath...), arrayName))
+		}
+	}
+	// marking any row of an array as keyed applies to the whole array
+	for _, path := range keyed {
+		visitPath(template, path, func(parent map[string]interface{}, name string) {
+			if arr, ok := parent[name].([]interface{}); ok && len(arr) > 0 {
+				parent[name] = keyedTemplate(arr[0].(map[string]interface{}))
+			}
+		})
+	}
+	if opts.ActiveOnly && opts.Inventory {
+		// the complete arrays of reference rows, described like the reduced ones
+		for _, row := range rows {
+			if !row.Reference {
+				continue
+			}
+			arrayPath, arrayName, _ := parseArrayPath(row.OSCEM)
+			if array := lookupPath(template, append(arrayPath, arrayName)); array != nil {
+				if err := insertNested(template, []string{"instrument", "inventory", arrayName}, copyTree(array)); err != nil {
+					return nil, fmt.Errorf("cannot describe the inventory of %s: %w", row.OSCEM, err)
+				}
+			}
+		}
+	}
+	fixed := []struct {
+		path  string
+		value interface{}
+	}{
+		{"organizational.license", templateValue("string", "")},
+		{"organizational.doi", templateValue("string", "")},
+		{"organizational.authors.orcid", templateValue("string", "")},
+		{"organizational.funder.funder_name", templateValue("string", "")},
+	}
+	for _, inj := range opts.injections() {
+		inj, err := resolveInjection(inj, rows)
+		if err != nil {
+			return nil, err
+		}
+		fixed = append(fixed, struct {
+			path  string
+			value interface{}
+		}{inj.Path, templateValue(strings.ToLower(inj.Type), inj.Unit)})
+	}
+	if opts.DerivePixelSize && lookupPath(template, []string{"acquisition", "pixel_size"}) == nil {
+		fixed = append(fixed, struct {
+			path  string
+			value interface{}
+		}{"acquisition.pixel_size", templateValue("float64", "Å")})
+	}
+	for _, field := range fixed {
+		if err := insertNested(template, strings.Split(field.path, "."), field.value); err != nil {
+			return nil, fmt.Errorf("cannot describe %s: %w", field.path, err)
+		}
+	}
+	if len(opts.ChecksumKeys) > 0 {
+		template["data_files"] = []interface{}{map[string]interface{}{
+			"path":   templateValue("string", ""),
+			"size":   templateValue("int", ""),
+			"sha256": templateValue("string", ""),
+		}}
+	}
+	template["oscem_schema_version"] = schemaConst(gen.Version)
+
+	template = prunePaths(prunePaths(template, opts.Only, nil), opts.Include, opts.Exclude)
+	redactPaths(template, opts.Redact, opts.PseudonymKey)
+	if len(opts.Hash) > 0 {
+		// only the type of the hashed values matters here
+		hashPaths(template, opts.Hash, []byte("schema"))
+	}
+
+	schema := describeValue(template, opts)
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = fmt.Sprintf("OSC-EM %s documents produced by oscem-converter-extracted", gen.Version)
+	schema["required"] = []string{"oscem_schema_version"}
+	if opts.Provenance {
+		schema["properties"].(map[string]interface{})["_provenance"] = map[string]interface{}{
+			"type": "object",
+			"additionalProperties": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"source": map[string]interface{}{"enum": []string{"mapping", "option", "enrichment", "derived"}},
+					"input":  map[string]interface{}{"type": "string"},
+					"row":    map[string]interface{}{"type": "integer"},
+					"target": map[string]interface{}{"type": "string"},
+					"crunch": map[string]interface{}{"type": "string"},
+				},
+				"required":             []string{"source"},
+				"additionalProperties": false,
+			},
+		}
+	}
+	return json.MarshalIndent(schema, "", "  ")
+}
+
+// A string value fixed by the converter, such as the targeted schema version.
+type schemaConst string
+
+// A value that may also be written as an array of such values, see ListPolicyArray.
+type oneOrManyTemplate struct{ value interface{} }
+
+// The elements of an array emitted as an object keyed by the identifier captured for [N].
+type keyedTemplate map[string]interface{}
+
+// Returns a set value of the given mapping type and unit, standing in for every value of a row.
+func templateValue(t string, unit string) interface{} {
+	value, _ := castToBaseTypeChecked(map[string]string{
+		"int": "0", "float": "0", "float64": "0", "bool": "true", "string": "",
+	}[t], t, unit)
+	return value
+}
+
+// Inserts the stand-in value of a row at its path, creating a single element for the
+// array of an [N] path.
+func insertTemplate(template map[string]interface{}, row csvextract, value interface{}) error {
+	if !strings.Contains(row.OSCEM, "[N]") {
+		if err := insertNested(template, row.oscemPath(), value); err != nil {
+			return fmt.Errorf("cannot describe %s (row %d): %w", row.OSCEM, row.Line, err)
+		}
+		return nil
+	}
+	arrayPath, arrayName, propertyName := parseArrayPath(row.OSCEM)
+	parent, collision := arrayParent(template, arrayPath, arrayName, false)
+	if collision != nil {
+		return fmt.Errorf("cannot describe %s (row %d): %v", row.OSCEM, row.Line, collision)
+	}
+	arr := parent[arrayName].([]interface{})
+	if len(arr) == 0 {
+		arr = append(arr, make(map[string]interface{}))
+	}
+	element := arr[0].(map[string]interface{})
+	if err := insertNested(element, strings.Split(propertyName, "."), value); err != nil {
+		return fmt.Errorf("cannot describe %s (row %d): %w", row.OSCEM, row.Line, err)
+	}
+	parent[arrayName] = arr
+	return nil
+}
+
+// Describes a value of the template document as a JSON Schema.
+func describeValue(value interface{}, opts Options) map[string]interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		properties := make(map[string]interface{}, len(v))
+		for key, child := range v {
+			properties[key] = describeValue(child, opts)
+		}
+		return map[string]interface{}{"type": "object", "properties": properties, "additionalProperties": false}
+	case keyedTemplate:
+		return map[string]interface{}{"type": "object", "additionalProperties": describeValue(map[string]interface{}(v), opts)}
+	case []interface{}:
+		var items map[string]interface{}
+		if len(v) > 0 {
+			items = describeValue(v[0], opts)
+		}
+		if opts.KeepEmptySlots {
+			items = map[string]interface{}{"anyOf": []interface{}{items, map[string]interface{}{"type": "null"}}}
+		}
+		return map[string]interface{}{"type": "array", "items": items}
+	case oneOrManyTemplate:
+		one := describeValue(v.value, opts)
+		return map[string]interface{}{"anyOf": []interface{}{one, map[string]interface{}{"type": "array", "items": one}}}
+	case schemaConst:
+		return map[string]interface{}{"type": "string", "const": string(v)}
+	case basetypes.Int:
+		return describeNumber("integer", v.Unit, opts.ValueStyle)
+	case basetypes.Float64:
+		return describeNumber("number", v.Unit, opts.ValueStyle)
+	case basetypes.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	default:
+		return map[string]interface{}{"type": "string"}
+	}
+}
+
+// Describes a number as written in the given value style: bare, or as a {value, unit} object.
+func describeNumber(numberType string, unit string, style string) map[string]interface{} {
+	if style == ValueStyleBare || (style == ValueStyleDefault && unit == "") {
+		return map[string]interface{}{"type": numberType}
+	}
+	properties := map[string]interface{}{"value": map[string]interface{}{"type": numberType}}
+	required := []string{"value"}
+	if unit != "" {
+		properties["unit"] = map[string]interface{}{"const": unit}
+		required = append(required, "unit")
+	}
+	return map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"required":             required,
+		"additionalProperties": false,
+	}
+}
@@ -0,0 +1,66 @@
+package conversion
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Reads an overlay: a JSON object with metadata the instrument does not record, such as
+// the grid, autoloader cassette slot and plunge-freezing parameters of a session, kept in
+// a hand-curated file. Nested objects are flattened with "." and scalars are kept as text,
+// so {"sample": {"grid": {"mesh": 300}}} yields the input key sample.grid.mesh, which the
+// default mapping reads. Being maintained by hand, overlays may contain comments and
+// trailing commas (JSONC).
+func LoadOverlay(path string) (map[string]string, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read overlay: %w", err)
+	}
+	text, err := decodeText(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode overlay %s: %w", path, err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(stripJSONC([]byte(text))))
+	dec.UseNumber()
+	var data map[string]interface{}
+	if err := dec.Decode(&data); err != nil {
+		return nil, fmt.Errorf("overlay %s is not a JSON object: %w", path, err)
+	}
+	values := make(map[string]string)
+	flattenOverlay(data, "", values)
+	return values, nil
+}
+
+// Adds the scalars below node to values, keyed by their "." joined path.
+func flattenOverlay(node map[string]interface{}, prefix string, values map[string]string) {
+	for key, value := range node {
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		switch v := value.(type) {
+		case map[string]interface{}:
+			flattenOverlay(v, key, values)
+		case nil:
+		default:
+			values[key] = fmt.Sprint(v)
+		}
+	}
+}
+
+// Returns the input with the overlay values added, overlay values winning over the input's.
+// The input map itself is left untouched.
+func applyOverlay(input map[string]string, overlay map[string]string) map[string]string {
+	if len(overlay) == 0 {
+		return input
+	}
+	merged := make(map[string]string, len(input)+len(overlay))
+	for key, value := range input {
+		merged[key] = value
+	}
+	for key, value := range overlay {
+		merged[key] = value
+	}
+	return merged
+}
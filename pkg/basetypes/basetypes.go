@@ -0,0 +1,14 @@
+// Package basetypes holds the typed values converted documents are built from. It is part
+// of the stable v1 library surface, see package convert.
+package basetypes
+
+import "github.com/osc-em/oscem-converter-extracted/basetypes"
+
+// The typed values of converted documents: numbers carrying their unit, booleans and strings.
+// Values that were never set are written as null, which the converter removes.
+type (
+	Int     = basetypes.Int
+	Float64 = basetypes.Float64
+	Bool    = basetypes.Bool
+	String  = basetypes.String
+)
@@ -0,0 +1,196 @@
+// Package conversiontest provides helpers for testing adapters built on this engine, such as
+// extractors, hooks or facility mappings: flat inputs and minimal mappings built in a line,
+// conversions failing the test on error, and comparisons of documents against golden files.
+package conversiontest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/osc-em/oscem-converter-extracted/pkg/convert"
+	"github.com/osc-em/oscem-converter-extracted/pkg/mapping"
+)
+
+// The environment variable that makes Golden rewrite the golden files instead of comparing
+// against them, e.g. OSCEM_UPDATE_GOLDEN=1 go test ./...
+const UpdateGoldenEnv = "OSCEM_UPDATE_GOLDEN"
+
+// Returns a flat input document of alternating keys and values, as an extractor produces
+// it, e.g. Input("Microscope.Name", "Krios", "Optics.Voltage", "300000"). Panics on an odd
+// number of arguments, a mistake in the test itself.
+func Input(keyValues ...string) []byte {
+	if len(keyValues)%2 != 0 {
+		panic(fmt.Sprintf("conversiontest.Input: odd number of arguments (%d), the last key has no value", len(keyValues)))
+	}
+	values := make(map[string]string, len(keyValues)/2)
+	for i := 0; i < len(keyValues); i += 2 {
+		values[keyValues[i]] = keyValues[i+1]
+	}
+	data, err := json.Marshal(values)
+	if err != nil {
+		panic(fmt.Sprintf("conversiontest.Input: %v", err))
+	}
+	return data
+}
+
+// Returns a mapping row writing the value of an input key, converted to a type, to an OSCEM
+// path. Unit, crunch factor and other settings can be set on the returned row.
+func Row(oscem, source, typ string) mapping.Row {
+	return mapping.Row{OSCEM: oscem, Source: source, Type: typ}
+}
+
+// Returns a mapping of the given rows, failing the test if they do not form a valid mapping.
+func Mapping(t testing.TB, rows ...mapping.Row) *mapping.Mapping {
+	t.Helper()
+	m, err := mapping.New(rows)
+	if err != nil {
+		t.Fatalf("invalid test mapping: %v", err)
+	}
+	return m
+}
+
+// Converts an input without any I/O, failing the test if the conversion fails.
+func Convert(t testing.TB, input []byte, opts convert.Options) *convert.Result {
+	t.Helper()
+	res, err := convert.Document(context.Background(), input, opts)
+	if err != nil {
+		t.Fatalf("conversion failed: %v", err)
+	}
+	return res
+}
+
+// Compares a document with a golden file and fails the test, listing the differing paths,
+// unless both hold the same JSON; formatting and key order do not matter. With
+// OSCEM_UPDATE_GOLDEN set the golden file is written from the document instead, creating its
+// directory as needed.
+//
+// Parameters:
+//   - t: The test
+//   - path: The golden file, e.g. testdata/krios.golden.json
+//   - got: The document to check, e.g. Result.Document
+func Golden(t testing.TB, path string, got []byte) {
+	t.Helper()
+	if os.Getenv(UpdateGoldenEnv) != "" {
+		var doc interface{}
+		if err := decode(got, &doc); err != nil {
+			t.Fatalf("document is not valid JSON: %v", err)
+		}
+		data, _ := json.MarshalIndent(doc, "", "  ")
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to update %s: %v", path, err)
+		}
+		if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+			t.Fatalf("failed to update %s: %v", path, err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read golden file, set %s=1 to create it: %v", UpdateGoldenEnv, err)
+	}
+	diffs, err := Compare(want, got)
+	if err != nil {
+		t.Fatalf("cannot compare with %s: %v", path, err)
+	}
+	if len(diffs) > 0 {
+		t.Errorf("document differs from %s, set %s=1 to update it:\n  %s", path, UpdateGoldenEnv, strings.Join(diffs, "\n  "))
+	}
+}
+
+// Compares two JSON documents and describes each path whose value differs, in order, e.g.
+// `acquisition.voltage.value: want 300, got 200`. Formatting and key order do not matter.
+//
+// Parameters:
+//   - want: The expected document
+//   - got: The actual document
+//
+// Returns:
+//   - []string: One line per difference, none if the documents are equal
+//   - error: If either document is not valid JSON
+func Compare(want, got []byte) ([]string, error) {
+	var wantDoc, gotDoc interface{}
+	if err := decode(want, &wantDoc); err != nil {
+		return nil, fmt.Errorf("expected document is not valid JSON: %w", err)
+	}
+	if err := decode(got, &gotDoc); err != nil {
+		return nil, fmt.Errorf("document is not valid JSON: %w", err)
+	}
+	var diffs []string
+	compareNodes("", wantDoc, gotDoc, &diffs)
+	return diffs, nil
+}
+
+// Decodes JSON keeping numbers as written, so 1e3 and 1000 compare as different documents.
+func decode(data []byte, target *interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(target)
+}
+
+// Adds a line to diffs for every path below path where the two decoded values differ.
+func compareNodes(path string, want, got interface{}, diffs *[]string) {
+	wantMap, wantIsMap := want.(map[string]interface{})
+	gotMap, gotIsMap := got.(map[string]interface{})
+	if wantIsMap && gotIsMap {
+		keys := make(map[string]bool)
+		for key := range wantMap {
+			keys[key] = true
+		}
+		for key := range gotMap {
+			keys[key] = true
+		}
+		sorted := make([]string, 0, len(keys))
+		for key := range keys {
+			sorted = append(sorted, key)
+		}
+		sort.Strings(sorted)
+		for _, key := range sorted {
+			child := key
+			if path != "" {
+				child = path + "." + key
+			}
+			wantChild, inWant := wantMap[key]
+			gotChild, inGot := gotMap[key]
+			switch {
+			case !inWant:
+				*diffs = append(*diffs, fmt.Sprintf("%s: unexpected %s", child, text(gotChild)))
+			case !inGot:
+				*diffs = append(*diffs, fmt.Sprintf("%s: missing, want %s", child, text(wantChild)))
+			default:
+				compareNodes(child, wantChild, gotChild, diffs)
+			}
+		}
+		return
+	}
+	wantList, wantIsList := want.([]interface{})
+	gotList, gotIsList := got.([]interface{})
+	if wantIsList && gotIsList && len(wantList) == len(gotList) {
+		for i := range wantList {
+			compareNodes(fmt.Sprintf("%s[%d]", path, i), wantList[i], gotList[i], diffs)
+		}
+		return
+	}
+	if !reflect.DeepEqual(want, got) {
+		if path == "" {
+			path = "document"
+		}
+		*diffs = append(*diffs, fmt.Sprintf("%s: want %s, got %s", path, text(want), text(got)))
+	}
+}
+
+// Returns a decoded value as compact JSON for messages.
+func text(value interface{}) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
@@ -0,0 +1,71 @@
+package conversiontest_test
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/osc-em/oscem-converter-extracted/pkg/conversiontest"
+	"github.com/osc-em/oscem-converter-extracted/pkg/convert"
+	"github.com/osc-em/oscem-converter-extracted/pkg/mapping"
+)
+
+// The example inputs of the repository convert to their golden documents.
+func TestGoldenExamples(t *testing.T) {
+	emd, err := mapping.Load("../../csv/ms_conversions_emd.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		input string
+		opts  convert.Options
+	}{
+		{"xml_full.json", convert.Options{}},
+		{"STEM.emd_metadata.json", convert.Options{Mapping: emd}},
+	} {
+		t.Run(tt.input, func(t *testing.T) {
+			input, err := os.ReadFile("../../test/" + tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res := conversiontest.Convert(t, input, tt.opts)
+			conversiontest.Golden(t, "testdata/"+strings.TrimSuffix(tt.input, ".json")+".golden.json", res.Document)
+		})
+	}
+}
+
+// Inputs and mappings built in code convert like files.
+func TestInputAndMapping(t *testing.T) {
+	voltage := conversiontest.Row("acquisition.voltage", "Optics.Voltage", "Float64")
+	voltage.Units, voltage.Crunch = "kV", "0.001"
+	m := conversiontest.Mapping(t, voltage, conversiontest.Row("instrument.microscope.model", "Microscope.Name", "String"))
+	res := conversiontest.Convert(t, conversiontest.Input("Optics.Voltage", "300000", "Microscope.Name", "Krios"), convert.Options{Mapping: m})
+	// cs, the gain reference and the schema version are written by every conversion
+	want := `{
+		"acquisition": {"voltage": {"value": 300, "unit": "kV"}, "gainref_flip_rotate": ""},
+		"instrument": {"microscope": {"model": "Krios"}, "cs": {"value": 0, "unit": "mm"}},
+		"oscem_schema_version": "1.0.0"
+	}`
+	diffs, err := conversiontest.Compare([]byte(want), res.Document)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) > 0 {
+		t.Errorf("unexpected document:\n%s\n%s", res.Document, strings.Join(diffs, "\n"))
+	}
+}
+
+func ExampleCompare() {
+	diffs, _ := conversiontest.Compare(
+		[]byte(`{"acquisition": {"voltage": {"value": 300, "unit": "kV"}, "dose": 40}}`),
+		[]byte(`{"acquisition": {"voltage": {"value": 200, "unit": "kV"}}, "sample": {"name": "apoferritin"}}`),
+	)
+	for _, diff := range diffs {
+		fmt.Println(diff)
+	}
+	// Output:
+	// acquisition.dose: missing, want 40
+	// acquisition.voltage.value: want 300, got 200
+	// sample: unexpected {"name":"apoferritin"}
+}
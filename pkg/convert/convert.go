@@ -0,0 +1,176 @@
+// Package convert turns instrument metadata into OSCEM documents. Together with packages
+// mapping and basetypes it is the stable v1 library surface: within v1, exported names are
+// only added, never removed or changed in a breaking way, and the documents produced for the
+// same input, mapping and options stay the same apart from fixes. The top-level package of
+// the module is the implementation behind it and may change in any release.
+package convert
+
+import (
+	"context"
+	"io"
+
+	conversion "github.com/osc-em/oscem-converter-extracted"
+)
+
+// Configures a single conversion.
+type Options = conversion.Options
+
+// The outcome of a conversion: the document, its warnings and, when written, where to.
+type Result = conversion.Result
+
+// Fields set and skipped, arrays built, unit conversions and time taken by a conversion.
+type Stats = conversion.Stats
+
+// Caps on the size and complexity of an input, see Options.Limits.
+type Limits = conversion.Limits
+
+// An input exceeding one of the Limits.
+type LimitError = conversion.LimitError
+
+// The limits of the server and JSON-RPC modes, far above the inputs of any real acquisition.
+var DefaultLimits = conversion.DefaultLimits
+
+// A problem that did not stop the conversion, see the Warn constants.
+type Warning = conversion.Warning
+
+// Site-specific processing around the mapping, see Options.Hooks.
+type (
+	Hooks      = conversion.Hooks
+	InputHook  = conversion.InputHook
+	ValueHook  = conversion.ValueHook
+	OutputHook = conversion.OutputHook
+)
+
+// A value set at an output path after the mapping, see Options.Inject.
+type Injection = conversion.Injection
+
+// The license, DOI, ORCID and funder written to the organizational fields.
+type Rights = conversion.Rights
+
+// External lookups adding fields that are missing from the input, see Options.Enrichers.
+type (
+	Enricher        = conversion.Enricher
+	RESTEnricher    = conversion.RESTEnricher
+	EnrichmentField = conversion.EnrichmentField
+)
+
+// Turns an input format into the flat key-value metadata the mapping reads.
+type Extractor = conversion.Extractor
+
+// Collects the inputs of an acquisition arriving in pieces and converts them together.
+type Session = conversion.Session
+
+// How a conversion arrives at the value of an output path, see Explain.
+type (
+	FieldExplanation = conversion.FieldExplanation
+	RowTrace         = conversion.RowTrace
+	SourceCheck      = conversion.SourceCheck
+	ValueTrace       = conversion.ValueTrace
+)
+
+// A value of an existing document replaced by Merge.
+type MergeConflict = conversion.MergeConflict
+
+// Kinds of conversion warnings.
+const (
+	WarnUnitConversion = conversion.WarnUnitConversion
+	WarnOverwrite      = conversion.WarnOverwrite
+	WarnChecksum       = conversion.WarnChecksum
+	WarnLossyCast      = conversion.WarnLossyCast
+	WarnUnknownType    = conversion.WarnUnknownType
+	WarnDerived        = conversion.WarnDerived
+	WarnNearMiss       = conversion.WarnNearMiss
+	WarnListConflict   = conversion.WarnListConflict
+	WarnNoMatch        = conversion.WarnNoMatch
+	WarnReference      = conversion.WarnReference
+	WarnExistingOutput = conversion.WarnExistingOutput
+)
+
+// Representations of typed values, see Options.ValueStyle.
+const (
+	ValueStyleDefault = conversion.ValueStyleDefault
+	ValueStyleBare    = conversion.ValueStyleBare
+	ValueStyleObject  = conversion.ValueStyleObject
+)
+
+// Resolutions of ";"-separated lists whose entries disagree, see Options.ListPolicy.
+const (
+	ListPolicyFirst = conversion.ListPolicyFirst
+	ListPolicyLast  = conversion.ListPolicyLast
+	ListPolicyError = conversion.ListPolicyError
+	ListPolicyArray = conversion.ListPolicyArray
+)
+
+// The names of the built-in extractors, see Options.Extractor.
+const (
+	DefaultExtractor        = conversion.DefaultExtractor
+	JSONCExtractor          = conversion.JSONCExtractor
+	JEOLExtractor           = conversion.JEOLExtractor
+	LeginonExtractor        = conversion.LeginonExtractor
+	FIBLogExtractor         = conversion.FIBLogExtractor
+	ScreeningExtractor      = conversion.ScreeningExtractor
+	WarpSettingsExtractor   = conversion.WarpSettingsExtractor
+	RelionPipelineExtractor = conversion.RelionPipelineExtractor
+)
+
+// Converts an input without any I/O and returns the document along with its warnings.
+func Document(ctx context.Context, input []byte, opts Options) (*Result, error) {
+	return conversion.ConvertDocument(ctx, input, opts)
+}
+
+// Converts an input read from r like Document. Flat JSON inputs are decoded as they are read,
+// keeping only the keys the mapping reads, so giant inputs need not fit in memory.
+func DocumentFromReader(ctx context.Context, r io.Reader, opts Options) (*Result, error) {
+	return conversion.ConvertReader(ctx, r, opts)
+}
+
+// Converts an input and writes the document to opts.Output, merging it into opts.AppendTo
+// and signing it with opts.SigningKey when given.
+func ToFile(ctx context.Context, input []byte, opts Options) (*Result, error) {
+	return conversion.ConvertContext(ctx, input, opts)
+}
+
+// Starts collecting the inputs of one acquisition, converted together on Finalize.
+func NewSession(opts Options) *Session {
+	return conversion.NewSession(opts)
+}
+
+// Explains how a conversion with the given options arrives at the value of an OSCEM path.
+func Explain(ctx context.Context, input []byte, path string, opts Options) (*FieldExplanation, error) {
+	return conversion.Explain(ctx, input, path, opts)
+}
+
+// Describes the documents a conversion with the given options can produce as a JSON Schema.
+func OutputSchema(opts Options) ([]byte, error) {
+	return conversion.OutputSchema(opts)
+}
+
+// Deep-merges a newly converted document into an existing one, reporting replaced values.
+func Merge(existing, update []byte) ([]byte, []MergeConflict, error) {
+	return conversion.MergeDocuments(existing, update)
+}
+
+// Registers an extractor under its name, typically from an init function.
+func RegisterExtractor(e Extractor) error {
+	return conversion.RegisterExtractor(e)
+}
+
+// Returns the extractor registered under a name.
+func LookupExtractor(name string) (Extractor, bool) {
+	return conversion.LookupExtractor(name)
+}
+
+// Lists the names of the registered extractors.
+func Extractors() []string {
+	return conversion.Extractors()
+}
+
+// Returns the OSCEM schema version documents are produced for by default.
+func SchemaVersion() string {
+	return conversion.SchemaVersion()
+}
+
+// Lists the OSCEM schema versions Options.SchemaVersion accepts.
+func SupportedSchemaVersions() []string {
+	return conversion.SupportedSchemaVersions()
+}
@@ -0,0 +1,80 @@
+// Package mapping loads the CSV tables mapping input keys to OSCEM paths, and the registry
+// picking a table per instrument. It is part of the stable v1 library surface, see package
+// convert.
+package mapping
+
+import (
+	"io"
+
+	conversion "github.com/osc-em/oscem-converter-extracted"
+)
+
+// A parsed and validated mapping table, passed to conversions as convert.Options.Mapping.
+type Mapping = conversion.Mapping
+
+// A mapping compiled once for conversions, passed as convert.Options.Plan.
+type Plan = conversion.Plan
+
+// A row of a mapping, for building mappings in code with New.
+type Row = conversion.MappingRow
+
+// An alternative source key of a row, with its own unit conversion factor.
+type Fallback = conversion.MappingFallback
+
+// Keeps a mapping file loaded and swaps in new versions once they parse and validate.
+type Reloader = conversion.MappingReloader
+
+// An OSCEM field a mapping produces, with its type and unit.
+type Field = conversion.FieldSpec
+
+// Picks the mapping and injected values of a conversion by instrument.
+type Registry = conversion.Registry
+
+// The mapping and injected values the registry holds for an instrument.
+type InstrumentProfile = conversion.InstrumentProfile
+
+// Loads and validates a mapping CSV file. Malformed rows are an error.
+func Load(path string) (*Mapping, error) {
+	return conversion.LoadMapping(path)
+}
+
+// Parses and validates a mapping table, e.g. one held in memory.
+func Parse(r io.Reader) (*Mapping, error) {
+	return conversion.ParseMapping(r)
+}
+
+// Builds and validates a mapping from rows constructed in code, e.g. read from a database.
+func New(rows []Row) (*Mapping, error) {
+	return conversion.NewMapping(rows)
+}
+
+// Builds, validates and compiles a mapping from rows constructed in code into a plan.
+func Compile(rows []Row) (*Plan, error) {
+	return conversion.CompileMapping(rows)
+}
+
+// Loads a mapping file to be reloaded with Reload or Watch.
+func NewReloader(path string) (*Reloader, error) {
+	return conversion.NewMappingReloader(path)
+}
+
+// Returns the raw embedded mapping table of a schema version, the newest when empty, as a
+// starting point for custom mappings.
+func Default(schemaVersion string) ([]byte, error) {
+	return conversion.DefaultMapping(schemaVersion)
+}
+
+// Lists the OSCEM fields of the embedded mapping of the newest schema version, in mapping order.
+func Fields() ([]Field, error) {
+	return conversion.Fields()
+}
+
+// Loads an instrument registry CSV file.
+func LoadRegistry(path string) (*Registry, error) {
+	return conversion.LoadRegistry(path)
+}
+
+// Returns the acquisition modalities whose profile rows a conversion can activate.
+func Modalities() []string {
+	return append([]string(nil), conversion.Modalities...)
+}
@@ -0,0 +1,133 @@
+package conversion
+
+import (
+	"regexp"
+	"strings"
+)
+
+// A mapping compiled for conversions: its rows are validated and the plan of the rows of every
+// modality, with split paths and compiled [N] patterns, is prepared up front, so conversions
+// using it through Options.Plan parse, validate and compile nothing. A Plan is read-only and
+// can be shared between concurrent conversions.
+type Plan struct {
+	mapping *Mapping
+}
+
+// Compiles mapping rows constructed in code into a plan, validating them like NewMapping.
+//
+// Parameters:
+//   - rows: The rows of the mapping, in the order they are applied
+//
+// Returns:
+//   - *Plan: The plan, usable as Options.Plan
+//   - error: If a row is invalid or rows conflict
+func CompileMapping(rows []MappingRow) (*Plan, error) {
+	m, err := NewMapping(rows)
+	if err != nil {
+		return nil, err
+	}
+	return m.Compile()
+}
+
+// Compiles a loaded mapping into a plan, e.g. one read with LoadMapping or ParseMapping.
+func (m *Mapping) Compile() (*Plan, error) {
+	for _, modality := range append([]string{""}, Modalities...) {
+		if _, err := m.forModality(modality); err != nil {
+			return nil, err
+		}
+	}
+	return &Plan{mapping: m}, nil
+}
+
+// Returns the mapping the plan was compiled from.
+func (p *Plan) Mapping() *Mapping {
+	return p.mapping
+}
+
+// The rows of a mapping active for one modality, with what conversions look up in them
+// prepared once and shared by every conversion using the mapping for that modality.
+type mappingPlan struct {
+	rows  []csvextract    // the active rows, see rowsForModality
+	folds map[string]bool // the source keys of the rows folded by foldKey, see suggestNearMisses
+
+	// the warnings of loading the mapping that concern the active rows, see Mapping
+	warnings []Warning
+
+	// the [N] source keys and crunch keys of the rows, matching the input keys they read
+	// besides those in folds, see streamFilter, and compiled by pattern for the conversions
+	// using the plan, see patternRegexp
+	patterns []*regexp.Regexp
+	regexps  map[string]*regexp.Regexp
+}
+
+// Prepares the plan of the active rows of a mapping.
+func newMappingPlan(rows []csvextract) *mappingPlan {
+	plan := &mappingPlan{rows: rows, folds: make(map[string]bool), regexps: make(map[string]*regexp.Regexp)}
+	addPattern := func(key string) {
+		if strings.Contains(key, "[N]") && plan.regexps[key] == nil {
+			regex := regexp.MustCompile(convertPatternToRegex(key))
+			plan.regexps[key] = regex
+			plan.patterns = append(plan.patterns, regex)
+		}
+	}
+	for _, row := range rows {
+		// the dynamic array step matches the whole cell, see getFieldPattern
+		addPattern(getFieldPattern(row))
+		for _, key := range rowSourceKeys(row) {
+			if !strings.Contains(key, "[N]") {
+				plan.folds[foldKey(key)] = true
+			}
+			addPattern(key)
+		}
+		crunches := []string{row.CrunchFromMDOC, row.CrunchFromXML}
+		for _, fallback := range row.Fallbacks {
+			crunches = append(crunches, fallback.Crunch)
+		}
+		for _, crunch := range crunches {
+			for _, key := range strings.Split(crunch, ";") {
+				addPattern(strings.TrimSpace(key))
+			}
+		}
+	}
+	return plan
+}
+
+// Returns the plan of a mapping for a modality, prepared on first use.
+//
+// Parameters:
+//   - modality: One of Modalities, or empty for the rows without a profile
+//
+// Returns:
+//   - *mappingPlan: The plan, shared with other conversions
+//   - error: If the modality is unknown
+func (m *Mapping) forModality(modality string) (*mappingPlan, error) {
+	key := strings.ToLower(strings.TrimSpace(modality))
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if plan, ok := m.plans[key]; ok {
+		return plan, nil
+	}
+	rows, err := rowsForModality(m.rows, key)
+	if err != nil {
+		return nil, err
+	}
+	if m.plans == nil {
+		m.plans = make(map[string]*mappingPlan)
+	}
+	plan := newMappingPlan(rows)
+	plan.warnings = activeWarnings(m.warnings, rows)
+	m.plans[key] = plan
+	return plan, nil
+}
+
+// Returns the warnings of loading a mapping that concern the active rows: those about
+// malformed rows, and rows sharing a target only for the modalities both are active in.
+func activeWarnings(warnings []Warning, rows []csvextract) []Warning {
+	var kept []Warning
+	for _, w := range warnings {
+		if w.Code != WarnDuplicateRows {
+			kept = append(kept, w)
+		}
+	}
+	return append(kept, duplicateTargets(rows)...)
+}
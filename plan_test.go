@@ -0,0 +1,39 @@
+package conversion
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// A compiled mapping converts like the mapping it was compiled from, for every modality.
+func TestCompileMapping(t *testing.T) {
+	plan, err := CompileMapping([]MappingRow{
+		{OSCEM: "acquisition.voltage", Source: "Voltage", Crunch: "1e-3", Units: "kV", Type: "Float64"},
+		{OSCEM: "acquisition.tilt_angle", Source: "Tilt", Units: "degree", Type: "Float64", Profiles: []string{"tomo"}},
+		{OSCEM: "acquisition.detectors[N].name", Source: "Detector[N].Name", Type: "String"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := map[string]string{"Voltage": "300000", "Tilt": "-60", "Detector1.Name": "Falcon"}
+	for _, modality := range []string{"", "tomo"} {
+		compiled, err := convertValues(context.Background(), input, Options{Plan: plan, Modality: modality})
+		if err != nil {
+			t.Fatal(err)
+		}
+		loaded, err := convertValues(context.Background(), input, Options{Mapping: plan.Mapping(), Modality: modality})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(compiled.Document) != string(loaded.Document) {
+			t.Errorf("modality %q: the plan converts to\n%s\nthe mapping to\n%s", modality, compiled.Document, loaded.Document)
+		}
+		if got := strings.Contains(string(compiled.Document), "tilt_angle"); got != (modality == "tomo") {
+			t.Errorf("modality %q: tilt angle written is %t:\n%s", modality, got, compiled.Document)
+		}
+	}
+	if _, err := CompileMapping([]MappingRow{{OSCEM: "acquisition.voltage", Source: "Voltage", Type: "Flaot"}}); err == nil {
+		t.Error("a mapping with an unknown type compiled")
+	}
+}
@@ -0,0 +1,125 @@
+package conversion
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Reads the optional mode column into a row. The mode selects what the row does with its
+// source keys: "value" (the default) maps their value, "presence" writes whether any of
+// them exists and "reference" checks that their values name an element of an array, see
+// checkReferences.
+func parseRowMode(row *csvextract, cell string) error {
+	switch mode := strings.ToLower(strings.TrimSpace(cell)); mode {
+	case "", "value":
+	case "presence":
+		row.Presence = true
+	case "reference":
+		row.Reference = true
+	default:
+		return fmt.Errorf("unknown mode %q, expected value, presence or reference", mode)
+	}
+	return checkRowMode(row)
+}
+
+// Checks the targets of presence and reference rows and that presence rows write a single
+// Bool, defaulting their type to Bool.
+func checkRowMode(row *csvextract) error {
+	switch {
+	case row.Presence && row.Reference:
+		return fmt.Errorf("a row cannot be both a presence and a reference row")
+	case row.Reference:
+		if !strings.Contains(row.OSCEM, "[N]") {
+			return fmt.Errorf("reference rows must target a property of array elements, such as acquisition.detectors[N].name, not %s", row.OSCEM)
+		}
+		if _, _, property := parseArrayPath(row.OSCEM); property == "" || strings.Contains(property, "[N]") {
+			return fmt.Errorf("reference rows must target a property of array elements, such as acquisition.detectors[N].name, not %s", row.OSCEM)
+		}
+		return nil
+	case !row.Presence:
+		return nil
+	}
+	if strings.Contains(row.OSCEM, "[N]") {
+		return fmt.Errorf("presence rows cannot target array elements such as %s", row.OSCEM)
+	}
+	switch strings.ToLower(strings.TrimSpace(row.Type)) {
+	case "":
+		row.Type = "Bool"
+	case "bool":
+	default:
+		return fmt.Errorf("presence rows write a Bool, not %s", row.Type)
+	}
+	return nil
+}
+
+// Returns every source key of a row, in the priority order of findMatchingValues, with
+// ";"-separated lists split into their keys.
+func rowSourceKeys(row csvextract) []string {
+	fields := []string{row.OptionalsMDOC, row.FromMDOC, row.OptionalsXML, row.FromXML}
+	for _, fallback := range row.Fallbacks {
+		fields = append(fields, fallback.Field)
+	}
+	var keys []string
+	for _, field := range fields {
+		for _, key := range strings.Split(field, ";") {
+			if key = strings.TrimSpace(key); key != "" {
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys
+}
+
+// Returns the first input key present for a presence row.
+func (c *converter) presentKey(row csvextract, input map[string]string) (string, bool) {
+	for _, key := range rowSourceKeys(row) {
+		if inputKey, ok := c.keyPresent(key, input); ok {
+			return inputKey, true
+		}
+	}
+	return "", false
+}
+
+// Reports whether a source key exists in the input and returns the input key found. Keys
+// with [N] match any input key of the pattern, e.g. one per detector, the first in order.
+func (c *converter) keyPresent(key string, input map[string]string) (string, bool) {
+	if !strings.Contains(key, "[N]") {
+		if _, ok := input[key]; !ok {
+			return "", false
+		}
+		return key, true
+	}
+	regex := c.patternRegexp(key)
+	var matches []string
+	for inputKey := range input {
+		if regex.MatchString(inputKey) {
+			matches = append(matches, inputKey)
+		}
+	}
+	if len(matches) == 0 {
+		return "", false
+	}
+	sort.Strings(matches)
+	return matches[0], true
+}
+
+// Writes true to the target of a presence row when any of its source keys exists in the
+// input, whatever its value, and false otherwise.
+//
+// Parameters:
+//   - result: The output map being built
+//   - row: CSV mapping rule in presence mode
+//   - input: Source data as key-value pairs
+//
+// Returns:
+//   - error: If the value collides with the output of another row
+func (c *converter) handlePresenceField(result map[string]interface{}, row csvextract, input map[string]string) error {
+	key, present := c.presentKey(row, input)
+	value := castToBaseType(fmt.Sprint(present), "bool", "")
+	if err := c.insertValue(result, "", row.oscemPath(), value, row); err != nil {
+		return err
+	}
+	c.recordProvenance(row.OSCEM, row, key, "")
+	return nil
+}
@@ -0,0 +1,255 @@
+package conversion
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// The names of the built-in extractors reading the descriptions of on-the-fly processing
+// pipelines: Warp settings files and RELION default_pipeline.star files.
+const (
+	WarpSettingsExtractor   = "warp-settings"
+	RelionPipelineExtractor = "relion-pipeline"
+)
+
+func init() {
+	RegisterExtractor(warpSettingsExtractor{})
+	RegisterExtractor(relionPipelineExtractor{})
+}
+
+// Reads a Warp settings file (previous.settings), an XML document whose root holds a section
+// element per processing step, such as Movement, CTF or Picking, each with Param elements
+// carrying Name and Value attributes. Every section becomes a processing step, see
+// processingStep, whose parameters list the Params of the section; a Process<Section> Param
+// of the root reports whether the step is enabled.
+type warpSettingsExtractor struct{}
+
+func (warpSettingsExtractor) Name() string { return WarpSettingsExtractor }
+
+func (warpSettingsExtractor) Extract(_ context.Context, src []byte) (map[string]string, error) {
+	dec := xml.NewDecoder(bytes.NewReader(src))
+	software := "Warp"
+	rootParams := make(map[string]string)
+	var sections []string
+	params := make(map[string][]string)
+	var path []string
+	for {
+		token, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the Warp settings: %w", err)
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch {
+			case len(path) == 0:
+				if version := xmlAttr(t, "Version"); version != "" {
+					software += " " + version
+				}
+			case t.Name.Local == "Param" && len(path) == 1:
+				rootParams[xmlAttr(t, "Name")] = xmlAttr(t, "Value")
+			case t.Name.Local == "Param" && len(path) == 2:
+				section := path[1]
+				if _, seen := params[section]; !seen {
+					sections = append(sections, section)
+				}
+				params[section] = append(params[section], xmlAttr(t, "Name")+"="+xmlAttr(t, "Value"))
+			}
+			path = append(path, t.Name.Local)
+		case xml.EndElement:
+			path = path[:len(path)-1]
+		}
+	}
+	if len(sections) == 0 {
+		return nil, fmt.Errorf("no processing sections found in the Warp settings")
+	}
+
+	values := make(map[string]string)
+	for i, section := range sections {
+		step := processingStep{
+			Name:       section,
+			Software:   software,
+			Type:       section,
+			Parameters: strings.Join(params[section], ", "),
+		}
+		if enabled, ok := rootParams["Process"+section]; ok {
+			if flag, err := parseFlag(enabled); err == nil && flag {
+				step.Status = "enabled"
+			} else if err == nil {
+				step.Status = "disabled"
+			}
+		}
+		step.addTo(values, fmt.Sprintf("%02d", i+1))
+	}
+	return values, nil
+}
+
+// Returns the value of an attribute of an XML element, or "" if it has none.
+func xmlAttr(element xml.StartElement, name string) string {
+	for _, attr := range element.Attr {
+		if attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// Reads a RELION default_pipeline.star file: the rows of its pipeline_processes loop become
+// processing steps, see processingStep, named by their alias if set and by their process
+// name otherwise. Both the labelled type and status columns of RELION 4 and later and the
+// numeric ones of RELION 3 are understood; without a type label the process' job directory,
+// such as MotionCorr, is taken as its type. Job parameters are not part of the pipeline file
+// and are left out.
+type relionPipelineExtractor struct{}
+
+func (relionPipelineExtractor) Name() string { return RelionPipelineExtractor }
+
+// The job status codes of RELION 3 pipelines.
+var relionStatuses = map[string]string{
+	"0": "Running",
+	"1": "Scheduled",
+	"2": "Succeeded",
+	"3": "Failed",
+	"4": "Aborted",
+}
+
+func (relionPipelineExtractor) Extract(_ context.Context, src []byte) (map[string]string, error) {
+	header, rows, err := readStarLoop(src, "pipeline_processes")
+	if err != nil {
+		return nil, err
+	}
+	column := func(row []string, names ...string) string {
+		for _, name := range names {
+			if idx, ok := header[name]; ok && idx < len(row) {
+				return row[idx]
+			}
+		}
+		return ""
+	}
+
+	values := make(map[string]string)
+	for _, row := range rows {
+		process := column(row, "_rlnPipeLineProcessName")
+		if process == "" {
+			continue
+		}
+		jobType, job, _ := strings.Cut(strings.Trim(process, "/"), "/")
+		step := processingStep{
+			Name:     strings.Trim(process, "/"),
+			Software: "RELION",
+			Type:     column(row, "_rlnPipeLineProcessTypeLabel"),
+			Status:   column(row, "_rlnPipeLineProcessStatusLabel"),
+		}
+		if alias := strings.Trim(column(row, "_rlnPipeLineProcessAlias"), "/"); alias != "" && alias != "None" {
+			step.Name = alias
+		}
+		if step.Type == "" {
+			step.Type = jobType
+		}
+		if step.Status == "" {
+			step.Status = relionStatuses[column(row, "_rlnPipeLineProcessStatus")]
+		}
+		id := job
+		if id == "" {
+			id = jobType
+		}
+		step.addTo(values, strings.NewReplacer(".", "_", "/", "_").Replace(id))
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("no processes found in the RELION pipeline")
+	}
+	return values, nil
+}
+
+// Reads the loop of a STAR data block, returning the column index of every label and the rows.
+// Quoted fields may contain whitespace.
+func readStarLoop(src []byte, block string) (map[string]int, [][]string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(src))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	inBlock, inLoop := false, false
+	header := make(map[string]int)
+	var rows [][]string
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+			if inLoop && len(rows) > 0 && line == "" {
+				return header, rows, nil
+			}
+		case strings.HasPrefix(line, "data_"):
+			if inLoop {
+				return header, rows, nil
+			}
+			inBlock = line == "data_"+block
+		case !inBlock:
+		case line == "loop_":
+			inLoop = true
+		case inLoop && strings.HasPrefix(line, "_"):
+			label, _, _ := strings.Cut(line, " ")
+			header[label] = len(header)
+		case inLoop:
+			rows = append(rows, splitStarFields(line))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("failed to read the STAR file: %w", err)
+	}
+	if !inLoop {
+		return nil, nil, fmt.Errorf("no loop found in the data_%s block of the STAR file", block)
+	}
+	return header, rows, nil
+}
+
+// Splits a STAR loop row into its fields, keeping quoted fields together.
+func splitStarFields(line string) []string {
+	var fields []string
+	for line = strings.TrimSpace(line); line != ""; line = strings.TrimSpace(line) {
+		if quote := line[0]; quote == '"' || quote == '\'' {
+			if end := strings.IndexByte(line[1:], quote); end >= 0 {
+				fields = append(fields, line[1:end+1])
+				line = line[end+2:]
+				continue
+			}
+		}
+		end := strings.IndexAny(line, " \t")
+		if end < 0 {
+			end = len(line)
+		}
+		fields = append(fields, line[:end])
+		line = line[end:]
+	}
+	return fields
+}
+
+// A step of a processing pipeline, as written to the Processing.Step-<id> input keys.
+// Steps are ordered by their ID.
+type processingStep struct {
+	Name       string
+	Software   string
+	Type       string
+	Status     string
+	Parameters string
+}
+
+// Adds the non-empty fields of the step to values under the given step ID.
+func (s processingStep) addTo(values map[string]string, id string) {
+	prefix := "Processing.Step-" + id + "."
+	for key, value := range map[string]string{
+		"Name":       s.Name,
+		"Software":   s.Software,
+		"Type":       s.Type,
+		"Status":     s.Status,
+		"Parameters": s.Parameters,
+	} {
+		if value != "" {
+			values[prefix+key] = value
+		}
+	}
+}
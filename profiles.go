@@ -0,0 +1,96 @@
+package conversion
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Acquisition modalities mapping rows can be restricted to with the profile column,
+// selected for a conversion by Options.Modality.
+var Modalities = []string{"spa", "tomo", "screening", "diffraction"}
+
+// Parses a profile cell listing the modalities a row applies to, separated by "|",
+// e.g. "spa|screening". An empty cell applies the row to every modality.
+func parseProfiles(cell string) ([]string, error) {
+	if strings.TrimSpace(cell) == "" {
+		return nil, nil
+	}
+	var profiles []string
+	for _, entry := range strings.Split(cell, "|") {
+		profile := strings.ToLower(strings.TrimSpace(entry))
+		if !isModality(profile) {
+			return nil, fmt.Errorf("unknown profile %q, expected one of %s", profile, strings.Join(Modalities, ", "))
+		}
+		profiles = append(profiles, profile)
+	}
+	return profiles, nil
+}
+
+// Reports whether name is one of the known modalities.
+func isModality(name string) bool {
+	for _, modality := range Modalities {
+		if name == modality {
+			return true
+		}
+	}
+	return false
+}
+
+// Keeps the rows active for a modality: rows without a profile, and rows whose profile lists it.
+// Without a modality only the rows without a profile are active.
+//
+// Parameters:
+//   - rows: The rows of the mapping
+//   - modality: One of Modalities, or empty
+//
+// Returns:
+//   - []csvextract: The active rows, in mapping order
+//   - error: If the modality is unknown
+func rowsForModality(rows []csvextract, modality string) ([]csvextract, error) {
+	modality = strings.ToLower(strings.TrimSpace(modality))
+	if modality != "" && !isModality(modality) {
+		return nil, fmt.Errorf("unknown modality %q, expected one of %s", modality, strings.Join(Modalities, ", "))
+	}
+	var active []csvextract
+	for i, row := range rows {
+		if len(row.Profiles) == 0 || hasProfile(row, modality) {
+			if active != nil {
+				active = append(active, row)
+			}
+			continue
+		}
+		if active == nil {
+			// copy only once a row is dropped; mappings without profiles are used as they are
+			active = make([]csvextract, i, len(rows))
+			copy(active, rows[:i])
+		}
+	}
+	if active == nil {
+		return rows, nil
+	}
+	return active, nil
+}
+
+// Reports whether a row's profile lists the modality.
+func hasProfile(row csvextract, modality string) bool {
+	for _, profile := range row.Profiles {
+		if profile == modality {
+			return true
+		}
+	}
+	return false
+}
+
+// Reports whether two rows can be active in the same conversion, i.e. whether either applies
+// to every modality or their profiles share one.
+func profilesOverlap(a, b csvextract) bool {
+	if len(a.Profiles) == 0 || len(b.Profiles) == 0 {
+		return true
+	}
+	for _, profile := range a.Profiles {
+		if hasProfile(b, profile) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+package conversion
+
+import "strings"
+
+// Describes where one value of a converted document came from.
+type provenanceRecord struct {
+	Source string `json:"source"`           // mapping, option, enrichment or derived
+	Input  string `json:"input,omitempty"`  // input key the value was read from
+	Row    int    `json:"row,omitempty"`    // line of the mapping row, 0 if unknown
+	Target string `json:"target,omitempty"` // OSCEM path of the mapping row
+	Crunch string `json:"crunch,omitempty"` // unit conversion factor applied
+}
+
+// Remembers which input key, mapping row and crunch factor produced the value at a path.
+// Values of dynamic arrays are recorded under their captured identifier, e.g.
+// acquisition.detectors[EF-CCD].name, as their final position is only known later.
+func (c *converter) recordProvenance(path string, row csvextract, input string, crunch string) {
+	if c.provenance == nil {
+		return
+	}
+	c.provenance[path] = provenanceRecord{
+		Source: "mapping",
+		Input:  input,
+		Row:    row.Line,
+		Target: row.OSCEM,
+		Crunch: crunch,
+	}
+}
+
+// Drops the records of paths that include and exclude prune from the document, so the
+// audit trail does not reveal which excluded fields existed.
+func pruneProvenance(records map[string]provenanceRecord, include []string, exclude []string) map[string]provenanceRecord {
+	includes, excludes := splitPaths(include), splitPaths(exclude)
+	pruned := make(map[string]provenanceRecord)
+	for path, record := range records {
+		segments := strings.Split(path, ".")
+		for i, segment := range segments {
+			// arrays are addressed without their index in path lists
+			if idx := strings.Index(segment, "["); idx >= 0 {
+				segments[i] = segment[:idx]
+			}
+		}
+		if len(includes) > 0 && !hasPathPrefix(segments, includes) {
+			continue
+		}
+		if hasPathPrefix(segments, excludes) {
+			continue
+		}
+		pruned[path] = record
+	}
+	return pruned
+}
+
+// Reports whether a path lies at or below any of the given paths.
+func hasPathPrefix(segments []string, prefixes [][]string) bool {
+	for _, prefix := range prefixes {
+		if len(prefix) > len(segments) {
+			continue
+		}
+		match := true
+		for i := range prefix {
+			if prefix[i] != segments[i] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,128 @@
+package conversion
+
+import "strings"
+
+// Prunes a converted document down to the requested subtrees.
+// Paths are "." separated and apply to every element of the arrays they pass through.
+// oscem_schema_version is kept by include lists so pruned documents stay identifiable.
+//
+// Parameters:
+//   - doc: The converted document, before cleaning
+//   - include: Paths to keep; everything else is dropped. All paths are kept when empty
+//   - exclude: Paths to drop, applied after include
+//
+// Returns:
+//   - map[string]interface{}: The pruned document
+func prunePaths(doc map[string]interface{}, include []string, exclude []string) map[string]interface{} {
+	if paths := splitPaths(include); len(paths) > 0 {
+		paths = append(paths, []string{"oscem_schema_version"})
+		kept, _ := keepPaths(doc, paths).(map[string]interface{})
+		if kept == nil {
+			kept = make(map[string]interface{})
+		}
+		doc = kept
+	}
+	for _, path := range splitPaths(exclude) {
+		dropPath(doc, path)
+	}
+	return doc
+}
+
+// Returns the rows whose OSCEM path lies at or below one of the given paths, see Options.Only.
+// Arrays are addressed without their [N], e.g. acquisition.detectors. All rows are returned
+// when no paths are given.
+func rowsForPaths(rows []csvextract, paths []string) []csvextract {
+	prefixes := splitPaths(paths)
+	if len(prefixes) == 0 {
+		return rows
+	}
+	var selected []csvextract
+	for _, row := range rows {
+		segments := strings.Split(strings.ReplaceAll(row.OSCEM, "[N]", ""), ".")
+		if hasPathPrefix(segments, prefixes) {
+			selected = append(selected, row)
+		}
+	}
+	return selected
+}
+
+// Splits "." separated paths into their segments, ignoring blank entries.
+func splitPaths(paths []string) [][]string {
+	var split [][]string
+	for _, path := range paths {
+		path = strings.Trim(strings.TrimSpace(path), ".")
+		if path != "" {
+			split = append(split, strings.Split(path, "."))
+		}
+	}
+	return split
+}
+
+// Returns a copy of node reduced to the given relative paths, or nil if none of them exist.
+// An empty path keeps the whole node.
+func keepPaths(node interface{}, paths [][]string) interface{} {
+	for _, path := range paths {
+		if len(path) == 0 {
+			return node
+		}
+	}
+	switch v := node.(type) {
+	case map[string]interface{}:
+		kept := make(map[string]interface{})
+		for key, child := range v {
+			var below [][]string
+			for _, path := range paths {
+				if path[0] == key {
+					below = append(below, path[1:])
+				}
+			}
+			if len(below) == 0 {
+				continue
+			}
+			if value := keepPaths(child, below); value != nil {
+				kept[key] = value
+			}
+		}
+		if len(kept) == 0 {
+			return nil
+		}
+		return kept
+	case []interface{}:
+		var kept []interface{}
+		for _, elem := range v {
+			kept = append(kept, keepPaths(elem, paths))
+		}
+		return kept
+	default:
+		// a value cannot contain the remaining path
+		return nil
+	}
+}
+
+// Removes the value at the given path, in every element of the arrays along the way.
+func dropPath(node interface{}, path []string) {
+	visitPath(node, path, func(parent map[string]interface{}, key string) {
+		delete(parent, key)
+	})
+}
+
+// Calls visit with the holding object and key of every value found at the given path,
+// descending into every element of the arrays along the way.
+func visitPath(node interface{}, path []string, visit func(parent map[string]interface{}, key string)) {
+	switch v := node.(type) {
+	case map[string]interface{}:
+		child, ok := v[path[0]]
+		if !ok {
+			return
+		}
+		if len(path) == 1 {
+			visit(v, path[0])
+			return
+		}
+		visitPath(child, path[1:], visit)
+	case []interface{}:
+		for _, elem := range v {
+			visitPath(elem, path, visit)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package conversion
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/osc-em/oscem-converter-extracted/basetypes"
+)
+
+// OSCEM paths holding personal data, redacted when "personal" is given as a redaction path.
+var PersonalDataPaths = []string{
+	"organizational.authors.given_name",
+	"organizational.authors.family_name",
+	"organizational.authors.email",
+	"organizational.authors.telephone",
+	"sample.description",
+}
+
+// Prefix of pseudonyms, so they cannot be mistaken for real names.
+const pseudonymPrefix = "pseud-"
+
+// Removes or pseudonymizes personal data in a converted document before it leaves the facility.
+// With a key, string values are replaced by a keyed HMAC-SHA256 pseudonym, so the same person
+// maps to the same pseudonym across documents without being recoverable. Without a key, and
+// for values that are not strings, the value is removed.
+//
+// Parameters:
+//   - doc: The converted document, before cleaning
+//   - paths: "." separated paths to redact; "personal" stands for PersonalDataPaths
+//   - key: Secret HMAC key for pseudonyms; values are removed when empty
+func redactPaths(doc map[string]interface{}, paths []string, key []byte) {
+	var expanded []string
+	for _, path := range paths {
+		if strings.TrimSpace(path) == "personal" {
+			expanded = append(expanded, PersonalDataPaths...)
+		} else {
+			expanded = append(expanded, path)
+		}
+	}
+	for _, path := range splitPaths(expanded) {
+		visitPath(doc, path, func(parent map[string]interface{}, name string) {
+			if len(key) > 0 {
+				if value, ok := stringValue(parent[name]); ok {
+					var out basetypes.String
+					out.Set(pseudonym(key, value))
+					parent[name] = out
+					return
+				}
+			}
+			delete(parent, name)
+		})
+	}
+}
+
+// Returns the text of a string output value, as set by the mapping or an output hook.
+func stringValue(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case basetypes.String:
+		return v.Value, v.HasSet
+	case string:
+		return v, true
+	default:
+		return "", false
+	}
+}
+
+// Derives the stable pseudonym of a value under the given key.
+func pseudonym(key []byte, value string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(value))
+	return pseudonymPrefix + hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+// Replaces identifiers such as instrument serial numbers or session IDs by their salted one-way hash,
+// so documents can be shared publicly while staying linkable for anyone holding the site salt.
+// Values of any type are hashed in their textual form, and the hash is written as a string.
+//
+// Parameters:
+//   - doc: The converted document, before cleaning
+//   - paths: "." separated paths of the identifiers to hash
+//   - salt: Secret site salt, used as HMAC-SHA256 key
+func hashPaths(doc map[string]interface{}, paths []string, salt []byte) {
+	for _, path := range splitPaths(paths) {
+		visitPath(doc, path, func(parent map[string]interface{}, name string) {
+			value, ok := scalarText(parent[name])
+			if !ok {
+				// objects, arrays and unset values have no single identifier to hash
+				return
+			}
+			mac := hmac.New(sha256.New, salt)
+			mac.Write([]byte(value))
+			var out basetypes.String
+			out.Set(hashPrefix + hex.EncodeToString(mac.Sum(nil)))
+			parent[name] = out
+		})
+	}
+}
+
+// Prefix of hashed identifiers, naming the hash function.
+const hashPrefix = "sha256:"
+
+// Returns the textual form of a set scalar output value, without its unit.
+func scalarText(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case basetypes.String:
+		return v.Value, v.HasSet
+	case basetypes.Int:
+		return strconv.FormatInt(v.Value, 10), v.HasSet
+	case basetypes.Float64:
+		return strconv.FormatFloat(v.Value, 'g', -1, 64), v.HasSet
+	case basetypes.Bool:
+		return strconv.FormatBool(v.Value), v.HasSet
+	case nil, map[string]interface{}, []interface{}:
+		return "", false
+	default:
+		return fmt.Sprint(v), true
+	}
+}
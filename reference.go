@@ -0,0 +1,151 @@
+package conversion
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/osc-em/oscem-converter-extracted/basetypes"
+)
+
+// Returns the values a reference row reads from the input, one per non-empty entry of its
+// source, after the row's extract pattern. Only plain keys are looked up, so the lookup has
+// no side effects on the conversion.
+func referencedValues(row csvextract, input map[string]string) []string {
+	lookup := func(_ csvextract, input map[string]string, key string) ([]string, bool) {
+		var values []string
+		found := false
+		for _, k := range strings.Split(key, ";") {
+			value, ok := input[strings.TrimSpace(k)]
+			values = append(values, value)
+			found = found || ok
+		}
+		return values, found && !strings.Contains(key, "[N]")
+	}
+	values, _, _, found := findMatchingValues(row, input, lookup)
+	if !found {
+		return nil
+	}
+	var referenced []string
+	for _, value := range values {
+		if value = strings.TrimSpace(value); value == "" {
+			continue
+		}
+		if extracted, ok := extractValue(row, value); ok && strings.TrimSpace(extracted) != "" {
+			referenced = append(referenced, strings.TrimSpace(extracted))
+		}
+	}
+	return referenced
+}
+
+// Returns the text of an output value for comparisons, e.g. Falcon 4i for a String.
+func valueText(value interface{}) string {
+	switch v := value.(type) {
+	case basetypes.String:
+		return v.Value
+	case string:
+		return v
+	}
+	return strings.Trim(formatValue(value), `"`)
+}
+
+// Returns the names an array element can be referenced by: the value of the property and
+// the identifier captured for [N].
+func elementNames(element interface{}, property string, id string) []string {
+	var names []string
+	if id != "" {
+		names = append(names, id)
+	}
+	if m, ok := element.(map[string]interface{}); ok {
+		if value := lookupPath(m, strings.Split(property, ".")); value != nil {
+			names = append(names, valueText(value))
+		}
+	}
+	return names
+}
+
+// Checks the rows in reference mode once the arrays of the document are complete. Such a row
+// names the element of an array the acquisition used, e.g. the selected camera for
+// acquisition.detectors[N].name, and warns when the value matches the property or the
+// identifier of none of the elements, as when the metadata of that detector was not captured.
+// Values are compared case-insensitively and without surrounding whitespace. With
+// Options.ActiveOnly the arrays are then reduced to the elements referenced.
+//
+// Parameters:
+//   - result: The converted document
+//   - rows: CSV mapping rules
+//   - input: Source data as key-value pairs
+//
+// Returns:
+//   - error: If the inventory of a reduced array collides with the output of a row
+func (c *converter) checkReferences(result map[string]interface{}, rows []csvextract, input map[string]string) error {
+	// the referenced elements of each array, by position or by key of keyed arrays
+	active := make(map[string]map[string]bool)
+	var arrays []string
+	for _, row := range rows {
+		if !row.Reference {
+			continue
+		}
+		referenced := referencedValues(row, input)
+		if len(referenced) == 0 {
+			continue
+		}
+		parentPath, arrayName, property := parseArrayPath(row.OSCEM)
+		arrayPath := strings.Join(append(append([]string{}, parentPath...), arrayName), ".")
+		known := make(map[string][]string)
+		count := 0
+		switch elements := lookupPath(result, strings.Split(arrayPath, ".")).(type) {
+		case []interface{}:
+			count = len(elements)
+			for i, element := range elements {
+				for _, name := range elementNames(element, property, c.elementIDs[arrayPath][i]) {
+					name = strings.ToLower(strings.TrimSpace(name))
+					known[name] = append(known[name], strconv.Itoa(i))
+				}
+			}
+		case map[string]interface{}:
+			count = len(elements)
+			for id, element := range elements {
+				for _, name := range elementNames(element, property, id) {
+					name = strings.ToLower(strings.TrimSpace(name))
+					known[name] = append(known[name], id)
+				}
+			}
+		}
+		for _, value := range referenced {
+			if elements, ok := known[strings.ToLower(value)]; ok {
+				if active[arrayPath] == nil {
+					active[arrayPath] = make(map[string]bool)
+					arrays = append(arrays, arrayPath)
+				}
+				for _, element := range elements {
+					active[arrayPath][element] = true
+				}
+				continue
+			}
+			c.warn(Warning{
+				Code:    WarnReference,
+				Path:    row.OSCEM,
+				Row:     row.Line,
+				Message: fmt.Sprintf("the input references %q, which is not among the %s of %s", value, describeCount(count, "element"), arrayPath),
+			})
+		}
+	}
+	if !c.activeOnly {
+		return nil
+	}
+	for _, arrayPath := range arrays {
+		if err := c.keepActiveElements(result, arrayPath, active[arrayPath]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns a count with its noun, e.g. "1 element" or "3 elements".
+func describeCount(count int, noun string) string {
+	if count == 1 {
+		return "1 " + noun
+	}
+	return strconv.Itoa(count) + " " + noun + "s"
+}
@@ -0,0 +1,122 @@
+package conversion
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Configuration picked for a specific instrument: the mapping to use and the
+// values injected alongside the converted metadata.
+type InstrumentProfile struct {
+	Mapping        string // mapping CSV, resolved relative to the registry file
+	CS             string
+	GainFlipRotate string
+}
+
+type registryEntry struct {
+	Key     string // input key holding the instrument identifier
+	Value   string // identifier to match, "*" matches any value
+	Profile InstrumentProfile
+}
+
+// Maps instrument identifiers found in the input to instrument profiles.
+// Entries are checked top to bottom and the first match wins; an entry with
+// an empty key acts as the fallback.
+type Registry struct {
+	entries []registryEntry
+}
+
+// Reads a registry CSV with the columns key, value, mapping and the optional
+// cs and gain_flip_rotate columns, e.g.
+//
+//	key,value,mapping,cs,gain_flip_rotate
+//	Instrument.InstrumentId,3510,themis.csv,,
+//	MicroscopeImage.microscopeData.instrument.InstrumentID,*,krios.csv,2.7,
+func LoadRegistry(path string) (*Registry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open registry: %w", err)
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	reader.Comment = '#'
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+	colIdx := make(map[string]int)
+	for i, h := range header {
+		colIdx[strings.ToLower(strings.TrimSpace(strings.TrimLeft(h, "\ufeff")))] = i
+	}
+	for _, col := range []string{"key", "value", "mapping"} {
+		if _, ok := colIdx[col]; !ok {
+			return nil, fmt.Errorf("missing required column: %s", col)
+		}
+	}
+	get := func(row []string, col string) string {
+		idx, ok := colIdx[col]
+		if !ok || idx >= len(row) {
+			return ""
+		}
+		return strings.TrimSpace(row[idx])
+	}
+
+	dir := filepath.Dir(path)
+	var reg Registry
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read registry: %w", err)
+		}
+		entry := registryEntry{
+			Key:   get(row, "key"),
+			Value: get(row, "value"),
+			Profile: InstrumentProfile{
+				Mapping:        get(row, "mapping"),
+				CS:             get(row, "cs"),
+				GainFlipRotate: get(row, "gain_flip_rotate"),
+			},
+		}
+		if entry.Key == "" && entry.Profile.Mapping == "" {
+			continue
+		}
+		if entry.Profile.Mapping != "" && !filepath.IsAbs(entry.Profile.Mapping) {
+			entry.Profile.Mapping = filepath.Join(dir, entry.Profile.Mapping)
+		}
+		reg.entries = append(reg.entries, entry)
+	}
+	return &reg, nil
+}
+
+// Finds the profile for the instrument described by the input.
+//
+// Parameters:
+//   - input: Flat input metadata
+//
+// Returns:
+//   - InstrumentProfile: The first matching profile
+//   - bool: Whether any entry matched
+func (r *Registry) Lookup(input map[string]string) (InstrumentProfile, bool) {
+	for _, entry := range r.entries {
+		if entry.Key == "" {
+			return entry.Profile, true
+		}
+		val, exists := input[entry.Key]
+		if !exists {
+			continue
+		}
+		if entry.Value == "*" || strings.TrimSpace(val) == entry.Value {
+			return entry.Profile, true
+		}
+	}
+	return InstrumentProfile{}, false
+}
@@ -0,0 +1,216 @@
+package conversion
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A parsed and validated mapping table. It is read-only after loading and can be
+// shared between concurrent conversions through Options.Mapping, which then reuse what
+// the mapping prepared once: split paths, compiled patterns and a plan per modality.
+type Mapping struct {
+	Source   string // file the mapping was loaded from, empty for ParseMapping
+	rows     []csvextract
+	warnings []Warning // problems found while loading it outside strict mode, see parseMappingCSV
+
+	mu    sync.Mutex
+	plans map[string]*mappingPlan // the plans of the modalities used so far, see forModality
+}
+
+// Loads and validates a custom mapping CSV file. Malformed rows are always an error here.
+func LoadMapping(path string) (*Mapping, error) {
+	rows, _, err := loadMappingCSV(path, true)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateMapping(rows, true); err != nil {
+		return nil, fmt.Errorf("invalid mapping %s: %w", path, err)
+	}
+	return &Mapping{Source: path, rows: rows}, nil
+}
+
+// Loads and validates a custom mapping CSV file like a conversion naming it in
+// Options.MappingFile: outside opts.Strict malformed rows are skipped and reported as
+// warnings of every conversion using the mapping. The file is parsed once per version.
+func LoadMappingWithOptions(path string, opts Options) (*Mapping, error) {
+	return cachedMappingCSV(path, opts.Strict)
+}
+
+// Parses and validates a custom mapping from memory, for callers without file access.
+// Malformed rows are always an error here.
+func ParseMapping(r io.Reader) (*Mapping, error) {
+	rows, _, err := parseMappingCSV(r, true)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateMapping(rows, true); err != nil {
+		return nil, fmt.Errorf("invalid mapping: %w", err)
+	}
+	return &Mapping{rows: rows}, nil
+}
+
+// Checks that a mapping targets at least one OSCEM field, names a property of the elements
+// of the arrays it targets, only uses known types and lists as many crunch factors as sources.
+// Outside strict mode unknown types are left to the unknown_type warnings of conversions.
+func validateMapping(rows []csvextract, strict bool) error {
+	targets := 0
+	for _, row := range rows {
+		if row.OSCEM == "" || row.Reference {
+			continue
+		}
+		targets++
+		if strings.Contains(row.OSCEM, "[N]") {
+			// a property of each element is written, e.g. acquisition.detectors[N].name
+			_, arrayName, property := parseArrayPath(row.OSCEM)
+			if arrayName == "" || strings.Contains("."+property+".", "..") {
+				return fmt.Errorf("%s: array targets must name the array and a property of its elements, such as acquisition.detectors[N].name", row.OSCEM)
+			}
+		}
+		if strict && row.Type != "" && !isKnownType(row.Type) {
+			return fmt.Errorf("%s: unknown type %q", row.OSCEM, row.Type)
+		}
+		if err := checkCrunchLists(row); err != nil {
+			return fmt.Errorf("%s: %w", row.OSCEM, err)
+		}
+	}
+	if targets == 0 {
+		return fmt.Errorf("no rows target an OSCEM field")
+	}
+	return nil
+}
+
+// Checks that a crunch cell listing one factor per source has as many factors as each
+// ";"-separated source list it applies to has keys.
+func checkCrunchLists(row csvextract) error {
+	columns := []struct {
+		crunch  string
+		sources []string
+	}{
+		{row.CrunchFromMDOC, []string{row.OptionalsMDOC, row.FromMDOC}},
+		{row.CrunchFromXML, []string{row.OptionalsXML, row.FromXML}},
+	}
+	for _, fallback := range row.Fallbacks {
+		columns = append(columns, struct {
+			crunch  string
+			sources []string
+		}{fallback.Crunch, []string{fallback.Field}})
+	}
+	for _, column := range columns {
+		if !strings.Contains(column.crunch, ";") {
+			continue
+		}
+		factors := len(strings.Split(column.crunch, ";"))
+		for _, source := range column.sources {
+			if keys := len(strings.Split(source, ";")); source != "" && keys != factors {
+				return fmt.Errorf("crunch %q lists %d factors for the %d keys of %q", column.crunch, factors, keys, source)
+			}
+		}
+	}
+	return nil
+}
+
+// Keeps a mapping file loaded for long-running services and swaps in a new version
+// when the file changes or the process receives SIGHUP. A new version is only
+// swapped in after it loaded and validated successfully, so a half-saved or broken
+// file never replaces the mapping that is in use.
+type MappingReloader struct {
+	path    string
+	current atomic.Pointer[Mapping]
+
+	mu      sync.Mutex
+	modTime time.Time
+
+	OnReload func(*Mapping) // optional, called after a successful swap
+	OnError  func(error)    // optional, called when a reload is rejected
+}
+
+// Loads the mapping file once; fails if the initial version is not valid.
+func NewMappingReloader(path string) (*MappingReloader, error) {
+	r := &MappingReloader{path: path}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Returns the mapping currently in use.
+func (r *MappingReloader) Mapping() *Mapping {
+	return r.current.Load()
+}
+
+// Re-reads the mapping file and swaps it in if it is valid.
+func (r *MappingReloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return fmt.Errorf("failed to stat mapping file: %w", err)
+	}
+	// remember the version even if it is rejected, so a broken file is reported once
+	// and not on every poll
+	r.modTime = info.ModTime()
+	m, err := LoadMapping(r.path)
+	if err != nil {
+		return err
+	}
+	r.current.Store(m)
+	if r.OnReload != nil {
+		r.OnReload(m)
+	}
+	return nil
+}
+
+// Reloads the mapping whenever its modification time changes or SIGHUP is received,
+// until the context is cancelled.
+//
+// Parameters:
+//   - ctx: Stops watching when cancelled
+//   - interval: How often the file's modification time is checked
+func (r *MappingReloader) Watch(ctx context.Context, interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	if len(reloadSignals) > 0 {
+		signal.Notify(hup, reloadSignals...)
+		defer signal.Stop(hup)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			r.reportError(r.Reload())
+		case <-ticker.C:
+			if r.changed() {
+				r.reportError(r.Reload())
+			}
+		}
+	}
+}
+
+// Reports whether the mapping file was modified since the last load attempt.
+func (r *MappingReloader) changed() bool {
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return !info.ModTime().Equal(r.modTime)
+}
+
+func (r *MappingReloader) reportError(err error) {
+	if err != nil && r.OnError != nil {
+		r.OnError(err)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !js
+
+package conversion
+
+import (
+	"os"
+	"syscall"
+)
+
+// Signals that trigger a mapping reload.
+var reloadSignals = []os.Signal{syscall.SIGHUP}
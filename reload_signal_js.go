@@ -0,0 +1,8 @@
+//go:build js
+
+package conversion
+
+import "os"
+
+// There are no process signals in the browser; reloads happen on file changes only.
+var reloadSignals []os.Signal
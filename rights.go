@@ -0,0 +1,93 @@
+package conversion
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Licensing, persistent identifier and funding metadata supplied alongside the input,
+// which the instrument metadata itself never contains.
+type Rights struct {
+	License string // SPDX license identifier, e.g. CC-BY-4.0
+	DOI     string // DOI of the dataset, with or without a doi: or https://doi.org/ prefix
+	ORCID   string // ORCID iD of the author, with or without the https://orcid.org/ prefix
+	Funder  string // name of the funding organization
+}
+
+var (
+	spdxPattern  = regexp.MustCompile(`^[A-Za-z0-9.\-]+\+?$`)
+	doiPattern   = regexp.MustCompile(`^10\.\d{4,9}/\S+$`)
+	orcidPattern = regexp.MustCompile(`^\d{4}-\d{4}-\d{4}-\d{3}[\dX]$`)
+)
+
+// Validates the given rights metadata and returns it in normalized form,
+// with DOI and ORCID reduced to their bare identifiers.
+func (r Rights) normalize() (Rights, error) {
+	r.License = strings.TrimSpace(r.License)
+	if r.License != "" && !spdxPattern.MatchString(r.License) {
+		return r, fmt.Errorf("license %q is not an SPDX identifier", r.License)
+	}
+
+	r.DOI = strings.TrimSpace(r.DOI)
+	for _, prefix := range []string{"https://doi.org/", "http://doi.org/", "https://dx.doi.org/", "doi:"} {
+		if len(r.DOI) >= len(prefix) && strings.EqualFold(r.DOI[:len(prefix)], prefix) {
+			r.DOI = r.DOI[len(prefix):]
+			break
+		}
+	}
+	if r.DOI != "" && !doiPattern.MatchString(r.DOI) {
+		return r, fmt.Errorf("DOI %q does not have the form 10.<registrant>/<suffix>", r.DOI)
+	}
+
+	r.ORCID = strings.TrimSpace(r.ORCID)
+	r.ORCID = strings.TrimPrefix(strings.TrimPrefix(r.ORCID, "https://orcid.org/"), "http://orcid.org/")
+	if r.ORCID != "" {
+		if !orcidPattern.MatchString(r.ORCID) {
+			return r, fmt.Errorf("ORCID iD %q does not have the form 0000-0000-0000-0000", r.ORCID)
+		}
+		if !orcidChecksumValid(r.ORCID) {
+			return r, fmt.Errorf("ORCID iD %q has an invalid check digit", r.ORCID)
+		}
+	}
+
+	r.Funder = strings.TrimSpace(r.Funder)
+	return r, nil
+}
+
+// Checks the ISO 7064 MOD 11-2 check digit that ends every ORCID iD.
+func orcidChecksumValid(orcid string) bool {
+	digits := strings.ReplaceAll(orcid, "-", "")
+	total := 0
+	for _, d := range digits[:len(digits)-1] {
+		total = (total + int(d-'0')) * 2
+	}
+	check := (12 - total%11) % 11
+	want := byte('0' + check)
+	if check == 10 {
+		want = 'X'
+	}
+	return digits[len(digits)-1] == want
+}
+
+// Writes the rights metadata to its OSCEM fields, leaving fields whose option is empty untouched.
+func insertRights(out map[string]interface{}, r Rights) error {
+	fields := []struct {
+		path  []string
+		value string
+	}{
+		{[]string{"organizational", "license"}, r.License},
+		{[]string{"organizational", "doi"}, r.DOI},
+		{[]string{"organizational", "authors", "orcid"}, r.ORCID},
+		{[]string{"organizational", "funder", "funder_name"}, r.Funder},
+	}
+	for _, field := range fields {
+		if field.value == "" {
+			continue
+		}
+		if err := insertNested(out, field.path, castToBaseType(field.value, "string", "")); err != nil {
+			return fmt.Errorf("cannot set %s: %w", strings.Join(field.path, "."), err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package conversion
+
+import (
+	_ "embed"
+	"fmt"
+	"strings"
+)
+
+// Version of the OSCEM schema the embedded mapping targets. It is kept next to the
+// mapping tables in csv/ so it gets bumped together with them.
+//
+//go:embed csv/schema_version.txt
+var embeddedSchemaVersion string
+
+// Pairs an OSCEM schema version with the embedded mapping table producing documents for it.
+type schemaGeneration struct {
+	Version string
+	Mapping string // path of the mapping table inside the embedded FS
+}
+
+// All schema generations shipped with the binary, oldest first. A new generation is
+// added by embedding its mapping table and appending it here; so far only 1.0.0 is shipped.
+func schemaGenerations() []schemaGeneration {
+	return []schemaGeneration{
+		{Version: SchemaVersion(), Mapping: "csv/ls_conversions.csv"},
+	}
+}
+
+// Returns the OSCEM schema version that converted documents conform to by default.
+func SchemaVersion() string {
+	return strings.TrimSpace(embeddedSchemaVersion)
+}
+
+// Lists the OSCEM schema versions that can be selected as conversion target.
+func SupportedSchemaVersions() []string {
+	var versions []string
+	for _, gen := range schemaGenerations() {
+		versions = append(versions, gen.Version)
+	}
+	return versions
+}
+
+// Selects the schema generation matching the requested version.
+// An empty request selects the newest generation. Versions may be given in full ("1.0.0")
+// or as a prefix with an optional wildcard ("1", "1.0", "1.x"), in which case the newest
+// matching generation wins.
+//
+// Parameters:
+//   - requested: The version asked for by the caller
+//
+// Returns:
+//   - schemaGeneration: The matching generation
+//   - error: If no embedded generation matches
+func resolveSchemaGeneration(requested string) (schemaGeneration, error) {
+	gens := schemaGenerations()
+	requested = strings.TrimPrefix(strings.TrimSpace(requested), "v")
+	if requested == "" {
+		return gens[len(gens)-1], nil
+	}
+	for i := len(gens) - 1; i >= 0; i-- {
+		if versionMatches(gens[i].Version, requested) {
+			return gens[i], nil
+		}
+	}
+	return schemaGeneration{}, fmt.Errorf("unsupported OSCEM schema version %q, available: %s",
+		requested, strings.Join(SupportedSchemaVersions(), ", "))
+}
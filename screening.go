@@ -0,0 +1,127 @@
+package conversion
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The name of the built-in extractor reading SmartScope and cryoSPARC Live screening exports.
+const ScreeningExtractor = "screening"
+
+func init() {
+	RegisterExtractor(screeningExtractor{})
+}
+
+// Reads the JSON export of an automated screening tool, so the decisions taken while screening
+// a grid become part of the OSCEM record of its acquisition, e.g. as an Options.Overlay.
+//
+// The export is an object with any of the members
+//
+//	grid:      {"name": ..., "quality": ...}, the grid and its quality label or score
+//	squares:   [{"name": ..., "selected": true, "score": ...}, ...], the grid squares (SmartScope)
+//	exposures: [{"uid": ..., "accepted": true}, ...], the screened exposures (cryoSPARC Live)
+//
+// and yields Screening.Grid.Name and .Quality, the counts Screening.Squares, .SquaresSelected,
+// .Exposures and .ExposuresAccepted, and Screening.Square-<id>.Name and .Score for every
+// selected square. Squares may name their ID square_id instead of name, exposures may flag
+// rejection with rejected instead of accepted. Dots in square IDs are replaced by underscores,
+// as the mapping's [N] patterns cannot capture them.
+type screeningExtractor struct{}
+
+func (screeningExtractor) Name() string { return ScreeningExtractor }
+
+// The members of a screening export read by the extractor.
+type screeningExport struct {
+	Grid *struct {
+		Name    string          `json:"name"`
+		Quality json.RawMessage `json:"quality"`
+	} `json:"grid"`
+	Squares []struct {
+		Name     json.RawMessage `json:"name"`
+		SquareID json.RawMessage `json:"square_id"`
+		Selected bool            `json:"selected"`
+		Score    json.RawMessage `json:"score"`
+	} `json:"squares"`
+	Exposures []struct {
+		Accepted *bool `json:"accepted"`
+		Rejected *bool `json:"rejected"`
+	} `json:"exposures"`
+}
+
+func (screeningExtractor) Extract(_ context.Context, src []byte) (map[string]string, error) {
+	var export screeningExport
+	if err := json.Unmarshal(bytes.TrimPrefix(src, []byte("\ufeff")), &export); err != nil {
+		return nil, fmt.Errorf("failed to read the screening export: %w", err)
+	}
+
+	values := make(map[string]string)
+	if export.Grid != nil {
+		if export.Grid.Name != "" {
+			values["Screening.Grid.Name"] = export.Grid.Name
+		}
+		if quality := jsonScalarText(export.Grid.Quality); quality != "" {
+			values["Screening.Grid.Quality"] = quality
+		}
+	}
+	if export.Squares != nil {
+		selected := 0
+		for i, square := range export.Squares {
+			if !square.Selected {
+				continue
+			}
+			selected++
+			id := jsonScalarText(square.Name)
+			if id == "" {
+				id = jsonScalarText(square.SquareID)
+			}
+			if id == "" {
+				id = strconv.Itoa(i + 1)
+			}
+			prefix := "Screening.Square-" + strings.ReplaceAll(id, ".", "_") + "."
+			values[prefix+"Name"] = id
+			if score := jsonScalarText(square.Score); score != "" {
+				values[prefix+"Score"] = score
+			}
+		}
+		values["Screening.Squares"] = strconv.Itoa(len(export.Squares))
+		values["Screening.SquaresSelected"] = strconv.Itoa(selected)
+	}
+	if export.Exposures != nil {
+		accepted := 0
+		for _, exposure := range export.Exposures {
+			switch {
+			case exposure.Accepted != nil:
+				if *exposure.Accepted {
+					accepted++
+				}
+			case exposure.Rejected != nil:
+				if !*exposure.Rejected {
+					accepted++
+				}
+			default:
+				accepted++
+			}
+		}
+		values["Screening.Exposures"] = strconv.Itoa(len(export.Exposures))
+		values["Screening.ExposuresAccepted"] = strconv.Itoa(accepted)
+	}
+	return values, nil
+}
+
+// Returns a JSON scalar as text: strings unquoted, numbers and booleans as written, and
+// null, missing members or composite values as "".
+func jsonScalarText(raw json.RawMessage) string {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || bytes.Equal(raw, []byte("null")) || raw[0] == '{' || raw[0] == '[' {
+		return ""
+	}
+	var text string
+	if json.Unmarshal(raw, &text) == nil {
+		return strings.TrimSpace(text)
+	}
+	return string(raw)
+}
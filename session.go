@@ -0,0 +1,99 @@
+package conversion
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Builds one document from flat inputs that become available at different times,
+// e.g. the EPU XML at the start of an acquisition and the mdoc once collection has
+// finished. Inputs are merged in the order they are added; a key present in several
+// inputs takes the value added last. A Session is safe for concurrent use.
+type Session struct {
+	opts Options
+
+	mu        sync.Mutex
+	values    map[string]string
+	finalized bool
+}
+
+// Starts a new incremental conversion. The options apply to every input and to the
+// final conversion; opts.Output is ignored.
+func NewSession(opts Options) *Session {
+	return &Session{opts: opts, values: make(map[string]string)}
+}
+
+// Runs the configured extractor on src and merges the result into the session.
+func (s *Session) Add(ctx context.Context, src []byte) error {
+	values, err := extractInput(ctx, s.opts.Extractor, src)
+	if err != nil {
+		return err
+	}
+	return s.AddValues(values)
+}
+
+// Merges already extracted flat metadata into the session.
+func (s *Session) AddValues(values map[string]string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.finalized {
+		return fmt.Errorf("session is already finalized")
+	}
+	for key, val := range values {
+		s.values[key] = val
+	}
+	return nil
+}
+
+// Converts everything added so far without ending the session, e.g. for a live preview,
+// and returns the document along with the warnings of the conversion.
+func (s *Session) Preview(ctx context.Context) (*Result, error) {
+	s.mu.Lock()
+	snapshot := s.snapshot()
+	s.mu.Unlock()
+	return s.convert(ctx, snapshot)
+}
+
+// Converts all inputs into the final document and returns it along with the warnings of
+// the conversion. Afterwards no more inputs can be added; inputs added concurrently are either
+// part of the document or rejected. If the conversion fails, the session stays open.
+func (s *Session) Finalize(ctx context.Context) (*Result, error) {
+	s.mu.Lock()
+	if s.finalized {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("session is already finalized")
+	}
+	s.finalized = true
+	snapshot := s.snapshot()
+	s.mu.Unlock()
+	res, err := s.convert(ctx, snapshot)
+	if err != nil {
+		s.mu.Lock()
+		s.finalized = false
+		s.mu.Unlock()
+		return nil, err
+	}
+	return res, nil
+}
+
+// Returns a copy of the values added so far. The caller holds s.mu.
+func (s *Session) snapshot() map[string]string {
+	snapshot := make(map[string]string, len(s.values))
+	for key, val := range s.values {
+		snapshot[key] = val
+	}
+	return snapshot
+}
+
+// Converts a snapshot of the session.
+func (s *Session) convert(ctx context.Context, snapshot map[string]string) (*Result, error) {
+	start := time.Now()
+	res, err := convertValues(ctx, snapshot, s.opts)
+	if err != nil {
+		return nil, err
+	}
+	res.Stats.Elapsed = time.Since(start)
+	return res, nil
+}
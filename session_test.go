@@ -0,0 +1,63 @@
+package conversion
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// Inputs added while the session is finalized are either in the document or rejected.
+func TestSessionFinalizeConcurrentAdd(t *testing.T) {
+	mapping, err := ParseMapping(strings.NewReader("oscem,fromformat,optionals,units,crunch,type\nsample.name,Name,,,,String\nsample.late,Late,,,,String\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s *Session
+	var lateErr error
+	opts := Options{Mapping: mapping}
+	// an input added while the final document is converted
+	opts.Hooks.Input = []InputHook{func(values map[string]string) map[string]string {
+		lateErr = s.AddValues(map[string]string{"Late": "added"})
+		return values
+	}}
+	s = NewSession(opts)
+	if err := s.AddValues(map[string]string{"Name": "apoferritin"}); err != nil {
+		t.Fatal(err)
+	}
+	res, err := s.Finalize(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lateErr == nil && !strings.Contains(string(res.Document), "added") {
+		t.Fatalf("an input added during Finalize was accepted but is not in the document:\n%s", res.Document)
+	}
+	if err := s.AddValues(map[string]string{"Name": "late"}); err == nil {
+		t.Fatal("AddValues after Finalize succeeded")
+	}
+	if _, err := s.Finalize(context.Background()); err == nil {
+		t.Fatal("a second Finalize succeeded")
+	}
+}
+
+// Previews and the final document carry the warnings of their conversion.
+func TestSessionWarnings(t *testing.T) {
+	mapping, err := ParseMapping(strings.NewReader("oscem,fromformat,optionals,units,crunch,type\nacquisition.dose_rate,Dose,,e/A^2/s,,Float64\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewSession(Options{Mapping: mapping})
+	if err := s.AddValues(map[string]string{"Dose": "high"}); err != nil {
+		t.Fatal(err)
+	}
+	preview, err := s.Preview(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := s.Finalize(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(preview.Warnings) == 0 || len(res.Warnings) != len(preview.Warnings) {
+		t.Fatalf("got %v in the preview and %v in the final document, want the same warnings in both", preview.Warnings, res.Warnings)
+	}
+}
@@ -0,0 +1,128 @@
+package conversion
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Protected header of the detached signatures, Ed25519 per RFC 8037.
+const jwsHeader = `{"alg":"EdDSA"}`
+
+// Reads an Ed25519 private key from a PEM encoded PKCS #8 file, as written by
+// `openssl genpkey -algorithm ed25519`.
+func LoadSigningKey(path string) (ed25519.PrivateKey, error) {
+	key, err := readPEMKey(path, "PRIVATE KEY", func(der []byte) (interface{}, error) {
+		return x509.ParsePKCS8PrivateKey(der)
+	})
+	if err != nil {
+		return nil, err
+	}
+	private, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s does not hold an Ed25519 private key", path)
+	}
+	return private, nil
+}
+
+// Reads an Ed25519 public key from a PEM encoded PKIX file, as written by `openssl pkey -pubout`.
+func LoadVerifyKey(path string) (ed25519.PublicKey, error) {
+	key, err := readPEMKey(path, "PUBLIC KEY", x509.ParsePKIXPublicKey)
+	if err != nil {
+		return nil, err
+	}
+	public, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s does not hold an Ed25519 public key", path)
+	}
+	return public, nil
+}
+
+// Decodes the first PEM block of the given type in a file and parses it.
+func readPEMKey(path string, blockType string, parse func([]byte) (interface{}, error)) (interface{}, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key: %w", err)
+	}
+	for {
+		var block *pem.Block
+		block, raw = pem.Decode(raw)
+		if block == nil {
+			return nil, fmt.Errorf("%s contains no %s block", path, blockType)
+		}
+		if block.Type == blockType {
+			key, err := parse(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse key in %s: %w", path, err)
+			}
+			return key, nil
+		}
+	}
+}
+
+// Signs an OSCEM document and returns a detached JWS in compact serialization
+// (RFC 7515, appendix F), i.e. with an empty payload part. The RFC 8785 canonical JSON of
+// the document is signed, so the signature survives re-indenting and can be checked by any
+// JCS implementation.
+//
+// Parameters:
+//   - doc: The OSCEM JSON document
+//   - key: Ed25519 key of the facility pipeline
+//
+// Returns:
+//   - string: The detached signature, "<header>..<signature>"
+//   - error: If the document is not valid JSON
+func SignDocument(doc []byte, key ed25519.PrivateKey) (string, error) {
+	payload, err := CanonicalJSON(doc)
+	if err != nil {
+		return "", err
+	}
+	header := base64.RawURLEncoding.EncodeToString([]byte(jwsHeader))
+	signingInput := header + "." + base64.RawURLEncoding.EncodeToString(payload)
+	signature := ed25519.Sign(key, []byte(signingInput))
+	return header + ".." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// Checks a detached JWS produced by SignDocument against a document.
+//
+// Parameters:
+//   - doc: The OSCEM JSON document
+//   - jws: The detached signature
+//   - key: Ed25519 public key of the facility pipeline
+//
+// Returns:
+//   - error: If the signature is malformed or does not match the document
+func VerifyDocument(doc []byte, jws string, key ed25519.PublicKey) error {
+	parts := strings.Split(strings.TrimSpace(jws), ".")
+	if len(parts) != 3 || parts[1] != "" {
+		return fmt.Errorf("signature is not a detached compact JWS")
+	}
+	header, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return fmt.Errorf("malformed signature header: %w", err)
+	}
+	var protected struct {
+		Alg string `json:"alg"`
+	}
+	if err := json.Unmarshal(header, &protected); err != nil || protected.Alg != "EdDSA" {
+		return fmt.Errorf("unsupported signature header %s", header)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return fmt.Errorf("malformed signature: %w", err)
+	}
+	payload, err := CanonicalJSON(doc)
+	if err != nil {
+		return err
+	}
+	signingInput := parts[0] + "." + base64.RawURLEncoding.EncodeToString(payload)
+	if !ed25519.Verify(key, []byte(signingInput), signature) {
+		return fmt.Errorf("signature does not match the document")
+	}
+	return nil
+}
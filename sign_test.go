@@ -0,0 +1,30 @@
+package conversion
+
+import (
+	"crypto/ed25519"
+	"testing"
+)
+
+// A signature verifies against the re-indented document but not against one with content appended.
+func TestSignDocumentRoundTrip(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jws, err := SignDocument([]byte(`{"a":1,"b":[true,null]}`), private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyDocument([]byte("{\n  \"b\": [true, null],\n  \"a\": 1.0\n}\n"), jws, public); err != nil {
+		t.Errorf("re-indented document: %v", err)
+	}
+	for _, doc := range []string{
+		`{"a":2,"b":[true,null]}`,
+		`{"a":1,"b":[true,null]} {"tampered":true}`,
+		`{"a":1,"b":[true,null]}]`,
+	} {
+		if err := VerifyDocument([]byte(doc), jws, public); err == nil {
+			t.Errorf("signature verified against %s", doc)
+		}
+	}
+}
@@ -0,0 +1,15 @@
+//go:build sitemapping
+
+package conversion
+
+import _ "embed"
+
+// A facility's own mapping, compiled in with `go build -tags sitemapping`.
+// It replaces the embedded default mapping, see site/README.md.
+//
+//go:embed site/conversions.csv
+var siteMappingCSV []byte
+
+func init() {
+	siteMapping = siteMappingCSV
+}
@@ -0,0 +1,61 @@
+package conversion
+
+import (
+	"strconv"
+	"time"
+)
+
+// Stats summarizes a conversion, so embedding services can emit their own metrics without
+// parsing the log.
+type Stats struct {
+	FieldsSet       int           `json:"fields_set"`       // distinct field paths in the document, counted like Options.MinFields
+	FieldsSkipped   int           `json:"fields_skipped"`   // mapping rows that wrote no value, mostly as their source is missing from the input
+	ArraysBuilt     int           `json:"arrays_built"`     // non-empty arrays in the document, such as acquisition.detectors
+	UnitConversions int           `json:"unit_conversions"` // values converted by a crunch factor or to a dose unit
+	Elapsed         time.Duration `json:"elapsed_ns"`       // time taken, from reading the input to the finished document
+}
+
+// Returns the key remembering that a mapping row wrote a value.
+func rowKey(row csvextract) string {
+	return strconv.Itoa(row.Line) + "\x00" + row.OSCEM
+}
+
+// Counts the mapping rows that wrote no value during the conversion. Reference rows never
+// write one and are left out.
+func (c *converter) skippedRows(rows []csvextract) int {
+	skipped := 0
+	for _, row := range rows {
+		if row.OSCEM != "" && !row.Reference && !c.rowsWritten[rowKey(row)] {
+			skipped++
+		}
+	}
+	return skipped
+}
+
+// Returns the number of distinct field paths in a document, array elements counted once,
+// apart from oscem_schema_version, which every document has.
+func countFields(doc interface{}) int {
+	fields := make(map[string]bool)
+	collectLeafPaths(doc, "", fields)
+	delete(fields, "oscem_schema_version")
+	return len(fields)
+}
+
+// Returns the number of non-empty arrays in a document, including arrays within arrays.
+func countArrays(node interface{}) int {
+	count := 0
+	switch v := node.(type) {
+	case map[string]interface{}:
+		for _, child := range v {
+			count += countArrays(child)
+		}
+	case []interface{}:
+		if len(v) > 0 {
+			count++
+		}
+		for _, element := range v {
+			count += countArrays(element)
+		}
+	}
+	return count
+}
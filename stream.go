@@ -0,0 +1,215 @@
+package conversion
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
+
+// Reports input that is not valid UTF-8, which the default extractor decodes as
+// Windows-1252 instead, see decodeText, but only once all of it was read.
+var errInvalidUTF8 = errors.New("input is not valid UTF-8")
+
+// Reads the flat metadata of an input from r. Flat JSON objects in UTF-8, the output of most
+// extraction tools, are decoded as they are read, keeping only the keys the mapping can use,
+// so giant inputs such as the per-frame keys of 10,000-frame EER stacks take memory in
+// proportion to the document rather than to the input. Everything else is read completely
+// and handed to the extractor like the input of ConvertDocument, as are all inputs of
+// conversions whose options see every key: input hooks, a registry or enrichers.
+//
+// Parameters:
+//   - ctx: Cancels the extraction, for extractors supporting it
+//   - r: The input; one that is not valid UTF-8 is read again from its start if r is an
+//     io.Seeker, such as a file, and is an error otherwise
+//   - opts: The options of the conversion
+//
+// Returns:
+//   - map[string]string: The flat metadata
+//   - error: If the input cannot be read or decoded
+func readInput(ctx context.Context, r io.Reader, opts Options) (map[string]string, error) {
+	var start int64
+	seeker, seekable := r.(io.Seeker)
+	if seekable {
+		var err error
+		if start, err = seeker.Seek(0, io.SeekCurrent); err != nil {
+			seekable = false
+		}
+	}
+	br := bufio.NewReader(r)
+	if keep := streamFilter(opts); keep != nil && streamableJSON(br) {
+		values, err := decodeFlatJSON(br, keep)
+		if !errors.Is(err, errInvalidUTF8) {
+			return values, err
+		}
+		if !seekable {
+			return nil, fmt.Errorf("%w and cannot be read again to decode it as Windows-1252; convert it to UTF-8", err)
+		}
+		if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+			return nil, fmt.Errorf("failed to read the input again: %w", err)
+		}
+		br.Reset(r)
+	}
+	src, err := io.ReadAll(br)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read input: %w", err)
+	}
+	return extractInput(ctx, opts.Extractor, src)
+}
+
+// Returns whether a conversion may drop the input keys its mapping does not read, and if
+// so a function reporting the keys to keep: those of the rows and their [N] patterns, those
+// differing from them only in case or whitespace, for near-miss warnings, and the checksum
+// keys. Options whose errors the conversion reports, such as an unknown schema version,
+// return nil, so the input is read completely and the conversion fails as usual.
+func streamFilter(opts Options) func(string) bool {
+	if (opts.Extractor != "" && opts.Extractor != DefaultExtractor) || len(opts.Hooks.Input) > 0 || opts.Registry != nil || len(opts.Enrichers) > 0 {
+		return nil
+	}
+	gen, err := resolveSchemaGeneration(opts.SchemaVersion)
+	if err != nil {
+		return nil
+	}
+	plan, err := activePlan(opts, gen)
+	if err != nil {
+		return nil
+	}
+	checksumKeys := make(map[string]bool, len(opts.ChecksumKeys))
+	for _, key := range opts.ChecksumKeys {
+		checksumKeys[key] = true
+	}
+	var buf []byte
+	return func(key string) bool {
+		if checksumKeys[key] {
+			return true
+		}
+		buf = appendFoldedKey(buf[:0], key)
+		if plan.folds[string(buf)] {
+			return true
+		}
+		for _, pattern := range plan.patterns {
+			if pattern.MatchString(key) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Reports whether an input starts like a JSON object in UTF-8, skipping a UTF-8 byte order
+// mark. UTF-16, recognized by its byte order mark or NUL bytes, and other JSON values are
+// left to the extractor, which handles them like ConvertDocument.
+func streamableJSON(br *bufio.Reader) bool {
+	if head, _ := br.Peek(3); bytes.Equal(head, []byte{0xEF, 0xBB, 0xBF}) {
+		br.Discard(3)
+	}
+	head, _ := br.Peek(512)
+	if bytes.IndexByte(head, 0) >= 0 {
+		return false
+	}
+	head = bytes.TrimLeft(head, " \t\r\n")
+	return len(head) > 0 && head[0] == '{'
+}
+
+// Decodes a flat JSON object as it is read, keeping the keys keep accepts. Like the default
+// extractor, values that are not strings are kept as empty strings, and the last of
+// repeated keys wins.
+//
+// Parameters:
+//   - r: The input, starting with the object
+//   - keep: Reports whether to keep a key
+//
+// Returns:
+//   - map[string]string: The kept keys and their values
+//   - error: errInvalidUTF8 if the input is not valid UTF-8, or if it is no JSON object
+func decodeFlatJSON(r io.Reader, keep func(string) bool) (map[string]string, error) {
+	ur := &utf8Reader{r: r}
+	dec := json.NewDecoder(ur)
+	fail := func(err error) (map[string]string, error) {
+		switch {
+		case errors.Is(err, errInvalidUTF8):
+			return nil, errInvalidUTF8
+		case errors.Is(err, io.ErrUnexpectedEOF):
+			// worded like the errors of json.Unmarshal, as the extractor reports them
+			err = errors.New("unexpected end of JSON input")
+		}
+		return nil, fmt.Errorf("input is not a JSON object: %w", err)
+	}
+	if _, err := dec.Token(); err != nil {
+		return fail(err)
+	}
+	values := make(map[string]string)
+	for dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			return fail(err)
+		}
+		key, _ := token.(string)
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return fail(err)
+		}
+		if !keep(key) {
+			continue
+		}
+		var value string
+		if len(raw) > 0 && raw[0] == '"' {
+			if err := json.Unmarshal(raw, &value); err != nil {
+				return fail(err)
+			}
+		}
+		values[key] = value
+	}
+	if _, err := dec.Token(); err != nil {
+		return fail(err)
+	}
+	rest := bufio.NewReader(io.MultiReader(dec.Buffered(), ur))
+	for {
+		c, err := rest.ReadByte()
+		if err == io.EOF {
+			return values, nil
+		}
+		if err != nil {
+			return fail(err)
+		}
+		if c != ' ' && c != '\t' && c != '\r' && c != '\n' {
+			return fail(fmt.Errorf("invalid character '%c' after top-level value", c))
+		}
+	}
+}
+
+// Passes input through while checking that it is valid UTF-8, failing with errInvalidUTF8
+// otherwise. A rune split between reads is checked once complete.
+type utf8Reader struct {
+	r       io.Reader
+	partial []byte // the incomplete rune at the end of the previous read
+}
+
+func (u *utf8Reader) Read(p []byte) (int, error) {
+	n, err := u.r.Read(p)
+	data := p[:n]
+	if len(u.partial) > 0 {
+		data = append(u.partial, data...)
+	}
+	u.partial = nil
+	for i := 0; i < len(data); {
+		if data[i] < utf8.RuneSelf {
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRune(data[i:])
+		if r == utf8.RuneError && size == 1 {
+			if !utf8.FullRune(data[i:]) && err == nil {
+				u.partial = append([]byte(nil), data[i:]...)
+				break
+			}
+			return 0, errInvalidUTF8
+		}
+		i += size
+	}
+	return n, err
+}
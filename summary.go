@@ -0,0 +1,146 @@
+package conversion
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SessionStats holds the session-level values derived from the documents of one session,
+// which no vendor file states directly.
+type SessionStats struct {
+	Acquisitions  int     `json:"acquisitions"`
+	Timed         int     `json:"timed_acquisitions"`          // acquisitions with a readable timestamp
+	First         string  `json:"first_acquisition,omitempty"` // RFC 3339
+	Last          string  `json:"last_acquisition,omitempty"`  // RFC 3339
+	DurationHours float64 `json:"duration_hours"`              // from the first to the last acquisition
+	ImagesPerHour float64 `json:"images_per_hour,omitempty"`   // timed acquisitions over the duration, unset for a single instant
+
+	first, last time.Time
+}
+
+// Counts one acquisition, and its timestamp unless it is the zero time.
+func (s *SessionStats) add(at time.Time) {
+	s.Acquisitions++
+	if at.IsZero() {
+		return
+	}
+	s.Timed++
+	if s.first.IsZero() || at.Before(s.first) {
+		s.first = at
+		s.First = at.Format(time.RFC3339)
+	}
+	if s.last.IsZero() || at.After(s.last) {
+		s.last = at
+		s.Last = at.Format(time.RFC3339)
+	}
+	duration := s.last.Sub(s.first).Hours()
+	s.DurationHours = roundTo(duration, 4)
+	s.ImagesPerHour = 0
+	if duration > 0 {
+		s.ImagesPerHour = roundTo(float64(s.Timed)/duration, 2)
+	}
+}
+
+// SessionSummary derives the values every facility report needs from the documents of a
+// batch, overall and per session: the number of acquisitions, the first and last
+// acquisition time and the throughput in images per hour.
+type SessionSummary struct {
+	Overall  SessionStats             `json:"overall"`
+	Sessions map[string]*SessionStats `json:"sessions"`
+
+	timePath []string
+}
+
+// Creates an empty summary reading the acquisition time of each document from the given
+// "." separated path, acquisition.date_time when empty.
+func NewSessionSummary(timePath string) *SessionSummary {
+	if timePath == "" {
+		timePath = "acquisition.date_time"
+	}
+	return &SessionSummary{Sessions: make(map[string]*SessionStats), timePath: strings.Split(timePath, ".")}
+}
+
+// Counts a converted document for the batch and its session. Documents without a readable
+// acquisition time count as acquisitions but leave the times and the throughput alone; an
+// empty session name leaves the document out of the sessions.
+func (s *SessionSummary) Add(doc []byte, session string) error {
+	at, err := documentTime(doc, s.timePath)
+	if err != nil {
+		return err
+	}
+	s.Overall.add(at)
+	if session == "" {
+		return nil
+	}
+	if s.Sessions[session] == nil {
+		s.Sessions[session] = &SessionStats{}
+	}
+	s.Sessions[session].add(at)
+	return nil
+}
+
+// Returns the acquisition time at a path of a converted document, or the zero time if it is
+// missing or unreadable.
+func documentTime(doc []byte, path []string) (time.Time, error) {
+	var data map[string]interface{}
+	if err := json.Unmarshal(doc, &data); err != nil {
+		return time.Time{}, fmt.Errorf("document is not valid JSON: %w", err)
+	}
+	value := lookupPath(data, path)
+	if m, ok := value.(map[string]interface{}); ok {
+		value = m["value"]
+	}
+	text, ok := value.(string)
+	if !ok {
+		if number, isNumber := value.(float64); isNumber {
+			text = strconv.FormatFloat(number, 'f', -1, 64)
+		}
+	}
+	at, _ := parseAcquisitionTime(text)
+	return at, nil
+}
+
+// Rounds a value to the given number of decimals, keeping summaries readable.
+func roundTo(value float64, decimals int) float64 {
+	scale := math.Pow(10, float64(decimals))
+	return math.Round(value*scale) / scale
+}
+
+// Timestamp layouts written by instruments, besides RFC 3339 and Unix times.
+var acquisitionTimeLayouts = []string{
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999",
+	"2006/01/02 15:04:05",
+	"02.01.2006 15:04:05",
+}
+
+// Parses an acquisition time as instruments write it: RFC 3339, a date and time without a
+// zone, taken as UTC, or a Unix time in seconds, milliseconds or microseconds.
+func parseAcquisitionTime(value string) (time.Time, bool) {
+	value = strings.TrimSpace(value)
+	if at, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return at, true
+	}
+	for _, layout := range acquisitionTimeLayouts {
+		if at, err := time.Parse(layout, value); err == nil {
+			return at, true
+		}
+	}
+	unix, err := strconv.ParseFloat(value, 64)
+	if err != nil || unix <= 0 {
+		return time.Time{}, false
+	}
+	// the magnitude tells the unit apart, as acquisitions are recent
+	switch {
+	case unix >= 1e15:
+		return time.UnixMicro(int64(unix)).UTC(), true
+	case unix >= 1e12:
+		return time.UnixMilli(int64(unix)).UTC(), true
+	default:
+		return time.Unix(int64(unix), 0).UTC(), true
+	}
+}
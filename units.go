@@ -0,0 +1,248 @@
+package conversion
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// A unit the crunch column can name instead of a numeric factor, given by its dimension
+// and the factor converting it to the base unit of that dimension.
+type unitDef struct {
+	Dimension string
+	Factor    float64
+}
+
+// Units known to the converter. A crunch cell naming one of them, e.g. "rad", converts values
+// from that unit to the unit of the row.
+var knownUnits = map[string]unitDef{
+	// angles, based on radians
+	"rad":     {"angle", 1},
+	"mrad":    {"angle", 1e-3},
+	"urad":    {"angle", 1e-6},
+	"µrad":    {"angle", 1e-6},
+	"deg":     {"angle", math.Pi / 180},
+	"°":       {"angle", math.Pi / 180},
+	"degree":  {"angle", math.Pi / 180},
+	"degrees": {"angle", math.Pi / 180},
+	// pressures, based on pascal
+	"Pa":    {"pressure", 1},
+	"mPa":   {"pressure", 1e-3},
+	"hPa":   {"pressure", 1e2},
+	"kPa":   {"pressure", 1e3},
+	"bar":   {"pressure", 1e5},
+	"mbar":  {"pressure", 1e2},
+	"Torr":  {"pressure", 101325.0 / 760},
+	"torr":  {"pressure", 101325.0 / 760},
+	"mTorr": {"pressure", 101325.0 / 760 / 1000},
+	"psi":   {"pressure", 6894.757293168},
+	// lengths, based on metres
+	"m":  {"length", 1},
+	"mm": {"length", 1e-3},
+	"um": {"length", 1e-6},
+	"µm": {"length", 1e-6},
+	"nm": {"length", 1e-9},
+	"pm": {"length", 1e-12},
+	"Å":  {"length", 1e-10},
+	// currents, based on amperes
+	"A":  {"current", 1},
+	"mA": {"current", 1e-3},
+	"uA": {"current", 1e-6},
+	"µA": {"current", 1e-6},
+	"nA": {"current", 1e-9},
+	"pA": {"current", 1e-12},
+	// times, based on seconds
+	"s":  {"time", 1},
+	"ms": {"time", 1e-3},
+	"us": {"time", 1e-6},
+	"µs": {"time", 1e-6},
+}
+
+// Resolves a crunch cell to a multiplication factor. Cells are either a number, or the name of
+// the unit the input is given in, which is converted to the unit of the row.
+//
+// Parameters:
+//   - crunch: The crunch cell, e.g. "1000" or "rad"
+//   - unit: The unit of the row the value is converted to
+//
+// Returns:
+//   - float64: The factor to multiply values by
+//   - error: If the cell is neither a number nor a unit convertible to the row's unit
+func crunchMultiplier(crunch string, unit string) (float64, error) {
+	fraction, err := crunchFraction(crunch, unit)
+	if err != nil {
+		return 0, err
+	}
+	factor, _ := fraction.Float64()
+	return factor, nil
+}
+
+// Resolves a crunch cell like crunchMultiplier, as the exact fraction of the decimals the
+// factor and the unit definitions are written in, e.g. 1/1000 from nm to µm, so converted
+// values carry no error of binary floating point.
+func crunchFraction(crunch string, unit string) (*big.Rat, error) {
+	if factor, err := strconv.ParseFloat(crunch, 64); err == nil {
+		fraction, ok := decimalFraction(factor)
+		if !ok {
+			return nil, fmt.Errorf("%q is not a finite number", crunch)
+		}
+		return fraction, nil
+	}
+	from, ok := knownUnits[normalizeUnit(crunch)]
+	if !ok {
+		return nil, fmt.Errorf("%q is neither a number nor a known unit", crunch)
+	}
+	to, ok := knownUnits[normalizeUnit(unit)]
+	if !ok {
+		return nil, fmt.Errorf("cannot convert from %s to the unknown unit %q", crunch, unit)
+	}
+	if from.Dimension != to.Dimension {
+		return nil, fmt.Errorf("cannot convert %s (%s) to %s (%s)", crunch, from.Dimension, unit, to.Dimension)
+	}
+	fromFraction, _ := decimalFraction(from.Factor)
+	toFraction, _ := decimalFraction(to.Factor)
+	return fromFraction.Quo(fromFraction, toFraction), nil
+}
+
+// Multiplies a value by a crunch factor, as the exact product of the decimals both are written
+// in rounded once to the nearest float, so 0.1*3 stays 0.3 and all digits of the value are kept.
+// Products of short decimals, such as values in nm converted to µm, are computed in floating
+// point, which is exact for them, and only the others through crunchFraction.
+//
+// Parameters:
+//   - x: The value
+//   - crunch: The crunch cell, see crunchMultiplier
+//   - unit: The unit of the row the value is converted to
+//
+// Returns:
+//   - float64: The converted value
+//   - error: If the cell is neither a number nor a unit convertible to the row's unit
+func crunchValue(x float64, crunch string, unit string) (float64, error) {
+	if mantissa, exp, ok := crunchDecimal(crunch, unit); ok {
+		if val, ok := exactDecimalProduct(x, mantissa, exp); ok {
+			return val, nil
+		}
+	}
+	fac, err := crunchFraction(crunch, unit)
+	if err != nil {
+		return 0, err
+	}
+	if operand, ok := decimalFraction(x); ok {
+		val, _ := operand.Mul(operand, fac).Float64()
+		return val, nil
+	}
+	factor, _ := fac.Float64()
+	return x * factor, nil
+}
+
+// Resolves a crunch cell like crunchFraction to a decimal mantissa·10^exp, without allocating.
+// It is false for cells whose factor is no such decimal, e.g. from degree to rad, and for cells
+// crunchFraction rejects.
+func crunchDecimal(crunch string, unit string) (int64, int, bool) {
+	from, ok := knownUnits[normalizeUnit(crunch)]
+	if !ok {
+		factor, err := strconv.ParseFloat(crunch, 64)
+		if err != nil || math.IsNaN(factor) || math.IsInf(factor, 0) {
+			return 0, 0, false
+		}
+		mantissa, exp := decimalParts(factor)
+		return mantissa, exp, true
+	}
+	to, ok := knownUnits[normalizeUnit(unit)]
+	if !ok || from.Dimension != to.Dimension {
+		return 0, 0, false
+	}
+	fromMantissa, fromExp := decimalParts(from.Factor)
+	toMantissa, toExp := decimalParts(to.Factor)
+	if fromMantissa%toMantissa != 0 {
+		return 0, 0, false
+	}
+	return fromMantissa / toMantissa, fromExp - toExp, true
+}
+
+// Returns the nearest float to the product of the shortest decimal of x and mantissa·10^exp
+// when floating point computes it exactly: the product of the mantissas is at most 2^53 and
+// the power of ten at most 10^22, both exact floats, so a single rounding step remains.
+func exactDecimalProduct(x float64, mantissa int64, exp int) (float64, bool) {
+	if math.IsNaN(x) || math.IsInf(x, 0) {
+		return 0, false
+	}
+	xMantissa, xExp := decimalParts(x)
+	negative := (xMantissa < 0) != (mantissa < 0)
+	hi, lo := bits.Mul64(absInt64(xMantissa), absInt64(mantissa))
+	if hi != 0 || lo > 1<<53 {
+		return 0, false
+	}
+	product := float64(lo)
+	switch exp += xExp; {
+	case exp >= 0 && exp <= 22:
+		product *= math.Pow10(exp)
+	case exp < 0 && exp >= -22:
+		product /= math.Pow10(-exp)
+	default:
+		return 0, false
+	}
+	if negative && product != 0 {
+		product = -product
+	}
+	return product, true
+}
+
+// Splits a finite float into the digits and exponent of the shortest decimal reading back as
+// it, x = mantissa·10^exp, e.g. 12345 and -6 for 0.012345.
+func decimalParts(x float64) (mantissa int64, exp int) {
+	var buf [32]byte
+	s := strconv.AppendFloat(buf[:0], x, 'e', -1, 64) // e.g. "-1.2345e-02"
+	i, negative := 0, s[0] == '-'
+	if negative {
+		i++
+	}
+	digits := 0
+	for ; s[i] != 'e'; i++ {
+		if s[i] != '.' {
+			mantissa = mantissa*10 + int64(s[i]-'0')
+			digits++
+		}
+	}
+	expSign := s[i+1]
+	for i += 2; i < len(s); i++ {
+		exp = exp*10 + int(s[i]-'0')
+	}
+	if expSign == '-' {
+		exp = -exp
+	}
+	if negative {
+		mantissa = -mantissa
+	}
+	return mantissa, exp - (digits - 1)
+}
+
+// Returns the magnitude of n.
+func absInt64(n int64) uint64 {
+	if n < 0 {
+		return uint64(-n)
+	}
+	return uint64(n)
+}
+
+// Returns the shortest decimal reading back as x, e.g. 0.1 for the float nearest to it, as an
+// exact fraction. It is false for NaN and infinities.
+func decimalFraction(x float64) (*big.Rat, bool) {
+	return new(big.Rat).SetString(strconv.FormatFloat(x, 'g', -1, 64))
+}
+
+// Spells a unit the way knownUnits does. The mapping tables write Å as the angstrom sign
+// U+212B and some vendors write µ as the Greek letter mu, which look the same but differ.
+func normalizeUnit(unit string) string {
+	unit = strings.TrimSpace(unit)
+	if !strings.ContainsAny(unit, "\u212b\u03bc") {
+		// most units need no respelling, which spares the replacer's allocations per value
+		return unit
+	}
+	return unitSpellings.Replace(unit)
+}
+
+var unitSpellings = strings.NewReplacer("\u212b", "\u00c5", "\u03bc", "\u00b5")
@@ -0,0 +1,103 @@
+package conversion
+
+import (
+	"math"
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+func TestUnitCrunch(t *testing.T) {
+	tests := []struct {
+		value, factor, unit string
+		want                string
+	}{
+		{"0.1", "3", "", "0.3"},
+		{"4.1501527908716085E-11", "10000000000", "Å", "0.41501527908716085"},
+		{"300000", "0.001", "kV", "300"},
+		{"1.5", "mm", "µm", "1500"},
+		{"2", "nm", "µm", "0.002"},
+		{"4,096", "0.001", "", "4.096"},
+		{"-1,000,000.5", "2", "", "-2000001"},
+		{"1.5 mm", "1000", "", "1500"},
+	}
+	for _, tt := range tests {
+		got, err := unitCrunch(tt.value, tt.factor, tt.unit)
+		if err != nil {
+			t.Errorf("unitCrunch(%q, %q, %q): %v", tt.value, tt.factor, tt.unit, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("unitCrunch(%q, %q, %q) = %q, want %q", tt.value, tt.factor, tt.unit, got, tt.want)
+		}
+	}
+}
+
+func TestUnitCrunchErrors(t *testing.T) {
+	for _, tt := range []struct{ value, factor, unit string }{
+		{"n/a", "1000", ""},
+		{"1", "furlong", "m"},
+		{"1", "nm", "s"},
+		{"1", "Inf", ""},
+	} {
+		if got, err := unitCrunch(tt.value, tt.factor, tt.unit); err == nil || got != tt.value {
+			t.Errorf("unitCrunch(%q, %q, %q) = %q, %v; want the value back and an error", tt.value, tt.factor, tt.unit, got, err)
+		}
+	}
+}
+
+// Converting a value and back by the inverse factor returns its digits unchanged.
+func TestUnitCrunchRoundTrip(t *testing.T) {
+	conversions := []struct{ factor, unit, inverse, inverseUnit string }{
+		{"10000000000", "Å", "1e-10", "m"},
+		{"0.001", "", "1000", ""},
+		{"4", "", "0.25", ""},
+		{"mm", "µm", "µm", "mm"},
+		{"nA", "pA", "pA", "nA"},
+	}
+	for _, value := range []string{"0.4150152790871608", "4.1501527908716085e-11", "300", "0.1", "123456.789", "-2.5e-7", "6.02214076e+23"} {
+		want, _ := strconv.ParseFloat(value, 64)
+		for _, c := range conversions {
+			converted, err := unitCrunch(value, c.factor, c.unit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			back, err := unitCrunch(converted, c.inverse, c.inverseUnit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, _ := strconv.ParseFloat(back, 64); got != want {
+				t.Errorf("%s by %s%s is %s, and back %s, want %s", value, c.factor, c.unit, converted, back, value)
+			}
+		}
+	}
+}
+
+// The floating point products of short decimals equal the exact products rounded once.
+func TestCrunchValueFastPath(t *testing.T) {
+	values := []float64{0, 1, -1, 0.1, 0.3, 300, 4096, 0.4150152790871608, 123456.789, -2.5e-7, 6.02214076e+23, 9007199254740993, 5e-324}
+	random := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		values = append(values, math.Round(random.NormFloat64()*1e6)/math.Pow10(random.Intn(12)))
+	}
+	for _, crunch := range []string{"1000", "0.001", "1e-10", "2.5", "-4", "nm", "mm", "mrad", "deg", "ms"} {
+		unit := map[string]string{"nm": "µm", "mm": "Å", "mrad": "rad", "deg": "rad", "ms": "µs"}[crunch]
+		fac, err := crunchFraction(crunch, unit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, x := range values {
+			got, err := crunchValue(x, crunch, unit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			operand, _ := decimalFraction(x)
+			if want, _ := operand.Mul(operand, fac).Float64(); got != want {
+				t.Errorf("%v by %s%s: got %v, want %v", x, crunch, unit, got, want)
+			}
+		}
+	}
+	if allocs := testing.AllocsPerRun(100, func() { crunchValue(0.4150152790871608, "nm", "µm") }); allocs > 0 {
+		t.Errorf("converting from nm to µm allocates %v times, want 0", allocs)
+	}
+}
@@ -0,0 +1,74 @@
+package conversion
+
+import (
+	"fmt"
+
+	"github.com/osc-em/oscem-converter-extracted/basetypes"
+)
+
+// Representations of typed values in the output, selected by Options.ValueStyle.
+const (
+	ValueStyleDefault = ""       // numbers with a unit as {value, unit} objects, everything else bare
+	ValueStyleBare    = "bare"   // every value as a bare JSON primitive, dropping units
+	ValueStyleObject  = "object" // numbers always as {value, unit} objects, unit omitted when there is none
+)
+
+// Rejects value styles the converter does not know.
+func checkValueStyle(style string) error {
+	switch style {
+	case ValueStyleDefault, ValueStyleBare, ValueStyleObject:
+		return nil
+	}
+	return fmt.Errorf("unknown value style %q, expected %s or %s", style, ValueStyleBare, ValueStyleObject)
+}
+
+// Replaces the typed values of a cleaned document by their representation in the given style.
+// The default style is left to the basetypes' own JSON marshalling.
+//
+// Parameters:
+//   - data: The cleaned document, or a part of it
+//   - style: One of the ValueStyle constants
+//
+// Returns:
+//   - interface{}: The document with typed values replaced
+func styleValues(data interface{}, style string) interface{} {
+	if style == ValueStyleDefault {
+		return data
+	}
+	switch v := data.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			v[key] = styleValues(value, style)
+		}
+		return v
+	case []interface{}:
+		for i, elem := range v {
+			v[i] = styleValues(elem, style)
+		}
+		return v
+	case basetypes.Int:
+		return styleNumber(v.Value, v.Unit, style)
+	case basetypes.Float64:
+		return styleNumber(v.Value, v.Unit, style)
+	case basetypes.Bool:
+		return v.Value
+	case basetypes.String:
+		return v.Value
+	default:
+		return v
+	}
+}
+
+// Represents a number with its unit in the given non-default style.
+func styleNumber(value interface{}, unit string, style string) interface{} {
+	if style == ValueStyleBare {
+		return value
+	}
+	return valueObject{Value: value, Unit: unit}
+}
+
+// A number with its unit in the object style, keeping the key order of the default style.
+type valueObject struct {
+	Value interface{} `json:"value"`
+	Unit  string      `json:"unit,omitempty"`
+}
@@ -0,0 +1,52 @@
+package conversion
+
+import (
+	"fmt"
+	"os"
+)
+
+// Kinds of conversion warnings.
+const (
+	WarnUnitConversion = "unit_conversion" // a crunch factor could not be applied, the raw value was kept
+	WarnOverwrite      = "overwrite"       // a mapping row replaced a different value written by an earlier row
+	WarnChecksum       = "checksum"        // a referenced data file could not be read for its checksum
+	WarnLossyCast      = "lossy_cast"      // a value did not fit its type and was truncated or replaced by 0
+	WarnUnknownType    = "unknown_type"    // a mapping row names a type the converter does not know
+	WarnDerived        = "derived"         // a value missing from the input was derived from others, or could not be
+	WarnNearMiss       = "near_miss"       // a source key was not found, but an input key differing only in case or whitespace was
+	WarnListConflict   = "list_conflict"   // the entries of a ";"-separated list mapped to a single field hold different values
+	WarnNoMatch        = "no_match"        // a source value does not match the extract pattern of its row and was dropped
+	WarnReference      = "reference"       // the input references an array element, such as the detector in use, that is not in the array
+	WarnExistingOutput = "existing_output" // the output file existed and was replaced, see Options.Force and Options.NoClobber
+	WarnMalformedRow   = "malformed_row"   // a row of the mapping file was malformed and skipped, padded or cut to the header
+	WarnDuplicateRows  = "duplicate_rows"  // two mapping rows active together target the same path, the later one overwriting the earlier
+	WarnEnrichment     = "enrichment"      // an enricher found no record for the identifier of the input and added nothing
+)
+
+// A Warning reports a problem that did not stop the conversion but may have left a value
+// missing or wrong. With Options.Strict, any warning fails the conversion instead.
+type Warning struct {
+	Code    string // one of the Warn constants
+	Path    string // affected output path, if any
+	Row     int    // line of the mapping row involved, 0 if unknown
+	Message string
+}
+
+func (w Warning) String() string {
+	if w.Path == "" {
+		return w.Message
+	}
+	return fmt.Sprintf("%s: %s", w.Path, w.Message)
+}
+
+// Records a warning of the current conversion.
+func (c *converter) warn(w Warning) {
+	c.warnings = append(c.warnings, w)
+}
+
+// Prints warnings to stderr, for callers of the APIs that only return the document.
+func printWarnings(warnings []Warning) {
+	for _, w := range warnings {
+		fmt.Fprintln(os.Stderr, "Warning:", w)
+	}
+}